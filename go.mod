@@ -3,9 +3,12 @@ module github.com/browsefile/backend
 require (
 	github.com/GeertJohan/go.rice v1.0.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.3.0
 	github.com/maruel/natural v0.0.0-20180416170133-dbcb3e2e8cf1
 	github.com/pkg/errors v0.8.1
-	golang.org/x/crypto v0.0.0-20190510104115-cbcb75029529
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
 	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
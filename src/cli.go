@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/preview"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//administrative sub command, like "user add"
+type command struct {
+	usage string
+	//works with config file directly, running server picks changes up by hot reload
+	local func(cfg *config.GlobalConfig, args []string) error
+	//works with running server over admin API, nil if not supported
+	remote func(cl *client, args []string) error
+	//do not load config before local run
+	noLoad bool
+}
+
+var commands = map[string]map[string]*command{
+	"user": {
+		"add":    {usage: "[-admin] [-edit] [-new] <name> [password]", local: userAdd, remote: userAddRemote},
+		"del":    {usage: "<name>", local: userDel, remote: userDelRemote},
+		"list":   {usage: "", local: userList, remote: userListRemote},
+		"passwd": {usage: "<name> [password]", local: userPasswd, remote: userPasswdRemote},
+	},
+	"share": {
+		"add":  {usage: "[-local] [-external] [-drop] [-users a,b] [-groups a,b] [-expires 24h] [-max-downloads n] <owner> <path>", local: shareAdd},
+		"del":  {usage: "<owner> <path>", local: shareDel},
+		"list": {usage: "[owner]", local: shareList},
+	},
+	"group": {
+		"set":  {usage: "<name> [user...]", local: groupSet},
+		"del":  {usage: "<name>", local: groupDel},
+		"list": {usage: "", local: groupList},
+	},
+	"config": {
+		"validate": {usage: "", local: configValidate, noLoad: true},
+		"print":    {usage: "", local: configPrint, remote: configPrintRemote},
+	},
+	"preview": {
+		"rebuild": {usage: "[user]", local: previewRebuild},
+	},
+	"key": {
+		"rotate": {usage: "", local: keyRotate},
+	},
+}
+
+//true in case argument is administrative command name
+func isCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+//print commands help
+func commandsUsage(w io.Writer) {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "Commands, -remote http://host:port -login user:password runs them against running server:")
+	for _, name := range names {
+		var subs []string
+		for sub := range commands[name] {
+			subs = append(subs, sub)
+		}
+		sort.Strings(subs)
+		for _, sub := range subs {
+			cmd := commands[name][sub]
+			remote := ""
+			if cmd.remote != nil {
+				remote = " (remote)"
+			}
+			fmt.Fprintf(w, "  %s %s %s%s\n", name, sub, cmd.usage, remote)
+		}
+	}
+}
+
+//run administrative command, args starts from command name
+func runCommand(cfg *config.GlobalConfig, remote, login string, args []string) error {
+	if len(args) < 2 {
+		return errors.New("sub command required for " + args[0])
+	}
+	cmd, ok := commands[args[0]][args[1]]
+	if !ok {
+		return fmt.Errorf("unknown command %s %s", args[0], args[1])
+	}
+	if len(remote) > 0 {
+		if cmd.remote == nil {
+			return fmt.Errorf("%s %s is not supported against running server", args[0], args[1])
+		}
+		cl, err := newClient(remote, login)
+		if err != nil {
+			return err
+		}
+		return cmd.remote(cl, args[2:])
+	}
+	if !cmd.noLoad {
+		cfg.ReadConfigFile()
+	}
+
+	return cmd.local(cfg, args[2:])
+}
+
+//password from arguments or first line of stdin
+func readPassword(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	fmt.Fprint(os.Stderr, "password: ")
+	p, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	p = strings.TrimRight(p, "\r\n")
+	if len(p) == 0 {
+		if err == nil || err == io.EOF {
+			err = cnst.ErrEmptyPassword
+		}
+		return "", err
+	}
+	return p, nil
+}
+
+func parseUserAdd(args []string) (*config.UserConfig, string, error) {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	u := &config.UserConfig{ViewMode: cnst.MosaicViewMode, Locale: "en"}
+	fs.BoolVar(&u.Admin, "admin", false, "administrator")
+	fs.BoolVar(&u.AllowEdit, "edit", false, "allow edit and rename files")
+	fs.BoolVar(&u.AllowNew, "new", false, "allow create files and folders")
+	if err := fs.Parse(args); err != nil {
+		return nil, "", err
+	}
+	if fs.NArg() < 1 {
+		return nil, "", cnst.ErrEmptyUsername
+	}
+	u.Username = fs.Arg(0)
+	pwd, err := readPassword(fs.Args()[1:])
+
+	return u, pwd, err
+}
+
+func userAdd(cfg *config.GlobalConfig, args []string) error {
+	u, pwd, err := parseUserAdd(args)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.GetUserByUsername(u.Username); ok {
+		return cnst.ErrExist
+	}
+	if u.Password, err = lib.HashPassword(pwd); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(cfg.GetUserHomePath(u.Username), cnst.PERM_DEFAULT); err != nil {
+		return err
+	}
+
+	return cfg.AddUser(u)
+}
+
+func userDel(cfg *config.GlobalConfig, args []string) error {
+	if len(args) < 1 {
+		return cnst.ErrEmptyUsername
+	}
+	if _, ok := cfg.GetUserByUsername(args[0]); !ok {
+		return cnst.ErrNotExist
+	}
+	return cfg.DeleteUser(args[0])
+}
+
+func printUsers(users []*config.UserConfig) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tADMIN\tEDIT\tNEW\tSHARES")
+	for _, u := range users {
+		fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%d\n", u.Username, u.Admin, u.AllowEdit, u.AllowNew, len(u.Shares))
+	}
+	_ = w.Flush()
+}
+
+func userList(cfg *config.GlobalConfig, args []string) error {
+	printUsers(cfg.GetUsers())
+	return nil
+}
+
+func userPasswd(cfg *config.GlobalConfig, args []string) error {
+	if len(args) < 1 {
+		return cnst.ErrEmptyUsername
+	}
+	u, ok := cfg.GetUserByUsername(args[0])
+	if !ok {
+		return cnst.ErrNotExist
+	}
+	pwd, err := readPassword(args[1:])
+	if err != nil {
+		return err
+	}
+	if u.Password, err = lib.HashPassword(pwd); err != nil {
+		return err
+	}
+	return cfg.UpdatePassword(u)
+}
+
+func shareAdd(cfg *config.GlobalConfig, args []string) error {
+	fs := flag.NewFlagSet("share add", flag.ContinueOnError)
+	shr := &config.ShareItem{}
+	users := fs.String("users", "", "comma separated users allowed to access share")
+	groups := fs.String("groups", "", "comma separated groups allowed to access share")
+	fs.BoolVar(&shr.AllowLocal, "local", false, "allow all registered users")
+	fs.BoolVar(&shr.AllowExternal, "external", false, "allow access by external link")
+	expires := fs.Duration("expires", 0, "delete share after this time")
+	fs.IntVar(&shr.MaxDownloads, "max-downloads", 0, "delete share after this number of downloads")
+	drop := fs.Bool("drop", false, "external link only accepts uploads, content is not shown")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *drop {
+		shr.Drop = &config.DropConfig{}
+	}
+	if *expires > 0 {
+		t := time.Now().Add(*expires)
+		shr.ExpiresAt = &t
+	}
+	if err := shr.Validate(); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errors.New("owner and path required")
+	}
+	if len(*users) > 0 {
+		shr.AllowUsers = strings.Split(*users, ",")
+	}
+	if len(*groups) > 0 {
+		shr.AllowGroups = strings.Split(*groups, ",")
+	}
+	u, ok := cfg.GetUserByUsername(fs.Arg(0))
+	if !ok {
+		return cnst.ErrNotExist
+	}
+	shr.Path = "/" + strings.Trim(fs.Arg(1), "/")
+	if _, err := os.Stat(filepath.Join(cfg.GetUserHomePath(u.Username), shr.Path)); err != nil {
+		return err
+	}
+	//links of existing share stay valid
+	if old := u.GetShares(shr.Path, false); old != nil {
+		shr.Links = old[0].Links
+		if !u.DeleteShare(shr.Path) {
+			return cnst.ErrExist
+		}
+	}
+	if !u.AddShare(shr) {
+		return cnst.ErrExist
+	}
+	if err := cfg.Update(u); err != nil {
+		return err
+	}
+	if shr.AllowExternal {
+		fmt.Println(shareLinks(cfg, shr))
+	}
+	return nil
+}
+
+func shareDel(cfg *config.GlobalConfig, args []string) error {
+	if len(args) < 2 {
+		return errors.New("owner and path required")
+	}
+	u, ok := cfg.GetUserByUsername(args[0])
+	if !ok {
+		return cnst.ErrNotExist
+	}
+	if !u.DeleteShare("/" + strings.Trim(args[1], "/")) {
+		return cnst.ErrNotExist
+	}
+	return cfg.Update(u)
+}
+
+//urls of all external links
+func shareLinks(cfg *config.GlobalConfig, shr *config.ShareItem) string {
+	res := make([]string, len(shr.Links))
+	for i, l := range shr.Links {
+		res[i] = cfg.ShareLinkURL(shr, l.Token)
+	}
+	return strings.Join(res, " ")
+}
+
+func shareList(cfg *config.GlobalConfig, args []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "OWNER\tPATH\tLOCAL\tUSERS\tGROUPS\tEXPIRES\tEXTERNAL")
+	for _, u := range cfg.GetUsers() {
+		if len(args) > 0 && u.Username != args[0] {
+			continue
+		}
+		for _, shr := range u.Shares {
+			ex := ""
+			if shr.AllowExternal {
+				ex = shareLinks(cfg, shr)
+			}
+			exp := ""
+			if shr.ExpiresAt != nil {
+				exp = shr.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\t%s\t%s\n", u.Username, shr.Path, shr.AllowLocal, strings.Join(shr.AllowUsers, ","), strings.Join(shr.AllowGroups, ","), exp, ex)
+		}
+	}
+	return w.Flush()
+}
+
+func groupSet(cfg *config.GlobalConfig, args []string) error {
+	if len(args) < 1 {
+		return errors.New("group name required")
+	}
+	for _, u := range args[1:] {
+		if _, ok := cfg.GetUserByUsername(u); !ok {
+			return fmt.Errorf("user %s : %v", u, cnst.ErrNotExist)
+		}
+	}
+	return cfg.SetGroup(&config.Group{Name: args[0], Users: args[1:]})
+}
+
+func groupDel(cfg *config.GlobalConfig, args []string) error {
+	if len(args) < 1 {
+		return errors.New("group name required")
+	}
+	return cfg.DeleteGroup(args[0])
+}
+
+func groupList(cfg *config.GlobalConfig, args []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tUSERS")
+	for _, g := range cfg.GetGroups() {
+		fmt.Fprintf(w, "%s\t%s\n", g.Name, strings.Join(g.Users, ","))
+	}
+	return w.Flush()
+}
+
+//config file path, in case it was not set explicitly
+func findConfigPath(cfg *config.GlobalConfig) string {
+	if len(cfg.Path) > 0 {
+		return cfg.Path
+	}
+	for _, p := range []string{cnst.FilePath1, cnst.FilePath2} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return cnst.FilePath1
+}
+
+func configValidate(cfg *config.GlobalConfig, args []string) error {
+	cfg.Path = findConfigPath(cfg)
+	if err := cfg.Check(); err != nil {
+		return err
+	}
+	fmt.Println(cfg.Path, "is valid")
+	return nil
+}
+
+//effective config as served by settings API
+type effectiveConfig struct {
+	Config *config.GlobalConfig  `json:"config"`
+	Values []*config.ConfigValue `json:"values"`
+}
+
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func configPrint(cfg *config.GlobalConfig, args []string) error {
+	return printJSON(&effectiveConfig{cfg.CopyConfig(), cfg.EffectiveValues()})
+}
+
+func previewRebuild(cfg *config.GlobalConfig, args []string) error {
+	users := cfg.GetUsers()
+	if len(args) > 0 {
+		u, ok := cfg.GetUserByUsername(args[0])
+		if !ok {
+			return cnst.ErrNotExist
+		}
+		users = []*config.UserConfig{u}
+	}
+	pg := new(preview.PreviewGen)
+	pg.Setup(0, cfg.ScriptPath)
+	for _, u := range users {
+		pp := cfg.GetUserPreviewPath(u.Username)
+		if err := os.RemoveAll(pp); err != nil {
+			return err
+		}
+		fmt.Println("generating previews for", u.Username)
+		pg.ProcessPath(cfg.GetUserHomePath(u.Username), pp)
+	}
+	return nil
+}
+
+//new salt key, all issued tokens become invalid
+func keyRotate(cfg *config.GlobalConfig, args []string) error {
+	k, err := lib.GenerateRandomBytes(64)
+	if err != nil {
+		return err
+	}
+	cfg.SetKey(k)
+	cfg.WriteConfig()
+	return nil
+}
+
+//admin API client for running server
+type client struct {
+	host     string
+	username string
+	token    string
+}
+
+//login into running server, login in form user:password
+func newClient(host, login string) (*client, error) {
+	cl := &client{host: strings.TrimSuffix(host, "/")}
+	i := strings.Index(login, ":")
+	if i < 0 {
+		return nil, errors.New("login should be in form user:password")
+	}
+	cl.username = login[:i]
+	b, err := json.Marshal(map[string]string{"username": cl.username, "password": login[i+1:]})
+	if err != nil {
+		return nil, err
+	}
+	tk, err := cl.do(http.MethodPost, "/auth/get", b, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	cl.token = string(tk)
+
+	return cl, nil
+}
+
+//make api request, error in case response status differs from expected
+func (cl *client) do(method, p string, body []byte, status int) ([]byte, error) {
+	req, err := http.NewRequest(method, cl.host+"/api"+p, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(cl.token) > 0 {
+		req.Header.Set(cnst.H_XAUTH, cl.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != status {
+		return nil, fmt.Errorf("%s %s : %s %s", method, p, resp.Status, strings.TrimSpace(string(res)))
+	}
+	return res, nil
+}
+
+func (cl *client) modifyUser(method, p string, which string, u *config.UserConfig, status int) error {
+	b, err := json.Marshal(map[string]interface{}{"what": "user", "which": which, "data": u})
+	if err != nil {
+		return err
+	}
+	_, err = cl.do(method, p, b, status)
+	return err
+}
+
+func userAddRemote(cl *client, args []string) error {
+	u, pwd, err := parseUserAdd(args)
+	if err != nil {
+		return err
+	}
+	u.Password = pwd
+	return cl.modifyUser(http.MethodPost, "/users/", "", u, http.StatusCreated)
+}
+
+func userDelRemote(cl *client, args []string) error {
+	if len(args) < 1 {
+		return cnst.ErrEmptyUsername
+	}
+	_, err := cl.do(http.MethodDelete, "/users/"+args[0], nil, http.StatusOK)
+	return err
+}
+
+func userListRemote(cl *client, args []string) error {
+	b, err := cl.do(http.MethodGet, "/users/", nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var users []*config.UserConfig
+	if err = json.Unmarshal(b, &users); err != nil {
+		return err
+	}
+	printUsers(users)
+	return nil
+}
+
+//users API changes password of the logged in user only
+func userPasswdRemote(cl *client, args []string) error {
+	if len(args) < 1 {
+		return cnst.ErrEmptyUsername
+	}
+	if args[0] != cl.username {
+		return errors.New("only password of the logged in user can be changed remotely")
+	}
+	pwd, err := readPassword(args[1:])
+	if err != nil {
+		return err
+	}
+	u := &config.UserConfig{Username: args[0], Password: pwd}
+	return cl.modifyUser(http.MethodPut, "/users/"+args[0], "password", u, http.StatusOK)
+}
+
+func configPrintRemote(cl *client, args []string) error {
+	b, err := cl.do(http.MethodGet, "/settings/effective", nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err = json.Indent(&out, b, "", "    "); err != nil {
+		return err
+	}
+	fmt.Println(out.String())
+	return nil
+}
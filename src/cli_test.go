@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib"
+	"os"
+	"testing"
+)
+
+//run local command against fresh config, read from disk
+func runLocal(t *testing.T, p string, args ...string) (*config.GlobalConfig, error) {
+	cfg := &config.GlobalConfig{Path: p}
+	err := runCommand(cfg, "", "", args)
+	return cfg, err
+}
+
+func TestCommandUsers(t *testing.T) {
+	tc := config.TContext{}
+	tc.InitWithUsers(t)
+	defer tc.Clean(t)
+	tc.WriteConfig()
+
+	if _, err := runLocal(t, tc.Path, "user", "add", "-edit", "user3", "pwd3"); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := runLocal(t, tc.Path, "user", "add", "user3", "pwd3")
+	if err == nil {
+		t.Error("duplicate user must fail")
+	}
+	u, ok := cfg.GetUserByUsername("user3")
+	if !ok || !u.AllowEdit || !lib.CheckPasswordHash("pwd3", u.Password) {
+		t.Fatal("user3 must be stored with hashed password", u)
+	}
+	if _, err = os.Stat(cfg.GetUserHomePath("user3")); err != nil {
+		t.Error("user3 home must be created", err)
+	}
+
+	if _, err = runLocal(t, tc.Path, "user", "passwd", "user3", "newpwd"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = runLocal(t, tc.Path, "user", "del", "user3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = cfg.GetUserByUsername("user3"); ok {
+		t.Error("user3 must be deleted")
+	}
+}
+
+func TestCommandShares(t *testing.T) {
+	tc := config.TContext{}
+	tc.InitWithUsers(t)
+	defer tc.Clean(t)
+	tc.WriteConfig()
+
+	if _, err := runLocal(t, tc.Path, "share", "add", "-users", "user2", "user1", "/not-exists"); err == nil {
+		t.Error("share of missed path must fail")
+	}
+	cfg, err := runLocal(t, tc.Path, "share", "add", "-users", "user2", "user1", tc.SharePathDeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := cfg.GetUserByUsername("user1")
+	if len(u.Shares) != 1 || !u.Shares[0].IsAllowed("user2") {
+		t.Fatal("share must be added for user2", u.Shares)
+	}
+	if cfg, err = runLocal(t, tc.Path, "share", "del", "user1", tc.SharePathDeep); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ = cfg.GetUserByUsername("user1"); len(u.Shares) != 0 {
+		t.Error("share must be deleted", u.Shares)
+	}
+}
+
+func TestCommandConfig(t *testing.T) {
+	tc := config.TContext{}
+	tc.InitWithUsers(t)
+	defer tc.Clean(t)
+	tc.WriteConfig()
+
+	if _, err := runLocal(t, tc.Path, "config", "validate"); err != nil {
+		t.Fatal(err)
+	}
+	k, _ := tc.GetKeyBytes()
+	cfg, err := runLocal(t, tc.Path, "key", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nk, _ := cfg.GetKeyBytes(); len(nk) == 0 || string(nk) == string(k) {
+		t.Error("key must be changed")
+	}
+	if _, err = runLocal(t, tc.Path, "config", "unknown"); err == nil {
+		t.Error("unknown command must fail")
+	}
+}
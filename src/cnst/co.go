@@ -10,6 +10,9 @@ var (
 	H_XAUTH        = "X-Auth"
 	P_PREVIEW_TYPE = "previewType"
 	P_EXSHARE      = "exshare"
+	//token that unlocks password protected external share
+	H_SHARE_AUTH = "X-Share-Auth"
+	P_SHARE_AUTH = "shareAuth"
 )
 var (
 	// Version is the current File Browser version.
@@ -90,9 +93,19 @@ func ErrorToHTTP(err error, gone bool) int {
 	switch {
 	case err == nil:
 		return http.StatusOK
+	case err == ErrQuotaExceeded || err == ErrDropFull:
+		return http.StatusInsufficientStorage
+	case err == ErrTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case err == ErrShareAccess || err == ErrWrongCode:
+		return http.StatusForbidden
+	case err == ErrShareExpired:
+		return http.StatusGone
+	case err == ErrShareLocked:
+		return http.StatusUnauthorized
 	case os.IsPermission(err):
 		return http.StatusForbidden
-	case os.IsNotExist(err):
+	case os.IsNotExist(err) || err == ErrNotExist:
 		if !gone {
 			return http.StatusNotFound
 		}
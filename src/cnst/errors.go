@@ -13,4 +13,10 @@ var (
 	ErrInvalidOption = errors.New("invalid option")
 	ErrWrongDataType = errors.New("wrong data type")
 	ErrShareAccess   = errors.New("share not allowed")
+	ErrShareExpired  = errors.New("share expired")
+	ErrShareLocked   = errors.New("share password required")
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	ErrDropFull      = errors.New("file drop is full")
+	ErrTooLarge      = errors.New("upload is too large")
+	ErrWrongCode     = errors.New("wrong verification code")
 )
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/lib/utils"
+	"os"
+	"strings"
+)
+
+//modes of path rules
+const (
+	//files can be read, but not created, modified or deleted
+	RULE_READ_ONLY = "readonly"
+	//files can be only created, content is not listed or read
+	RULE_UPLOAD_ONLY = "uploadonly"
+	//never listed, read, modified or shared
+	RULE_HIDDEN = "hidden"
+)
+
+//restricts access to path inside user home, and everything below it
+type PathRule struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+}
+
+//rules are immutable after set to the user, so can be shared between copies
+type PathRules []*PathRule
+
+func isRuleMode(m string) bool {
+	switch m {
+	case RULE_READ_ONLY, RULE_UPLOAD_ONLY, RULE_HIDDEN:
+		return true
+	}
+	return false
+}
+
+func (r PathRules) copyRules() (res PathRules) {
+	if len(r) == 0 {
+		return nil
+	}
+	res = make(PathRules, len(r))
+	for i, rule := range r {
+		res[i] = &PathRule{Path: utils.SlashClean(rule.Path), Mode: rule.Mode}
+	}
+	return res
+}
+
+//cnst.ErrInvalidOption in case rule has empty path or unknown mode
+func (r PathRules) Validate() error {
+	for _, rule := range r {
+		if rule == nil || len(rule.Path) == 0 || !isRuleMode(rule.Mode) {
+			return cnst.ErrInvalidOption
+		}
+	}
+	return nil
+}
+
+//true in case rule path is p or any of p parents
+func (rule *PathRule) covers(p string) bool {
+	rp := utils.SlashClean(rule.Path)
+	return rp == "/" || p == rp || strings.HasPrefix(p, rp+"/")
+}
+
+//mode of the most specific rule that covers p, empty in case p not restricted
+func (r PathRules) Mode(p string) (res string) {
+	p = utils.SlashClean(p)
+	l := -1
+	for _, rule := range r {
+		if l2 := len(utils.SlashClean(rule.Path)); rule.covers(p) && l2 > l {
+			res, l = rule.Mode, l2
+		}
+	}
+	return res
+}
+
+//true in case any rule restricts path below p
+func (r PathRules) hasBelow(p string, modes ...string) bool {
+	p = strings.TrimSuffix(utils.SlashClean(p), "/")
+	for _, rule := range r {
+		rp := utils.SlashClean(rule.Path)
+		if rp != p && strings.HasPrefix(rp, p+"/") {
+			for _, m := range modes {
+				if rule.Mode == m {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (r PathRules) IsHidden(p string) bool {
+	return r.Mode(p) == RULE_HIDDEN
+}
+
+//error in case p can't be listed or read, hidden paths reported as not existing
+func (r PathRules) CheckRead(p string) error {
+	switch r.Mode(p) {
+	case RULE_HIDDEN:
+		return os.ErrNotExist
+	case RULE_UPLOAD_ONLY:
+		return os.ErrPermission
+	}
+	return nil
+}
+
+//same as CheckRead, but also fails in case anything below p is not readable, used when whole tree is read at once
+func (r PathRules) CheckReadAll(p string) error {
+	if err := r.CheckRead(p); err != nil {
+		return err
+	}
+	if r.hasBelow(p, RULE_HIDDEN, RULE_UPLOAD_ONLY) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+//error in case new file or folder can't be created at p
+func (r PathRules) CheckCreate(p string) error {
+	switch r.Mode(p) {
+	case RULE_READ_ONLY, RULE_HIDDEN:
+		return os.ErrPermission
+	}
+	return nil
+}
+
+//error in case p can't be modified, renamed or deleted with everything below it
+func (r PathRules) CheckWrite(p string) error {
+	if len(r.Mode(p)) > 0 || r.hasBelow(p, RULE_READ_ONLY, RULE_UPLOAD_ONLY, RULE_HIDDEN) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+//path rules of the share owner and path at owner home, for url at shares(/owner/name_hash/sub)
+//or external shares(/name_hash/sub). Returns nil rules in case url is not inside any share
+func (cfg *GlobalConfig) SharePathRules(url string, isEx bool) (PathRules, string) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	shr, u, p := cfg.findShare(url, isEx)
+	if shr == nil {
+		return nil, p
+	}
+	return u.Rules, p
+}
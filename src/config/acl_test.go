@@ -0,0 +1,61 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPathRules(t *testing.T) {
+	r := PathRules{
+		{Path: "/archive", Mode: RULE_READ_ONLY},
+		{Path: "/archive/drop/", Mode: RULE_UPLOAD_ONLY},
+		{Path: "/private", Mode: RULE_HIDDEN},
+	}
+	if r.Validate() != nil {
+		t.Fatal("rules must be valid")
+	}
+	if (PathRules{{Path: "/x", Mode: "bad"}}).Validate() == nil || (PathRules{{Mode: RULE_HIDDEN}}).Validate() == nil {
+		t.Error("unknown mode and empty path must be rejected")
+	}
+	for p, m := range map[string]string{"/": "", "/archive": RULE_READ_ONLY, "/archive/a.txt": RULE_READ_ONLY,
+		"/archive/drop/x": RULE_UPLOAD_ONLY, "/private/": RULE_HIDDEN, "/privateer": ""} {
+		if r.Mode(p) != m {
+			t.Errorf("wrong mode of %s, got %s", p, r.Mode(p))
+		}
+	}
+	if r.CheckRead("/private/a") != os.ErrNotExist || r.CheckRead("/archive/drop") != os.ErrPermission || r.CheckRead("/archive") != nil {
+		t.Error("wrong read access")
+	}
+	if r.CheckCreate("/archive/a") == nil || r.CheckCreate("/archive/drop/a") != nil || r.CheckCreate("/new") != nil {
+		t.Error("wrong create access")
+	}
+	if r.CheckWrite("/archive/drop/a") == nil || r.CheckWrite("/") == nil || r.CheckWrite("/docs") != nil {
+		t.Error("wrong write access, parents of restricted paths can't be modified as well")
+	}
+	if r.CheckReadAll("/archive") == nil || r.CheckReadAll("/docs") != nil {
+		t.Error("wrong read access to whole tree")
+	}
+}
+
+func TestSharePathRules(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+
+	shr := &ShareItem{Path: cfg.SharePathUp, AllowLocal: true, AllowExternal: true}
+	cfg.Usr1.AddShare(shr)
+	cfg.Usr1.Rules = PathRules{{Path: cfg.SharePathDeep, Mode: RULE_HIDDEN}}
+	_ = cfg.Update(cfg.Usr1)
+
+	r, p := cfg.SharePathRules("/user1/"+shr.ResolveSymlinkName()+"/share/a.jpg", false)
+	if p != cfg.SharePathDeep+"/a.jpg" || !r.IsHidden(p) {
+		t.Error("share path must be resolved with owner rules", p)
+	}
+	r, p = cfg.SharePathRules("/"+shr.LinkName(shr.Links[0].Token)+"/share", true)
+	if p != cfg.SharePathDeep || !r.IsHidden(p) {
+		t.Error("external share path must be resolved with owner rules", p)
+	}
+	if r, _ = cfg.SharePathRules("/user1", false); r != nil {
+		t.Error("path outside of share has no rules")
+	}
+}
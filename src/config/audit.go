@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//access records are kept this long, in case retention is not set
+const DEFAULT_SHARE_LOG_DAYS = 90
+
+//kinds of share access
+const (
+	ACCESS_LIST     = "list"
+	ACCESS_PREVIEW  = "preview"
+	ACCESS_DOWNLOAD = "download"
+	ACCESS_ZIP      = "zip"
+	ACCESS_PLAYLIST = "playlist"
+)
+
+//single access to share of the owner
+type AccessEntry struct {
+	Time time.Time `json:"time"`
+	//share path at owner home
+	Share string `json:"share"`
+	//requested path at owner home
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	//local user, empty for guests
+	User string `json:"user,omitempty"`
+	IP   string `json:"ip"`
+	//bytes sent to the client
+	Bytes int64 `json:"bytes"`
+}
+
+//serialize writes of access logs
+var accessLogLock sync.Mutex
+
+//days to keep access records, negative keeps them forever
+func (cfg *GlobalConfig) shareLogDays() int {
+	if cfg.ShareLogDays == 0 {
+		return DEFAULT_SHARE_LOG_DAYS
+	}
+	return cfg.ShareLogDays
+}
+
+// ~/<<cfg_PATH>>/<<username>>/access.log
+func (cfg *GlobalConfig) GetUserAccessLogPath(userName string) string {
+	return filepath.Join(cfg.FilesPath, userName, "access.log")
+}
+
+//append access to share at url into the log of the share owner, ignored in case url is not inside any share
+func (cfg *GlobalConfig) LogAccess(url string, isEx bool, e *AccessEntry) error {
+	updateLock.RLock()
+	shr, owner, p := cfg.findShare(url, isEx)
+	if shr == nil {
+		updateLock.RUnlock()
+		return nil
+	}
+	e.Share, e.Path = shr.Path, p
+	name := owner.Username
+	updateLock.RUnlock()
+
+	accessLogLock.Lock()
+	defer accessLogLock.Unlock()
+	return appendJSONLine(cfg.GetUserAccessLogPath(name), e)
+}
+
+//accesses to share at p of the owner, oldest first. "/" returns accesses to all shares of the owner
+func (cfg *GlobalConfig) AccessLog(owner, p string) (res []*AccessEntry, err error) {
+	accessLogLock.Lock()
+	defer accessLogLock.Unlock()
+	p = strings.TrimSuffix(p, "/")
+	err = readAccessLog(cfg.GetUserAccessLogPath(owner), func(e *AccessEntry) {
+		if len(p) == 0 || e.Share == p {
+			res = append(res, e)
+		}
+	})
+	return res, err
+}
+
+//drop access records older than retention from logs of all users
+func (cfg *GlobalConfig) PruneAccessLogs(now time.Time) {
+	days := cfg.shareLogDays()
+	if days < 0 {
+		return
+	}
+	since := now.AddDate(0, 0, -days)
+	for _, u := range cfg.GetUsers() {
+		if err := cfg.pruneAccessLog(u.Username, since); err != nil {
+			log.Println("config : can't prune access log of", u.Username, err)
+		}
+	}
+}
+
+func (cfg *GlobalConfig) pruneAccessLog(owner string, since time.Time) error {
+	accessLogLock.Lock()
+	defer accessLogLock.Unlock()
+	p := cfg.GetUserAccessLogPath(owner)
+	var keep []byte
+	dropped := false
+	err := readAccessLog(p, func(e *AccessEntry) {
+		if e.Time.Before(since) {
+			dropped = true
+			return
+		}
+		b, _ := json.Marshal(e)
+		keep = append(append(keep, b...), '\n')
+	})
+	if err != nil || !dropped {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err = ioutil.WriteFile(tmp, keep, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+//call fn for every record of the log at p, missing log has no records
+func readAccessLog(p string, fn func(e *AccessEntry)) error {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		e := &AccessEntry{}
+		if json.Unmarshal(sc.Bytes(), e) == nil {
+			fn(e)
+		}
+	}
+	return sc.Err()
+}
+
+//append v as single json line to the file at p, should be called under lock of the log
+func appendJSONLine(p string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
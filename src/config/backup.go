@@ -0,0 +1,153 @@
+package config
+
+import (
+	"bytes"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/lib/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//how many previous config versions to keep, in case not set
+const DEFAULT_CONFIG_BACKUPS = 5
+
+//previous version of config file, kept next to it as <config>.<unix nano>.bak
+type ConfigBackup struct {
+	ID       string    `json:"id"`
+	Modified time.Time `json:"modified"`
+	Size     int64     `json:"size"`
+}
+
+func (cfg *GlobalConfig) backupPath(id string) string {
+	return cfg.Path + "." + id + ".bak"
+}
+
+//number of backups to keep, negative disables backups
+func (cfg *GlobalConfig) backupsCount() int {
+	if cfg.ConfigBackups == 0 {
+		return DEFAULT_CONFIG_BACKUPS
+	}
+	return cfg.ConfigBackups
+}
+
+//write config file atomically, previous content is rotated into backups. Should be called under lock
+func (cfg *GlobalConfig) writeFile(data []byte) error {
+	old, err := ioutil.ReadFile(cfg.Path)
+	if err == nil && cfg.backupsCount() > 0 && !bytes.Equal(old, data) {
+		id := strconv.FormatInt(time.Now().UnixNano(), 10)
+		//previous versions may contain secrets
+		if err = utils.WriteFileAtomic(cfg.backupPath(id), old, PERM_SECRET); err != nil {
+			return err
+		}
+		cfg.rotateBackups()
+	}
+
+	return utils.WriteFileAtomic(cfg.Path, data, cnst.PERM_DEFAULT)
+}
+
+//drop the oldest backups above the limit
+func (cfg *GlobalConfig) rotateBackups() {
+	ids := cfg.backupIDs()
+	for len(ids) > cfg.backupsCount() {
+		_ = os.Remove(cfg.backupPath(ids[0]))
+		ids = ids[1:]
+	}
+}
+
+//backups ids sorted from oldest
+func (cfg *GlobalConfig) backupIDs() (res []string) {
+	matches, _ := filepath.Glob(cfg.Path + ".*.bak")
+	for _, m := range matches {
+		id := strings.TrimSuffix(strings.TrimPrefix(m, cfg.Path+"."), ".bak")
+		if isBackupID(id) {
+			res = append(res, id)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if len(res[i]) != len(res[j]) {
+			return len(res[i]) < len(res[j])
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
+
+func isBackupID(id string) bool {
+	_, err := strconv.ParseUint(id, 10, 64)
+	return err == nil
+}
+
+//list config backups, newest first
+func (cfg *GlobalConfig) ListBackups() (res []*ConfigBackup) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	res = []*ConfigBackup{}
+	ids := cfg.backupIDs()
+	for i := len(ids) - 1; i >= 0; i-- {
+		if inf, err := os.Stat(cfg.backupPath(ids[i])); err == nil {
+			res = append(res, &ConfigBackup{ID: ids[i], Modified: inf.ModTime(), Size: inf.Size()})
+		}
+	}
+	return res
+}
+
+func (cfg *GlobalConfig) readBackup(id string) ([]byte, error) {
+	if !isBackupID(id) {
+		return nil, cnst.ErrInvalidOption
+	}
+	b, err := ioutil.ReadFile(cfg.backupPath(id))
+	if os.IsNotExist(err) {
+		return nil, cnst.ErrNotExist
+	}
+	return b, err
+}
+
+//diff from backup to the current config file
+func (cfg *GlobalConfig) DiffBackup(id string) ([]string, error) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	old, err := cfg.readBackup(id)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := ioutil.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.DiffLines(strings.Split(string(old), "\n"), strings.Split(string(cur), "\n")), nil
+}
+
+//replace config file by backup and apply it, current file becomes a backup as well
+func (cfg *GlobalConfig) Rollback(id string) error {
+	updateLock.Lock()
+	b, err := cfg.readBackup(id)
+	if err == nil {
+		//make sure backup is usable before it replace current config
+		mod := &GlobalConfig{Path: cfg.Path}
+		mod.overrides = cfg.overrides
+		if err = mod.decodeConf(cfg.Path, b); err == nil {
+			err = mod.applyLayers()
+		}
+		if err == nil {
+			if !cfg.isJsonStore() {
+				mod.Users = cfg.Users
+			}
+			err = mod.Validate()
+		}
+	}
+	if err == nil {
+		err = cfg.writeFile(b)
+	}
+	updateLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return cfg.Reload()
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfigBackupRotate(t *testing.T) {
+	cfg := TContext{}
+	cfg.Init()
+	defer cfg.Clean(t)
+	cfg.ConfigBackups = 2
+	cfg.WriteConfig()
+	for _, port := range []int{1001, 1002, 1003, 1004} {
+		cfg.Http.Port = port
+		cfg.WriteConfig()
+	}
+	//same content should not create backup
+	cfg.WriteConfig()
+	bkps := cfg.ListBackups()
+	if len(bkps) != 2 {
+		t.Fatal("only 2 backups must be kept, but got", len(bkps))
+	}
+	diff, err := cfg.DiffBackup(bkps[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := strings.Join(diff, "\n")
+	if !strings.Contains(d, "-        \"port\": 1003") || !strings.Contains(d, "+        \"port\": 1004") {
+		t.Error("diff must show port change", d)
+	}
+	if _, err = cfg.DiffBackup("../../etc/passwd"); err == nil {
+		t.Error("bad backup id must be rejected")
+	}
+	//no temp files left behind
+	files, _ := ioutil.ReadDir(cfg.ConfigPath)
+	for _, f := range files {
+		if strings.Contains(f.Name(), ".tmp") {
+			t.Error("temp file left", f.Name())
+		}
+	}
+}
+
+func TestConfigRollback(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+	if err := cfg.DeleteUser("user1"); err != nil {
+		t.Fatal(err)
+	}
+	bkps := cfg.ListBackups()
+	if len(bkps) == 0 {
+		t.Fatal("backup must be created")
+	}
+	if err := cfg.Rollback(bkps[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.GetUserByUsername("user1"); !ok {
+		t.Error("deleted user must be restored by rollback")
+	}
+	if err := cfg.Rollback("1"); err != cnst.ErrNotExist {
+		t.Error("missing backup must be reported", err)
+	}
+	//broken backup must not be applied
+	_ = ioutil.WriteFile(cfg.backupPath("2"), []byte("{"), 0600)
+	if err := cfg.Rollback("2"); err == nil {
+		t.Error("broken backup must be rejected")
+	}
+	if _, err := os.Stat(cfg.Path); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -8,11 +9,13 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
@@ -27,12 +30,14 @@ Single config for everything.
 update automatically
 */
 type GlobalConfig struct {
-	Users   []*UserConfig `json:"users"`
-	Http    *ListenConf   `json:"http"`
-	Tls     *ListenConf   `json:"https"`
-	Log     string        `json:"log"`
-	TLSKey  string        `json:"tlsKey"`
-	TLSCert string        `json:"tlsCert"`
+	//config file layout version, uses to migrate older files
+	SchemaVersion int           `json:"schemaVersion"`
+	Users         []*UserConfig `json:"users,omitempty"`
+	Http          *ListenConf   `json:"http"`
+	Tls           *ListenConf   `json:"https"`
+	Log           string        `json:"log"`
+	TLSKey        string        `json:"tlsKey"`
+	TLSCert       string        `json:"tlsCert"`
 	// Scope is the Path the user has access to.
 	FilesPath      string `json:"filesPath"`
 	*CaptchaConfig `json:"captchaConfig"`
@@ -41,8 +46,39 @@ type GlobalConfig struct {
 	//http://host:port that used behind DMZ
 	ExternalShareHost string `json:"externalShareHost"`
 
+	//where users and shares are kept, json config file by default
+	Storage *StorageConf `json:"storage,omitempty"`
+	//how many previous versions of config file to keep, 0 - default, negative - disabled
+	ConfigBackups int `json:"configBackups"`
+	//file with salt key, password hashes and other secrets, by default next to the config file
+	SecretsPath string `json:"secretsPath"`
+	//storage limits for users without own quota
+	DefaultQuota *Quota `json:"defaultQuota,omitempty"`
+	//named sets of users for share access rules
+	Groups []*Group `json:"groups,omitempty"`
+	//how many days share access records are kept, 0 - default, negative - forever
+	ShareLogDays int `json:"shareLogDays"`
+	//identity provider for oidc auth method
+	OIDC *OIDCConfig `json:"oidc,omitempty"`
+	//directory server for ldap auth method
+	LDAP *LDAPConfig `json:"ldap,omitempty"`
+	//addresses or networks(cidr) of reverse proxies, client address is taken from X-Forwarded-For or X-Real-IP of them only
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+
 	//Path to config file
-	Path string `json:"-"`
+	Path  string `json:"-"`
+	store UserStore
+	//config file was upgraded to the current schema and should be written back
+	migrated bool
+	//config is only validated, so nothing is written and users store is not opened
+	checkOnly bool
+	//keys present at config file, like http.port
+	fileKeys map[string]bool
+	//values from environment and flags, applied on top of file
+	overrides []*override
+	flags     []*override
+	//values that were replaced by overrides
+	original map[string]string
 }
 
 type ListenConf struct {
@@ -53,6 +89,8 @@ type ListenConf struct {
 	// - 'proxy', which requires a valid user and the user name has to be provided through an
 	//   web header.
 	// - 'none', which allows anyone to access the filebrowser instance.
+	// - 'oidc', which logs users in through OpenID Connect provider, see GlobalConfig.OIDC.
+	// - 'ldap', which checks passwords against directory server, see GlobalConfig.LDAP.
 	// If 'Method' is set to 'proxy' the header configured below is used to identify the user.
 	AuthMethod string `json:"authMethod"`
 }
@@ -62,6 +100,24 @@ func (l *ListenConf) copy() *ListenConf {
 
 }
 
+//true in case ip is address of trusted reverse proxy
+func (cfg *GlobalConfig) IsTrustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	for _, p := range cfg.TrustedProxies {
+		if _, n, err := net.ParseCIDR(p); err == nil && n.Contains(addr) {
+			return true
+		} else if pip := net.ParseIP(p); pip != nil && pip.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 // Auth settings.
 type PreviewConf struct {
 	//enable preview generating by call .sh
@@ -83,12 +139,14 @@ func (cfg *GlobalConfig) GetDavPath(userName string) string {
 }
 
 //since we sure that this method will not modify, just return original
-func (cfg *GlobalConfig) GetExternal(hash string) (res *ShareItem, usr *UserConfig) {
+//share with active external link of given token
+func (cfg *GlobalConfig) GetExternal(token string) (res *ShareItem, usr *UserConfig) {
 	updateLock.RLock()
 	defer updateLock.RUnlock()
+	now := time.Now()
 	for _, user := range cfg.Users {
 		for _, item := range user.Shares {
-			if hash == item.Hash {
+			if item.activeLink(token, now) != nil && item.checkActive(now) == nil {
 				res = item
 				usr = user
 				break
@@ -127,11 +185,39 @@ type CaptchaConfig struct {
 
 func (cfg *GlobalConfig) Verify() {
 	updateLock.RLock()
-	defer updateLock.RUnlock()
+	var migrated, apps []string
+	now := time.Now()
 	for _, u := range cfg.Users {
+		if u.migrateAppPasswords() {
+			apps = append(apps, u.Username)
+		}
+		changed := false
 		for _, shr := range u.Shares {
 			shr.Path = strings.TrimSuffix(shr.Path, "/")
-			shr.Hash = GenShareHash(u.Username, shr.Path)
+			//shares saved before hash was stored
+			if len(shr.Hash) == 0 {
+				shr.Hash = GenShareHash(u.Username, shr.Path)
+			}
+			//old links keep working during migration window
+			if shr.migrateLinks(now) {
+				changed = true
+			}
+		}
+		if changed {
+			migrated = append(migrated, u.Username)
+		}
+	}
+	updateLock.RUnlock()
+	for _, name := range migrated {
+		log.Printf("config : external shares of %s migrated to links, legacy links expire in %v", name, LEGACY_LINK_WINDOW)
+		if err := cfg.SaveUser(name); err != nil {
+			log.Println("config : can't save user", name, err)
+		}
+	}
+	for _, name := range apps {
+		log.Printf("config : app passwords of %s migrated to webdav tokens", name)
+		if err := cfg.SaveUser(name); err != nil {
+			log.Println("config : can't save user", name, err)
 		}
 	}
 }
@@ -141,7 +227,7 @@ func (cfg *GlobalConfig) GetUserHomePath(userName string) string {
 	return filepath.Join(cfg.FilesPath, userName, "files")
 }
 
-// ~/<<cfg_PATH>>/<<username>>/shares
+// ~/<<cfg_PATH>>/<<username>>/shares, held share symlinks of older versions
 func (cfg *GlobalConfig) GetUserSharesPath(userName string) string {
 	return filepath.Join(cfg.FilesPath, userName, "shares")
 }
@@ -151,7 +237,7 @@ func (cfg *GlobalConfig) GetUserPreviewPath(userName string) string {
 	return filepath.Join(cfg.FilesPath, userName, "preview")
 }
 
-// ~/<<cfg_PATH>>/<<username>>/sharex
+// ~/<<cfg_PATH>>/<<username>>/sharex, held external share symlinks of older versions
 func (cfg *GlobalConfig) GetUserSharexPath(userName string) string {
 	return filepath.Join(cfg.FilesPath, userName, "sharex")
 }
@@ -171,8 +257,12 @@ func (cfg *GlobalConfig) ReadConfigFile() {
 			paths = append(paths, filepath.Join(curPath, cnst.FilePath1))
 		}
 	}
+	fromFile := true
 	for i, p := range paths {
 		err := cfg.parseConf(p)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("config : can't read config file %s : %v", p, err)
+		}
 		if err != nil && i < len(paths)-1 {
 			continue
 		}
@@ -182,7 +272,9 @@ func (cfg *GlobalConfig) ReadConfigFile() {
 			if argumentPath {
 				cfg.Path = paths[0]
 			}
-			cfg.FilesPath = filepath.Join(filepath.Dir(cfg.Path), "bf-data")
+			fromFile = false
+			cfg.SchemaVersion = SCHEMA_VERSION
+			cfg.fileKeys = nil
 
 			// DefaultUser is used on New, when no 'config' exists
 			cfg.Users = append(cfg.Users, &UserConfig{
@@ -195,69 +287,66 @@ func (cfg *GlobalConfig) ReadConfigFile() {
 				ViewMode:  "mosaic",
 				Locale:    "en",
 			})
-			cfg.Http = &ListenConf{AuthMethod: "default", IP: "127.0.0.1", Port: 8999}
-			cfg.Tls = &ListenConf{AuthMethod: "", IP: "", Port: 0}
-			cfg.ExternalShareHost = "http://127.0.0.1:8999"
-			cfg.PreviewConf = &PreviewConf{Threads: 2, ScriptPath: filepath.Join(filepath.Dir(cfg.Path), "bfconvert.sh")}
-			cfg.CaptchaConfig = &CaptchaConfig{}
-			cfg.Auth = &Auth{Header: "X-Forwarded-User"}
-			cfg.Log = "stdout"
 		}
 		break
 	}
-	fmt.Println("using config at path : " + cfg.Path)
+	fmt.Fprintln(os.Stderr, "using config at path : "+cfg.Path)
+	//defaults, environment and flags
+	if err := cfg.applyLayers(); err != nil {
+		log.Fatal(err)
+	}
 
 	config = cfg
+	if err := cfg.openStore(); err != nil {
+		log.Fatalf("config : can't open users store : %v", err)
+	}
+	if fromFile {
+		if err := cfg.Validate(); err != nil {
+			log.Fatal(err)
+		}
+	}
 	cfg.RefreshUserRam()
 	cfg.setupLog()
 	cfg.Verify()
 	cfg.setUpPaths()
+	if cfg.migrated {
+		cfg.migrated = false
+		cfg.WriteConfig()
+	}
 
 }
 
-//setup paths for all users, validate shares, and symlinks
+//setup paths for all users. Shares are resolved at request time, so symlinks left by older versions are dropped
 func (cfg *GlobalConfig) setUpPaths() {
-	needUpdate := false
 	for _, u := range cfg.Users {
-		//rebuild share paths
-		p := cfg.GetUserSharesPath(u.Username)
-		_ = os.RemoveAll(p)
-	}
-	for _, u := range cfg.Users {
-		//create shares folder
-		createPath(cfg.GetUserSharesPath(u.Username))
-		//create external shares folder
-		createPath(cfg.GetUserSharexPath(u.Username))
 		//create user files folder
 		createPath(cfg.GetUserHomePath(u.Username))
 		//create user preview folder
 		createPath(cfg.GetUserPreviewPath(u.Username))
+		for _, p := range []string{cfg.GetUserSharesPath(u.Username), cfg.GetUserSharexPath(u.Username),
+			filepath.Join(cfg.GetDavPath(u.Username), cnst.WEB_DAV_FOLDER)} {
+			dropLinks(p)
+		}
+	}
+}
 
-		_ = cfg.checkDavFolder(u);
-		//fix bad symlinks, or build missed for share for specific user
-		for _, owner := range cfg.Users {
-			//skip same user
-			if owner.Username == u.Username {
-				continue
-			}
-
-			for _, shr := range u.Shares {
-				if !cfg.checkShareSymLinkPath(shr, owner.Username, u.Username) {
-					u.DeleteShare(shr.Path)
-
-					needUpdate = true
-				}
-			}
+//remove symlinks of tree at p, and folders that became empty
+func dropLinks(p string) {
+	var dirs []string
+	_ = filepath.Walk(p, func(path string, inf os.FileInfo, err error) error {
+		if err != nil {
+			return nil
 		}
-		for _, shr := range u.Shares {
-			if !cfg.checkExternalShareSymLinkPath(shr, u.Username) {
-				u.DeleteShare(shr.Path)
-				needUpdate = true
-			}
+		if inf.Mode()&os.ModeSymlink != 0 {
+			_ = os.Remove(path)
+		} else if inf.IsDir() {
+			dirs = append(dirs, path)
 		}
-	}
-	if needUpdate {
-		cfg.WriteConfig()
+		return nil
+	})
+	//deepest first, not empty folders stay
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
 	}
 }
 func createPath(p string) (ok bool) {
@@ -270,39 +359,21 @@ func createPath(p string) (ok bool) {
 	return ok
 }
 
-//create symlinks at dav path for shares, and files folders from user's filesystem
-func (cfg *GlobalConfig) checkDavFolder(u *UserConfig) (err error) {
-	dp := cfg.GetDavPath(u.Username)
-	sharePath := filepath.Join(dp, cnst.WEB_DAV_FOLDER, "shares")
-	filesPath := filepath.Join(dp, cnst.WEB_DAV_FOLDER, "files")
-
-	//check symlink from user home dir, to the user's webdav Path
-	createPath(filesPath)
-	//check shares symlink path
-	createPath(sharePath)
-
-	if _, err = os.Readlink(filesPath); err != nil {
-		_ = os.Remove(filesPath)
-	}
-	if err = os.Symlink(cfg.GetUserHomePath(u.Username), filesPath); err != nil && !os.IsExist(err) {
-		log.Println("config : Cant create user symlink at dav ", err)
-	}
-	//recreate shares path
-	_ = os.Remove(sharePath)
-	if err = os.Symlink(cfg.GetUserSharesPath(u.Username), sharePath); err != nil && !os.IsExist(err) {
-		log.Println("config : Cant create user symlink at dav ", err)
-	}
-	return
-}
-
 func (cfg *GlobalConfig) parseConf(p string) (r error) {
 	if jsonFile, err := os.Open(p); err == nil {
 		byteValue, _ := ioutil.ReadAll(jsonFile)
-		err = json.Unmarshal(byteValue, &cfg)
-		r = jsonFile.Close()
+		if !cfg.checkOnly {
+			updateLock.Lock()
+			fileSum = sha256.Sum256(byteValue)
+			updateLock.Unlock()
+		}
+		r = cfg.decodeConf(p, byteValue)
+		if err = jsonFile.Close(); r == nil {
+			r = err
+		}
 	} else {
-		fmt.Printf("can't open %s", p)
-		fmt.Println(err)
+		fmt.Fprintf(os.Stderr, "can't open %s", p)
+		fmt.Fprintln(os.Stderr, err)
 		r = err
 	}
 
@@ -322,7 +393,9 @@ func (cfg *GlobalConfig) WriteConfig() {
 	updateLock.Lock()
 	defer updateLock.Unlock()
 	//todo check hash if config changed
-	jsonData, err := json.MarshalIndent(cfg, "", "    ")
+	//users are kept at config file only by json store
+	sec, out := cfg.withoutOverrides().splitSecrets(cfg.isJsonStore())
+	jsonData, err := json.MarshalIndent(out, "", "    ")
 	if err != nil {
 		log.Println(err)
 	} else {
@@ -330,9 +403,15 @@ func (cfg *GlobalConfig) WriteConfig() {
 		if err != nil {
 			log.Println(err)
 		}
-		err = ioutil.WriteFile(cfg.Path, jsonData, cnst.PERM_DEFAULT)
+		if err = cfg.writeSecrets(sec); err != nil {
+			log.Println("config : cant write secrets file", err)
+			return
+		}
+		err = cfg.writeFile(jsonData)
 		if err != nil {
 			log.Println("config : cant write config file", err)
+		} else {
+			fileSum = sha256.Sum256(jsonData)
 		}
 	}
 }
@@ -386,23 +465,46 @@ func (cfg *GlobalConfig) GetKeyBytes() ([]byte, error) {
 	return base64.StdEncoding.DecodeString(cfg.Auth.Key)
 }
 
-//clone config
+//clone config without secrets, so it is safe to send to the client
 func (cfg *GlobalConfig) CopyConfig() *GlobalConfig {
 	updateLock.RLock()
 	defer updateLock.RUnlock()
 	res := &GlobalConfig{
+		SchemaVersion:     cfg.SchemaVersion,
 		Users:             cfg.GetUsers(),
 		Http:              &ListenConf{cfg.Http.Port, cfg.Http.IP, cfg.Http.AuthMethod},
 		Log:               cfg.Log,
-		CaptchaConfig:     cfg.copyCaptchaConfig(),
-		Auth:              cfg.copyAuth(),
+		CaptchaConfig:     &CaptchaConfig{Host: cfg.CaptchaConfig.Host, Key: cfg.CaptchaConfig.Key},
+		Auth:              &Auth{Header: cfg.Auth.Header},
 		PreviewConf:       &PreviewConf{ScriptPath: cfg.ScriptPath, Threads: cfg.Threads},
 		FilesPath:         cfg.FilesPath,
-		TLSKey:            cfg.TLSKey,
 		TLSCert:           cfg.TLSCert,
 		ExternalShareHost: cfg.ExternalShareHost,
+		ConfigBackups:     cfg.ConfigBackups,
+		ShareLogDays:      cfg.ShareLogDays,
+		TrustedProxies:    append([]string(nil), cfg.TrustedProxies...),
+		SecretsPath:       cfg.SecretsPath,
+		DefaultQuota:      cfg.DefaultQuota.copyQuota(),
 		Path:              cfg.Path,
 	}
+	for _, g := range cfg.Groups {
+		res.Groups = append(res.Groups, g.copyGroup())
+	}
+	for _, u := range res.Users {
+		u.MaskSecrets()
+		for _, shr := range u.Shares {
+			shr.MaskPassword()
+		}
+	}
+	if cfg.Storage != nil {
+		res.Storage = &StorageConf{Type: cfg.Storage.Type, Path: cfg.Storage.Path}
+	}
+	if res.OIDC = cfg.OIDC.copyOIDC(); res.OIDC != nil {
+		res.OIDC.ClientSecret = ""
+	}
+	if res.LDAP = cfg.LDAP.copyLDAP(); res.LDAP != nil {
+		res.LDAP.BindPassword = ""
+	}
 	if cfg.Tls != nil {
 		res.Tls = &ListenConf{cfg.Tls.Port, cfg.Tls.IP, cfg.Tls.AuthMethod}
 	} else {
@@ -412,10 +514,12 @@ func (cfg *GlobalConfig) CopyConfig() *GlobalConfig {
 	return res
 }
 
-//deep update config
+//deep update config, empty secrets keep current values, because they never reach the client
 func (cfg *GlobalConfig) UpdateConfig(u *GlobalConfig) {
 	updateLock.Lock()
 	defer updateLock.Unlock()
+	key, secret, tlsKey := cfg.Auth.Key, cfg.CaptchaConfig.Secret, cfg.TLSKey
+	oidc, ldap := cfg.OIDC, cfg.LDAP
 	cfg.Http = u.Http.copy()
 	cfg.Tls = u.Tls.copy()
 	cfg.Log = u.Log
@@ -426,6 +530,30 @@ func (cfg *GlobalConfig) UpdateConfig(u *GlobalConfig) {
 	cfg.TLSKey = u.TLSKey
 	cfg.PreviewConf = u.PreviewConf
 	cfg.ExternalShareHost = u.ExternalShareHost
+	cfg.ConfigBackups = u.ConfigBackups
+	cfg.ShareLogDays = u.ShareLogDays
+	cfg.TrustedProxies = append([]string(nil), u.TrustedProxies...)
+	cfg.DefaultQuota = u.DefaultQuota.copyQuota()
+	cfg.OIDC = u.OIDC.copyOIDC()
+	if cfg.OIDC != nil && len(cfg.OIDC.ClientSecret) == 0 && oidc != nil {
+		cfg.OIDC.ClientSecret = oidc.ClientSecret
+	}
+	cfg.LDAP = u.LDAP.copyLDAP()
+	if cfg.LDAP != nil && len(cfg.LDAP.BindPassword) == 0 && ldap != nil {
+		cfg.LDAP.BindPassword = ldap.BindPassword
+	}
+	if len(u.SecretsPath) > 0 {
+		cfg.SecretsPath = u.SecretsPath
+	}
+	if len(cfg.Auth.Key) == 0 {
+		cfg.Auth.Key = key
+	}
+	if len(cfg.CaptchaConfig.Secret) == 0 {
+		cfg.CaptchaConfig.Secret = secret
+	}
+	if len(cfg.TLSKey) == 0 {
+		cfg.TLSKey = tlsKey
+	}
 }
 
 //update salt key
@@ -19,18 +19,9 @@ func TestNewConfig(t *testing.T) {
 	if _, err = os.Stat(cfg.GetUserHomePath("admin")); err != nil {
 		t.Fatal(err)
 	}
-	if _, err = os.Stat(cfg.GetUserSharesPath("admin")); err != nil {
-		t.Fatal(err)
-	}
 	if _, err = os.Stat(cfg.GetUserPreviewPath("admin")); err != nil {
 		t.Fatal(err)
 	}
-	if _, err = os.Stat(cfg.GetUserSharexPath("admin")); err != nil {
-		t.Fatal(err)
-	}
-	if _, err = os.Stat(cfg.GetDavPath("admin")); err != nil {
-		t.Fatal(err)
-	}
 	if config == nil {
 		t.Fatal("global config empty")
 	}
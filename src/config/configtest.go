@@ -26,8 +26,6 @@ type TContext struct {
 	User1FS        utils.Dir
 	User1FSPreview utils.Dir
 	User2FS        utils.Dir
-	User2FSShare   utils.Dir
-	AdminFSShare   utils.Dir
 	AdminFS        utils.Dir
 	*GlobalConfig
 	Srv   *httptest.Server
@@ -48,6 +46,15 @@ func (tc *TContext) Init() {
 
 	tc.GlobalConfig = &cfg
 }
+//stat path inside share at url, as it seen by consumer
+func (tc *TContext) StatShare(consumer, url string) (os.FileInfo, error) {
+	owner, p, err := tc.ResolveShare(url, consumer, false)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(filepath.Join(tc.GetUserHomePath(owner), p))
+}
+
 func (tc *TContext) Clean(t *testing.T) {
 	err := os.RemoveAll(tc.ConfigPath)
 	if err != nil {
@@ -81,8 +88,6 @@ func (tc *TContext) InitWithUsers(t *testing.T) {
 	tc.User1FSPreview = utils.Dir(tc.GetUserPreviewPath(tc.Usr1.Username))
 	tc.User2FS = utils.Dir(tc.GetUserHomePath(tc.Usr2.Username))
 	tc.AdminFS = utils.Dir(tc.GetUserHomePath(tc.GetAdmin().Username))
-	tc.User2FSShare = utils.Dir(tc.GetUserSharesPath(tc.Usr2.Username))
-	tc.AdminFSShare = utils.Dir(tc.GetUserSharesPath(tc.GetAdmin().Username))
 	tc.Guest, _ = tc.GetUserByUsername("guest")
 	//create paths for share item for 2 users
 	err = tc.User1FS.Mkdir(tc.SharePathDeep, cnst.PERM_DEFAULT, 0, 0)
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//name collision rules of file drop
+const (
+	DROP_RENAME = "rename"
+	DROP_REJECT = "reject"
+)
+
+//file drop settings of external share, guests can only upload files into the share, but never see its content
+type DropConfig struct {
+	//max size of single upload in bytes, 0 - not limited
+	MaxSize int64 `json:"maxSize,omitempty"`
+	//max number of uploads, 0 - not limited
+	MaxFiles int `json:"maxFiles,omitempty"`
+	//in case file with same name exists: rename(default) new file or reject upload
+	OnConflict string `json:"onConflict,omitempty"`
+	//uploads counted so far, by server only
+	Uploads int `json:"uploads,omitempty"`
+}
+
+//single upload into file drop
+type DropEntry struct {
+	Time time.Time `json:"time"`
+	//share path at owner home
+	Share string `json:"share"`
+	//name of the saved file
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	IP   string `json:"ip"`
+}
+
+//serialize writes of upload logs
+var dropLogLock sync.Mutex
+
+//true in case guests can only upload into share
+func (shr *ShareItem) IsDrop() bool {
+	return shr.Drop != nil
+}
+
+//cnst.ErrInvalidOption in case limits or collision rule are wrong
+func (d *DropConfig) Validate() error {
+	if d.MaxSize < 0 || d.MaxFiles < 0 || len(d.OnConflict) > 0 && d.OnConflict != DROP_RENAME && d.OnConflict != DROP_REJECT {
+		return cnst.ErrInvalidOption
+	}
+	return nil
+}
+
+func (d *DropConfig) copyDrop() *DropConfig {
+	if d == nil {
+		return nil
+	}
+	res := *d
+	return &res
+}
+
+//reserve upload into file drop at external url(/name_token/file), returns drop settings, owner and share path at owner home.
+//Reservation must be released in case upload failed
+func (cfg *GlobalConfig) ReserveDrop(url string) (*DropConfig, string, string, error) {
+	updateLock.Lock()
+	shr, owner, _ := cfg.findShare(url, true)
+	if shr == nil || !shr.IsDrop() {
+		updateLock.Unlock()
+		return nil, "", "", cnst.ErrNotExist
+	}
+	if err := shr.checkActive(time.Now()); err != nil {
+		updateLock.Unlock()
+		return nil, "", "", err
+	}
+	if shr.Drop.MaxFiles > 0 && shr.Drop.Uploads >= shr.Drop.MaxFiles {
+		updateLock.Unlock()
+		return nil, "", "", cnst.ErrDropFull
+	}
+	shr.Drop.Uploads++
+	res, name, p := shr.Drop.copyDrop(), owner.Username, shr.Path
+	updateLock.Unlock()
+
+	return res, name, p, cfg.SaveUser(name)
+}
+
+//release upload reserved by ReserveDrop
+func (cfg *GlobalConfig) ReleaseDrop(url string) {
+	updateLock.Lock()
+	shr, owner, _ := cfg.findShare(url, true)
+	if shr == nil || !shr.IsDrop() || shr.Drop.Uploads == 0 {
+		updateLock.Unlock()
+		return
+	}
+	shr.Drop.Uploads--
+	name := owner.Username
+	updateLock.Unlock()
+
+	_ = cfg.SaveUser(name)
+}
+
+// ~/<<cfg_PATH>>/<<username>>/drop.log
+func (cfg *GlobalConfig) GetUserDropLogPath(userName string) string {
+	return filepath.Join(cfg.FilesPath, userName, "drop.log")
+}
+
+//append upload to the log of the share owner, one json entry per line
+func (cfg *GlobalConfig) LogDrop(owner string, e *DropEntry) error {
+	dropLogLock.Lock()
+	defer dropLogLock.Unlock()
+	return appendJSONLine(cfg.GetUserDropLogPath(owner), e)
+}
+
+//uploads into share at p of the owner, oldest first
+func (cfg *GlobalConfig) DropLog(owner, p string) (res []*DropEntry, err error) {
+	dropLogLock.Lock()
+	defer dropLogLock.Unlock()
+	f, err := os.Open(cfg.GetUserDropLogPath(owner))
+	if os.IsNotExist(err) {
+		return res, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p = strings.TrimSuffix(p, "/")
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		e := &DropEntry{}
+		if json.Unmarshal(sc.Bytes(), e) == nil && e.Share == p {
+			res = append(res, e)
+		}
+	}
+	return res, sc.Err()
+}
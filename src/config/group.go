@@ -0,0 +1,152 @@
+package config
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"strings"
+)
+
+//named set of users, that can be allowed to access shares
+type Group struct {
+	Name  string   `json:"name"`
+	Users []string `json:"users"`
+}
+
+func (g *Group) copyGroup() *Group {
+	res := &Group{Name: g.Name, Users: make([]string, len(g.Users))}
+	copy(res.Users, g.Users)
+	return res
+}
+
+func (g *Group) has(username string) bool {
+	for _, u := range g.Users {
+		if u == username {
+			return true
+		}
+	}
+	return false
+}
+
+//should be called under lock
+func (cfg *GlobalConfig) getGroup(name string) *Group {
+	for _, g := range cfg.Groups {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+//true in case user is member of any of groups, should be called under lock
+func (cfg *GlobalConfig) inGroups(username string, groups []string) bool {
+	for _, name := range groups {
+		if g := cfg.getGroup(name); g != nil && g.has(username) {
+			return true
+		}
+	}
+	return false
+}
+
+//members of groups, should be called under lock
+func (cfg *GlobalConfig) groupsMembers(groups []string) (res []string) {
+	for _, name := range groups {
+		if g := cfg.getGroup(name); g != nil {
+			res = append(res, g.Users...)
+		}
+	}
+	return res
+}
+
+func (cfg *GlobalConfig) GetGroups() (res []*Group) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	res = make([]*Group, len(cfg.Groups))
+	for i, g := range cfg.Groups {
+		res[i] = g.copyGroup()
+	}
+	return res
+}
+
+//create group or replace its members, access of members to shares is resolved at request time
+func (cfg *GlobalConfig) SetGroup(g *Group) error {
+	if len(g.Name) == 0 {
+		return cnst.ErrInvalidOption
+	}
+	updateLock.Lock()
+	if old := cfg.getGroup(g.Name); old != nil {
+		old.Users = g.copyGroup().Users
+	} else {
+		cfg.Groups = append(cfg.Groups, g.copyGroup())
+	}
+	updateLock.Unlock()
+	cfg.WriteConfig()
+
+	return nil
+}
+
+//delete group, members lose access to shares allowed by it
+func (cfg *GlobalConfig) DeleteGroup(name string) error {
+	updateLock.Lock()
+	found := false
+	for i, g := range cfg.Groups {
+		if g.Name == name {
+			cfg.Groups = append(cfg.Groups[:i], cfg.Groups[i+1:]...)
+			found = true
+			break
+		}
+	}
+	updateLock.Unlock()
+	if !found {
+		return cnst.ErrNotExist
+	}
+	cfg.WriteConfig()
+
+	return nil
+}
+
+//drop user from all groups, should be called under lock. Returns true in case any group was changed
+func (cfg *GlobalConfig) leaveGroups(username string) (res bool) {
+	for _, g := range cfg.Groups {
+		for i, u := range g.Users {
+			if u == username {
+				g.Users = append(g.Users[:i], g.Users[i+1:]...)
+				res = true
+				break
+			}
+		}
+	}
+	return res
+}
+
+//groups of identity provider or directory, mapped to permissions of their members
+type GroupMapping struct {
+	//members of those groups become admins
+	AdminGroups []string `json:"adminGroups,omitempty"`
+	//members of those groups can create and edit files
+	EditGroups []string `json:"editGroups,omitempty"`
+}
+
+func (m GroupMapping) copyMapping() GroupMapping {
+	return GroupMapping{append([]string(nil), m.AdminGroups...), append([]string(nil), m.EditGroups...)}
+}
+
+//true in case groups are mapped to permissions
+func (m *GroupMapping) MapsGroups() bool {
+	return len(m.AdminGroups) > 0 || len(m.EditGroups) > 0
+}
+
+//admin and edit flags of given groups
+func (m *GroupMapping) GroupFlags(groups []string) (admin, edit bool) {
+	has := func(names []string) bool {
+		for _, n := range names {
+			for _, g := range groups {
+				if strings.EqualFold(n, g) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	admin = has(m.AdminGroups)
+	edit = admin || has(m.EditGroups)
+	return
+}
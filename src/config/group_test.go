@@ -0,0 +1,78 @@
+package config
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGroupShares(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+
+	shr := &ShareItem{Path: cfg.SharePathDeep, AllowGroups: []string{"team"}}
+	cfg.Usr1.AddShare(shr)
+	_ = cfg.Update(cfg.Usr1)
+	link := "/" + cfg.Usr1.Username + "/" + shr.ResolveSymlinkName()
+	if shr.IsAllowed("user2") {
+		t.Fatal("user2 is not member yet")
+	}
+
+	if err := cfg.SetGroup(&Group{Name: "team", Users: []string{"user2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !shr.IsAllowed("user2") {
+		t.Error("group member must be allowed")
+	}
+	if _, err := cfg.StatShare("user2", link); err != nil {
+		t.Error("share must be available for new member", err)
+	}
+
+	if err := cfg.SetGroup(&Group{Name: "team"}); err != nil {
+		t.Fatal(err)
+	}
+	if shr.IsAllowed("user2") {
+		t.Error("excluded member must not be allowed")
+	}
+	if _, err := cfg.StatShare("user2", link); err == nil {
+		t.Error("share must not be available for excluded member")
+	}
+
+	_ = cfg.SetGroup(&Group{Name: "team", Users: []string{"user2"}})
+	if err := cfg.DeleteUser("user2"); err != nil {
+		t.Fatal(err)
+	}
+	if g := cfg.GetGroups(); len(g) != 1 || len(g[0].Users) != 0 {
+		t.Error("deleted user must leave groups", g)
+	}
+	if err := cfg.DeleteGroup("team"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DeleteGroup("team") == nil {
+		t.Error("missed group delete must fail")
+	}
+}
+
+func TestDeleteEmptyGroup(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+
+	if err := cfg.SetGroup(&Group{Name: "empty"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.DeleteGroup("empty"); err != nil {
+		t.Fatal("group without members must be deleted", err)
+	}
+	if len(cfg.GetGroups()) != 0 {
+		t.Error("group must be removed")
+	}
+	b, err := ioutil.ReadFile(cfg.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), `"empty"`) {
+		t.Error("deleted group must be removed from config file")
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import "time"
+
+//defaults of directory lookup
+const (
+	LDAP_USER_FILTER     = "(uid=%s)"
+	LDAP_GROUP_ATTRIBUTE = "memberOf"
+	LDAP_CACHE_TTL       = 5 * time.Minute
+)
+
+//directory server, used by listeners with ldap auth method
+type LDAPConfig struct {
+	//ldap://host:389 or ldaps://host:636
+	URL string `json:"url"`
+	//upgrade plain connection by StartTLS
+	StartTLS bool `json:"startTLS"`
+	//PEM file with CA certificates of server, system pool is used in case empty
+	CACert string `json:"caCert,omitempty"`
+	//skip verification of server certificate, never use it in production
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	//account used to search users, anonymous search in case empty
+	BindDN       string `json:"bindDN,omitempty"`
+	BindPassword string `json:"bindPassword,omitempty"`
+	//where users are searched
+	BaseDN string `json:"baseDN"`
+	//filter of user entry, %s is replaced by escaped username, (uid=%s) by default
+	UserFilter string `json:"userFilter,omitempty"`
+	//attribute of user entry with groups, memberOf by default
+	GroupAttribute string `json:"groupAttribute,omitempty"`
+	//seconds, successful bind is trusted without asking server again, 0 - default, negative - disabled
+	CacheTTL int `json:"cacheTTL"`
+	GroupMapping
+}
+
+func (l *LDAPConfig) copyLDAP() *LDAPConfig {
+	if l == nil {
+		return nil
+	}
+	res := *l
+	res.GroupMapping = l.GroupMapping.copyMapping()
+	return &res
+}
+
+func (l *LDAPConfig) GetUserFilter() string {
+	if len(l.UserFilter) == 0 {
+		return LDAP_USER_FILTER
+	}
+	return l.UserFilter
+}
+
+func (l *LDAPConfig) GetGroupAttribute() string {
+	if len(l.GroupAttribute) == 0 {
+		return LDAP_GROUP_ATTRIBUTE
+	}
+	return l.GroupAttribute
+}
+
+func (l *LDAPConfig) GetCacheTTL() time.Duration {
+	if l.CacheTTL == 0 {
+		return LDAP_CACHE_TTL
+	} else if l.CacheTTL < 0 {
+		return 0
+	}
+	return time.Duration(l.CacheTTL) * time.Second
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/browsefile/backend/src/cnst"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//links built from legacy share hash keep working this long after migration
+const LEGACY_LINK_WINDOW = 30 * 24 * time.Hour
+const LEGACY_LINK_LABEL = "legacy"
+
+//external link of the share, every link has own random token, so it can be rotated or revoked separately
+type ShareLink struct {
+	Token   string    `json:"token"`
+	Label   string    `json:"label,omitempty"`
+	Created time.Time `json:"created"`
+	//link is dropped after this time, set for migrated legacy links only
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+//new link with random token
+func NewShareLink(label string) *ShareLink {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	//hex only, because link name is split by "_" to find the token
+	return &ShareLink{Token: hex.EncodeToString(b), Label: label, Created: time.Now()}
+}
+
+func (l *ShareLink) isExpired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+func copyLinks(links []*ShareLink) []*ShareLink {
+	if links == nil {
+		return nil
+	}
+	res := make([]*ShareLink, len(links))
+	for i, l := range links {
+		cp := *l
+		cp.ExpiresAt = copyTime(l.ExpiresAt)
+		res[i] = &cp
+	}
+	return res
+}
+
+//link with given token, that is not expired at now
+func (shr *ShareItem) activeLink(token string, now time.Time) *ShareLink {
+	if len(token) == 0 {
+		return nil
+	}
+	for _, l := range shr.Links {
+		if l.Token == token && !l.isExpired(now) {
+			return l
+		}
+	}
+	return nil
+}
+
+//name of external share link, like name_token
+func (shr *ShareItem) LinkName(token string) string {
+	d := filepath.Dir(shr.Path)
+	return strings.ReplaceAll(strings.TrimPrefix(shr.Path, d), "/", "") + "_" + token
+}
+
+//token of legacy link from share hash. Hash is base64, slash is replaced, because token is single url segment
+//and old links with slash never resolved anyway. Dash is not used by base64, so tokens stay unique
+func legacyToken(hash string) string {
+	return strings.ReplaceAll(hash, "/", "-")
+}
+
+//link from legacy hash, in case external share was created before links were introduced. True in case share was changed
+func (shr *ShareItem) migrateLinks(now time.Time) bool {
+	changed := false
+	//links migrated with raw hash by earlier version
+	for _, l := range shr.Links {
+		if l.Label == LEGACY_LINK_LABEL && strings.Contains(l.Token, "/") {
+			l.Token, changed = legacyToken(l.Token), true
+		}
+	}
+	if !shr.AllowExternal || len(shr.Links) > 0 || len(shr.Hash) == 0 {
+		return changed
+	}
+	exp := now.Add(LEGACY_LINK_WINDOW)
+	shr.Links = []*ShareLink{{Token: legacyToken(shr.Hash), Label: LEGACY_LINK_LABEL, Created: now, ExpiresAt: &exp}}
+	return true
+}
+
+//full url of external share link
+func (cfg *GlobalConfig) ShareLinkURL(shr *ShareItem, token string) string {
+	return cfg.ExternalShareHost + "/shares/" + shr.LinkName(token) + "?" + cnst.P_EXSHARE + "=1"
+}
+
+//live share of the owner at exact path, should be called under lock
+func (cfg *GlobalConfig) ownShare(owner, p string) (*ShareItem, error) {
+	u, ok := usersRam[owner]
+	if !ok {
+		return nil, cnst.ErrNotExist
+	}
+	p = strings.TrimSuffix(p, "/")
+	for _, shr := range u.Shares {
+		if shr.Path == p {
+			if !shr.AllowExternal {
+				return nil, cnst.ErrInvalidOption
+			}
+			return shr, nil
+		}
+	}
+	return nil, cnst.ErrNotExist
+}
+
+//add new link to external share of the owner
+func (cfg *GlobalConfig) AddShareLink(owner, p, label string) (*ShareItem, *ShareLink, error) {
+	updateLock.Lock()
+	shr, err := cfg.ownShare(owner, p)
+	if err != nil {
+		updateLock.Unlock()
+		return nil, nil, err
+	}
+	l := NewShareLink(label)
+	shr.Links = append(shr.Links, l)
+	res, resL := *shr, *l
+	res.Links = copyLinks(shr.Links)
+	updateLock.Unlock()
+
+	return &res, &resL, cfg.SaveUser(owner)
+}
+
+//replace token of the link, old url stops working immediately
+func (cfg *GlobalConfig) RotateShareLink(owner, p, token string) (*ShareItem, *ShareLink, error) {
+	updateLock.Lock()
+	shr, err := cfg.ownShare(owner, p)
+	if err != nil {
+		updateLock.Unlock()
+		return nil, nil, err
+	}
+	l := shr.activeLink(token, time.Now())
+	if l == nil {
+		updateLock.Unlock()
+		return nil, nil, cnst.ErrNotExist
+	}
+	nl := NewShareLink(l.Label)
+	l.Token, l.Created, l.ExpiresAt = nl.Token, nl.Created, nil
+	res, resL := *shr, *l
+	res.Links = copyLinks(shr.Links)
+	updateLock.Unlock()
+
+	return &res, &resL, cfg.SaveUser(owner)
+}
+
+//drop the link, share and its other links stay
+func (cfg *GlobalConfig) RevokeShareLink(owner, p, token string) error {
+	updateLock.Lock()
+	shr, err := cfg.ownShare(owner, p)
+	if err != nil {
+		updateLock.Unlock()
+		return err
+	}
+	found := false
+	for i, l := range shr.Links {
+		if l.Token == token {
+			shr.Links = append(shr.Links[:i:i], shr.Links[i+1:]...)
+			found = true
+			break
+		}
+	}
+	updateLock.Unlock()
+	if !found {
+		return cnst.ErrNotExist
+	}
+
+	return cfg.SaveUser(owner)
+}
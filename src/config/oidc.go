@@ -0,0 +1,60 @@
+package config
+
+//default claims of identity provider
+const (
+	OIDC_USERNAME_CLAIM = "preferred_username"
+	OIDC_GROUPS_CLAIM   = "groups"
+)
+
+//OpenID Connect provider, used by listeners with oidc auth method
+type OIDCConfig struct {
+	//issuer url, provider configuration is discovered from it
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	//callback url registered at provider, by default built from request host
+	RedirectURL string `json:"redirectUrl,omitempty"`
+	//requested scopes, openid is always added
+	Scopes []string `json:"scopes,omitempty"`
+	//claim of id token used as username, preferred_username by default
+	UsernameClaim string `json:"usernameClaim,omitempty"`
+	//claim of id token with group names, groups by default
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+	//create missing users on first login
+	AutoCreate bool `json:"autoCreate"`
+	GroupMapping
+}
+
+func (o *OIDCConfig) copyOIDC() *OIDCConfig {
+	if o == nil {
+		return nil
+	}
+	res := *o
+	res.Scopes = append([]string(nil), o.Scopes...)
+	res.GroupMapping = o.GroupMapping.copyMapping()
+	return &res
+}
+
+func (o *OIDCConfig) GetUsernameClaim() string {
+	if len(o.UsernameClaim) == 0 {
+		return OIDC_USERNAME_CLAIM
+	}
+	return o.UsernameClaim
+}
+
+func (o *OIDCConfig) GetGroupsClaim() string {
+	if len(o.GroupsClaim) == 0 {
+		return OIDC_GROUPS_CLAIM
+	}
+	return o.GroupsClaim
+}
+
+//true in case any listener uses given auth method
+func (cfg *GlobalConfig) UsesAuth(method string) bool {
+	for _, l := range []*ListenConf{cfg.Http, cfg.Tls} {
+		if l != nil && l.AuthMethod == method {
+			return true
+		}
+	}
+	return false
+}
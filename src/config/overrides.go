@@ -0,0 +1,301 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//where effective config value came from, in order of priority from low to high
+const (
+	SRC_DEFAULT = "default"
+	SRC_FILE    = "file"
+	SRC_ENV     = "env"
+	SRC_FLAG    = "flag"
+)
+
+//prefix for environment variables, that override config values
+const ENV_PREFIX = "BROWSEFILE_"
+
+//config value, that can be set by file, environment variable or command line flag
+type setting struct {
+	//json path at config file
+	key   string
+	env   string
+	flag  string
+	usage string
+	//value is never shown, taken from secrets file, environment or *_FILE
+	secret bool
+	def    func(cfg *GlobalConfig) string
+	get    func(cfg *GlobalConfig) string
+	set    func(cfg *GlobalConfig, v string) error
+}
+
+//value applied from environment or flag
+type override struct {
+	key    string
+	value  string
+	source string
+}
+
+//effective config value and its origin
+type ConfigValue struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Env    string `json:"env"`
+	Flag   string `json:"flag"`
+}
+
+func setInt(p *int, v string) (err error) {
+	*p, err = strconv.Atoi(v)
+	return err
+}
+
+//comma separated values, empty ones are skipped
+func splitList(v string) (res []string) {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+func confDir(cfg *GlobalConfig) string {
+	return filepath.Dir(cfg.Path)
+}
+
+var settings = []*setting{
+	{key: "filesPath", env: "FILES_PATH", flag: "files-path", usage: "folder with users data",
+		def: func(cfg *GlobalConfig) string { return filepath.Join(confDir(cfg), "bf-data") },
+		get: func(cfg *GlobalConfig) string { return cfg.FilesPath },
+		set: func(cfg *GlobalConfig, v string) error { cfg.FilesPath = v; return nil }},
+	{key: "http.port", env: "HTTP_PORT", flag: "http-port", usage: "http listen port, 0 disables http",
+		def: func(cfg *GlobalConfig) string { return "8999" },
+		get: func(cfg *GlobalConfig) string { return strconv.Itoa(cfg.Http.Port) },
+		set: func(cfg *GlobalConfig, v string) error { return setInt(&cfg.Http.Port, v) }},
+	{key: "http.ip", env: "HTTP_IP", flag: "http-ip", usage: "http listen address",
+		def: func(cfg *GlobalConfig) string { return "127.0.0.1" },
+		get: func(cfg *GlobalConfig) string { return cfg.Http.IP },
+		set: func(cfg *GlobalConfig, v string) error { cfg.Http.IP = v; return nil }},
+	{key: "http.authMethod", env: "HTTP_AUTH_METHOD", flag: "http-auth", usage: "http authentication method",
+		def: func(cfg *GlobalConfig) string { return "default" },
+		get: func(cfg *GlobalConfig) string { return cfg.Http.AuthMethod },
+		set: func(cfg *GlobalConfig, v string) error { cfg.Http.AuthMethod = v; return nil }},
+	{key: "https.port", env: "HTTPS_PORT", flag: "https-port", usage: "https listen port, 0 disables https",
+		def: func(cfg *GlobalConfig) string { return "0" },
+		get: func(cfg *GlobalConfig) string { return strconv.Itoa(cfg.Tls.Port) },
+		set: func(cfg *GlobalConfig, v string) error { return setInt(&cfg.Tls.Port, v) }},
+	{key: "https.ip", env: "HTTPS_IP", flag: "https-ip", usage: "https listen address",
+		def: func(cfg *GlobalConfig) string { return "" },
+		get: func(cfg *GlobalConfig) string { return cfg.Tls.IP },
+		set: func(cfg *GlobalConfig, v string) error { cfg.Tls.IP = v; return nil }},
+	{key: "https.authMethod", env: "HTTPS_AUTH_METHOD", flag: "https-auth", usage: "https authentication method",
+		def: func(cfg *GlobalConfig) string { return "" },
+		get: func(cfg *GlobalConfig) string { return cfg.Tls.AuthMethod },
+		set: func(cfg *GlobalConfig, v string) error { cfg.Tls.AuthMethod = v; return nil }},
+	{key: "tlsCert", env: "TLS_CERT", flag: "tls-cert", usage: "tls certificate file",
+		def: func(cfg *GlobalConfig) string { return "" },
+		get: func(cfg *GlobalConfig) string { return cfg.TLSCert },
+		set: func(cfg *GlobalConfig, v string) error { cfg.TLSCert = v; return nil }},
+	{key: "tlsKey", env: "TLS_KEY", flag: "tls-key", usage: "tls key file", secret: true,
+		def: func(cfg *GlobalConfig) string { return "" },
+		get: func(cfg *GlobalConfig) string { return cfg.TLSKey },
+		set: func(cfg *GlobalConfig, v string) error { cfg.TLSKey = v; return nil }},
+	{key: "log", env: "LOG", flag: "log", usage: "stdout, stderr, file path or empty to disable",
+		def: func(cfg *GlobalConfig) string { return "stdout" },
+		get: func(cfg *GlobalConfig) string { return cfg.Log },
+		set: func(cfg *GlobalConfig, v string) error { cfg.Log = v; return nil }},
+	{key: "externalShareHost", env: "EXTERNAL_SHARE_HOST", flag: "external-share-host", usage: "http://host:port for external share links",
+		def: func(cfg *GlobalConfig) string { return "http://127.0.0.1:8999" },
+		get: func(cfg *GlobalConfig) string { return cfg.ExternalShareHost },
+		set: func(cfg *GlobalConfig, v string) error { cfg.ExternalShareHost = v; return nil }},
+	{key: "preview.scriptPath", env: "PREVIEW_SCRIPT", flag: "preview-script", usage: "preview generation script",
+		def: func(cfg *GlobalConfig) string { return filepath.Join(confDir(cfg), "bfconvert.sh") },
+		get: func(cfg *GlobalConfig) string { return cfg.ScriptPath },
+		set: func(cfg *GlobalConfig, v string) error { cfg.ScriptPath = v; return nil }},
+	{key: "preview.threads", env: "PREVIEW_THREADS", flag: "preview-threads", usage: "preview generation threads",
+		def: func(cfg *GlobalConfig) string { return "2" },
+		get: func(cfg *GlobalConfig) string { return strconv.Itoa(cfg.Threads) },
+		set: func(cfg *GlobalConfig, v string) error { return setInt(&cfg.Threads, v) }},
+	{key: "auth.header", env: "AUTH_HEADER", flag: "auth-header", usage: "user name header for proxy authentication",
+		def: func(cfg *GlobalConfig) string { return "X-Forwarded-User" },
+		get: func(cfg *GlobalConfig) string { return cfg.Header },
+		set: func(cfg *GlobalConfig, v string) error { cfg.Header = v; return nil }},
+	{key: "trustedProxies", env: "TRUSTED_PROXIES", flag: "trusted-proxies", usage: "comma separated addresses or networks of reverse proxies",
+		def: func(cfg *GlobalConfig) string { return "" },
+		get: func(cfg *GlobalConfig) string { return strings.Join(cfg.TrustedProxies, ",") },
+		set: func(cfg *GlobalConfig, v string) error { cfg.TrustedProxies = splitList(v); return nil }},
+	{key: "secretsPath", env: "SECRETS", flag: "secrets", usage: "file with salt key, password hashes and other secrets",
+		def: func(cfg *GlobalConfig) string { return filepath.Join(confDir(cfg), SECRETS_FILE) },
+		get: func(cfg *GlobalConfig) string { return cfg.SecretsPath },
+		set: func(cfg *GlobalConfig, v string) error { cfg.SecretsPath = v; return nil }},
+	{key: "auth.key", env: "AUTH_KEY", secret: true,
+		def: func(cfg *GlobalConfig) string { return "" },
+		get: func(cfg *GlobalConfig) string { return cfg.Auth.Key },
+		set: func(cfg *GlobalConfig, v string) error { cfg.Auth.Key = v; return nil }},
+	{key: "captchaConfig.secret", env: "CAPTCHA_SECRET", secret: true,
+		def: func(cfg *GlobalConfig) string { return "" },
+		get: func(cfg *GlobalConfig) string { return cfg.CaptchaConfig.Secret },
+		set: func(cfg *GlobalConfig, v string) error { cfg.CaptchaConfig.Secret = v; return nil }},
+}
+
+//collects flag values into config overrides
+type flagValue struct {
+	cfg *GlobalConfig
+	s   *setting
+}
+
+func (f *flagValue) String() string {
+	return ""
+}
+func (f *flagValue) Set(v string) error {
+	f.cfg.flags = append(f.cfg.flags, &override{f.s.key, v, SRC_FLAG})
+	return nil
+}
+
+//register command line flags for overridable settings, values are applied by ReadConfigFile
+func (cfg *GlobalConfig) BindFlags(fs *flag.FlagSet) {
+	for _, s := range settings {
+		//secrets are not accepted from command line, it is visible to other users
+		if len(s.flag) == 0 {
+			continue
+		}
+		fs.Var(&flagValue{cfg, s}, s.flag, s.usage+", env "+ENV_PREFIX+s.env)
+	}
+}
+
+func findSetting(key string) *setting {
+	for _, s := range settings {
+		if s.key == key {
+			return s
+		}
+	}
+	return nil
+}
+
+//allocate config sections that missed at file
+func (cfg *GlobalConfig) ensureSections() {
+	if cfg.Http == nil {
+		cfg.Http = &ListenConf{}
+	}
+	if cfg.Tls == nil {
+		cfg.Tls = &ListenConf{}
+	}
+	if cfg.PreviewConf == nil {
+		cfg.PreviewConf = &PreviewConf{}
+	}
+	if cfg.CaptchaConfig == nil {
+		cfg.CaptchaConfig = &CaptchaConfig{}
+	}
+	if cfg.Auth == nil {
+		cfg.Auth = &Auth{}
+	}
+}
+
+//apply defaults for values missed at file, after environment variables and flags
+func (cfg *GlobalConfig) applyLayers() error {
+	cfg.ensureSections()
+	for _, s := range settings {
+		if !cfg.fileKeys[s.key] {
+			if err := s.set(cfg, s.def(cfg)); err != nil {
+				return err
+			}
+		}
+	}
+	if cfg.overrides == nil {
+		for _, s := range settings {
+			if v, ok := os.LookupEnv(ENV_PREFIX + s.env); ok {
+				cfg.overrides = append(cfg.overrides, &override{s.key, v, SRC_ENV})
+			} else if p, ok := os.LookupEnv(ENV_PREFIX + s.env + "_FILE"); ok {
+				//value from mounted secret
+				b, err := ioutil.ReadFile(p)
+				if err != nil {
+					return ValidationError{"env " + ENV_PREFIX + s.env + "_FILE : " + err.Error()}
+				}
+				cfg.overrides = append(cfg.overrides, &override{s.key, strings.TrimSpace(string(b)), SRC_ENV})
+			}
+		}
+		//flags have the highest priority, so applied last
+		cfg.overrides = append(cfg.overrides, cfg.flags...)
+	}
+	if err := cfg.loadSecrets(); err != nil {
+		return err
+	}
+	cfg.original = make(map[string]string)
+	for _, o := range cfg.overrides {
+		s := findSetting(o.key)
+		if _, ok := cfg.original[o.key]; !ok {
+			cfg.original[o.key] = s.get(cfg)
+		}
+		if err := s.set(cfg, o.value); err != nil {
+			return ValidationError{o.source + " " + o.key + " : " + err.Error()}
+		}
+	}
+	return nil
+}
+
+//restore file values for settings, that overridden by environment or flags and was not changed at runtime
+func (cfg *GlobalConfig) withoutOverrides() *GlobalConfig {
+	if len(cfg.overrides) == 0 {
+		return cfg
+	}
+	c := *cfg
+	c.Http = cfg.Http.copy()
+	c.Tls = cfg.Tls.copy()
+	c.Auth = cfg.copyAuth()
+	c.CaptchaConfig = cfg.copyCaptchaConfig()
+	prev := *cfg.PreviewConf
+	c.PreviewConf = &prev
+	for _, o := range cfg.overrides {
+		s := findSetting(o.key)
+		if s.get(cfg) == o.value {
+			_ = s.set(&c, cfg.original[o.key])
+		}
+	}
+	return &c
+}
+
+//effective values of overridable settings with their origin
+func (cfg *GlobalConfig) EffectiveValues() (res []*ConfigValue) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	for _, s := range settings {
+		v := &ConfigValue{Key: s.key, Value: s.get(cfg), Source: SRC_DEFAULT, Env: ENV_PREFIX + s.env}
+		if len(s.flag) > 0 {
+			v.Flag = "-" + s.flag
+		}
+		if s.secret && len(v.Value) > 0 {
+			v.Value = SECRET_MASK
+		}
+		if cfg.fileKeys[s.key] {
+			v.Source = SRC_FILE
+		}
+		for _, o := range cfg.overrides {
+			if o.key == s.key {
+				v.Source = o.source
+			}
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+//flatten nested json object keys into paths, like http.port
+func flatKeys(raw map[string]interface{}, prefix string, res map[string]bool) map[string]bool {
+	for k, v := range raw {
+		res[prefix+k] = true
+		if m, ok := v.(map[string]interface{}); ok {
+			flatKeys(m, prefix+k+".", res)
+		}
+	}
+	return res
+}
+
+//config file path from environment, empty if not set
+func EnvConfigPath() string {
+	return strings.TrimSpace(os.Getenv(ENV_PREFIX + "CONFIG"))
+}
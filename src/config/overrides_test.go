@@ -0,0 +1,63 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigLayers(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bf_")
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "bf.json")
+	conf := `{"schemaVersion": 1, "filesPath": "` + dir + `", "log": "stderr", "http": {"port": 8100, "ip": "0.0.0.0"},
+		"users": [{"username": "admin", "admin": true, "password": "$2a$10$x"}]}`
+	if err := ioutil.WriteFile(p, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Setenv(ENV_PREFIX+"HTTP_PORT", "8200")
+	_ = os.Setenv(ENV_PREFIX+"EXTERNAL_SHARE_HOST", "http://env")
+	defer os.Unsetenv(ENV_PREFIX + "HTTP_PORT")
+	defer os.Unsetenv(ENV_PREFIX + "EXTERNAL_SHARE_HOST")
+
+	cfg := &GlobalConfig{Path: p}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.BindFlags(fs)
+	if err := fs.Parse([]string{"-external-share-host", "http://flag"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg.ReadConfigFile()
+
+	if cfg.Http.Port != 8200 {
+		t.Error("env must override file, got", cfg.Http.Port)
+	}
+	if cfg.ExternalShareHost != "http://flag" {
+		t.Error("flag must override env, got", cfg.ExternalShareHost)
+	}
+	src := make(map[string]string)
+	for _, v := range cfg.EffectiveValues() {
+		src[v.Key] = v.Source
+	}
+	exp := map[string]string{"http.port": SRC_ENV, "externalShareHost": SRC_FLAG, "log": SRC_FILE, "http.ip": SRC_FILE, "preview.threads": SRC_DEFAULT}
+	for k, s := range exp {
+		if src[k] != s {
+			t.Errorf("%s source must be %s, got %s", k, s, src[k])
+		}
+	}
+	if cfg.Threads != 2 {
+		t.Error("default must be applied for missed value")
+	}
+
+	//overrides must not leak into config file
+	cfg.WriteConfig()
+	b, _ := ioutil.ReadFile(p)
+	if strings.Contains(string(b), "8200") || strings.Contains(string(b), "http://flag") {
+		t.Error("overridden values must not be written to the file")
+	}
+	if !strings.Contains(string(b), "8100") {
+		t.Error("file value must be kept")
+	}
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/lib/utils"
+	"sync"
+)
+
+//storage limits, 0 - not limited
+type Quota struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+//used storage of user and effective limits
+type Usage struct {
+	Bytes      int64 `json:"bytes"`
+	Files      int64 `json:"files"`
+	QuotaBytes int64 `json:"quotaBytes"`
+	QuotaFiles int64 `json:"quotaFiles"`
+}
+
+//usage by username, counted lazily from the user home and updated by deltas after
+var usage = struct {
+	sync.Mutex
+	m map[string]*Usage
+	//writes with reserved usage in progress by username, disk is not counted while any runs
+	pending map[string]int
+	//users that are counted again, once their pending writes finish
+	stale map[string]bool
+}{m: make(map[string]*Usage), pending: make(map[string]int), stale: make(map[string]bool)}
+
+func (q *Quota) copyQuota() *Quota {
+	if q == nil {
+		return nil
+	}
+	res := *q
+	return &res
+}
+
+//user quota, or global default in case user has no own
+func (cfg *GlobalConfig) GetQuota(username string) Quota {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	if u, ok := usersRam[username]; ok && u.Quota != nil {
+		return *u.Quota
+	}
+	if cfg.DefaultQuota != nil {
+		return *cfg.DefaultQuota
+	}
+	return Quota{}
+}
+
+//current usage of user with effective quota
+func (cfg *GlobalConfig) GetUsage(username string) Usage {
+	q := cfg.GetQuota(username)
+	usage.Lock()
+	defer usage.Unlock()
+	res := *cfg.countUsage(username)
+	res.QuotaBytes, res.QuotaFiles = q.Bytes, q.Files
+
+	return res
+}
+
+//usage of user, counted from home in case it is not known yet. Should be called under usage lock
+func (cfg *GlobalConfig) countUsage(username string) *Usage {
+	u, ok := usage.m[username]
+	if !ok {
+		u = &Usage{}
+		u.Bytes, u.Files = utils.DirSize(cfg.GetUserHomePath(username))
+		usage.m[username] = u
+	}
+	return u
+}
+
+//account change of user storage, negative values for removed data
+func (cfg *GlobalConfig) AddUsage(username string, bytes, files int64) {
+	usage.Lock()
+	defer usage.Unlock()
+	if u, ok := usage.m[username]; ok {
+		u.Bytes += bytes
+		u.Files += files
+		if u.Bytes < 0 || u.Files < 0 {
+			delete(usage.m, username)
+		}
+	}
+}
+
+//drop counted usage, it will be counted again on next request. In case writes of the user are in progress,
+//count is dropped once they finish, since disk holds part of their reserved data only
+func (cfg *GlobalConfig) ResetUsage(username string) {
+	usage.Lock()
+	defer usage.Unlock()
+	if usage.pending[username] > 0 {
+		usage.stale[username] = true
+		return
+	}
+	delete(usage.m, username)
+}
+
+//start write, that reserves usage of the user. EndWrite must follow
+func (cfg *GlobalConfig) BeginWrite(username string) {
+	usage.Lock()
+	defer usage.Unlock()
+	usage.pending[username]++
+}
+
+//finish write started by BeginWrite, delayed reset of usage is applied after last one
+func (cfg *GlobalConfig) EndWrite(username string) {
+	usage.Lock()
+	defer usage.Unlock()
+	if usage.pending[username]--; usage.pending[username] > 0 {
+		return
+	}
+	delete(usage.pending, username)
+	if usage.stale[username] {
+		delete(usage.stale, username)
+		delete(usage.m, username)
+	}
+}
+
+//returns cnst.ErrQuotaExceeded in case user can't store more bytes and files
+func (cfg *GlobalConfig) CheckQuota(username string, bytes, files int64) error {
+	u := cfg.GetUsage(username)
+	if u.QuotaBytes > 0 && bytes > 0 && u.Bytes+bytes > u.QuotaBytes {
+		return cnst.ErrQuotaExceeded
+	}
+	if u.QuotaFiles > 0 && files > 0 && u.Files+files > u.QuotaFiles {
+		return cnst.ErrQuotaExceeded
+	}
+	return nil
+}
+
+//check and count bytes and files, that are going to be written, at once. So concurrent writes can't exceed quota together.
+//Reservation is released by AddUsage with negative values, or by ResetUsage in case write failed.
+//Write should be wrapped by BeginWrite and EndWrite, so usage is not counted again while it runs
+func (cfg *GlobalConfig) ReserveQuota(username string, bytes, files int64) error {
+	q := cfg.GetQuota(username)
+	usage.Lock()
+	defer usage.Unlock()
+	u := cfg.countUsage(username)
+	if q.Bytes > 0 && bytes > 0 && u.Bytes+bytes > q.Bytes {
+		return cnst.ErrQuotaExceeded
+	}
+	if q.Files > 0 && files > 0 && u.Files+files > q.Files {
+		return cnst.ErrQuotaExceeded
+	}
+	u.Bytes += bytes
+	u.Files += files
+	return nil
+}
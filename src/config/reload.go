@@ -0,0 +1,122 @@
+package config
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//called after config was reloaded from disk, uses to rebuild runtime state like dav handlers, password hashes
+var OnReload func(cfg *GlobalConfig)
+
+//checksum of the config file content that was read or written by this process, prevents reload of own writes
+var fileSum [sha256.Size]byte
+
+//read config file at cfg.Path, validate it and apply live, old config stays in case of any error
+func (cfg *GlobalConfig) Reload() error {
+	mod := &GlobalConfig{Path: cfg.Path}
+	if err := mod.parseConf(cfg.Path); err != nil {
+		return err
+	}
+	mod.overrides = cfg.overrides
+	if err := mod.applyLayers(); err != nil {
+		return err
+	}
+	if !cfg.isJsonStore() {
+		//users are not part of config file, store can't be switched at runtime
+		users, err := cfg.store.Users()
+		if err != nil {
+			return err
+		}
+		mod.Users = users
+	}
+	if err := mod.Validate(); err != nil {
+		return err
+	}
+	//keep current salt key, otherwise all issued tokens became invalid
+	if len(mod.Auth.Key) == 0 {
+		mod.Auth.Key = cfg.Auth.Key
+	}
+
+	cfg.UpdateConfig(mod)
+	cfg.replaceUsers(mod.Users)
+	updateLock.Lock()
+	//access of members to shares is resolved at request time
+	cfg.Groups = mod.Groups
+	cfg.fileKeys, cfg.original = mod.fileKeys, mod.original
+	updateLock.Unlock()
+
+	cfg.setupLog()
+	cfg.Verify()
+	cfg.setUpPaths()
+	if mod.migrated {
+		cfg.WriteConfig()
+	}
+	if OnReload != nil {
+		OnReload(cfg)
+	}
+
+	return nil
+}
+
+//swap users list, keep runtime state of existing users
+func (cfg *GlobalConfig) replaceUsers(users []*UserConfig) {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	for _, u := range users {
+		if old, ok := usersRam[u.Username]; ok {
+			u.DavHandler = old.DavHandler
+		}
+	}
+	cfg.Users = users
+	cfg.RefreshUserRam()
+}
+
+//true in case config file at disk differs from the one was read or written by this process
+func (cfg *GlobalConfig) isFileChanged() bool {
+	b, err := ioutil.ReadFile(cfg.Path)
+	if err != nil {
+		return false
+	}
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+
+	return sha256.Sum256(b) != fileSum
+}
+
+//watch config file for changes by polling it with given interval, also reload config on SIGHUP. Blocks forever
+func (cfg *GlobalConfig) WatchConfig(interval time.Duration) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var lastMod time.Time
+	if inf, err := os.Stat(cfg.Path); err == nil {
+		lastMod = inf.ModTime()
+	}
+	for {
+		select {
+		case <-sig:
+			log.Println("config : SIGHUP received, reloading", cfg.Path)
+		case <-t.C:
+			inf, err := os.Stat(cfg.Path)
+			if err != nil || inf.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = inf.ModTime()
+			if !cfg.isFileChanged() {
+				continue
+			}
+			log.Println("config : file changed, reloading", cfg.Path)
+		}
+		if err := cfg.Reload(); err != nil {
+			log.Println("config : reload failed, keeping current config :", err)
+		} else {
+			log.Println("config : reloaded", cfg.Path)
+		}
+	}
+}
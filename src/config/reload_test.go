@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReloadAddUser(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+	if cfg.isFileChanged() {
+		t.Fatal("own write must not be detected as change")
+	}
+	reloaded := false
+	OnReload = func(c *GlobalConfig) {
+		reloaded = true
+	}
+	defer func() { OnReload = nil }()
+
+	mod := cfg.CopyConfig()
+	mod.Users = append(mod.Users, cfg.MakeUser("user3"))
+	mod.Http.Port = 8777
+	writeTestConfig(t, mod)
+	if !cfg.isFileChanged() {
+		t.Fatal("external write must be detected as change")
+	}
+
+	if err := cfg.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded {
+		t.Error("reload hook was not called")
+	}
+	if _, ok := cfg.GetUserByUsername("user3"); !ok {
+		t.Fatal("user3 must be added after reload")
+	}
+	if cfg.Http.Port != 8777 {
+		t.Error("http port was not updated")
+	}
+	if _, err := os.Stat(cfg.GetUserHomePath("user3")); err != nil {
+		t.Error("user3 home must be created", err)
+	}
+	if k, _ := cfg.GetKeyBytes(); len(k) == 0 {
+		t.Error("salt key must be kept")
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+
+	//no admin user
+	mod := cfg.CopyConfig()
+	mod.Users = []*UserConfig{cfg.MakeUser("user3")}
+	writeTestConfig(t, mod)
+	if err := cfg.Reload(); err == nil {
+		t.Fatal("config without admin must be rejected")
+	}
+	if _, ok := cfg.GetUserByUsername("user1"); !ok {
+		t.Fatal("old users must be kept")
+	}
+
+	if err := ioutil.WriteFile(cfg.Path, []byte("{bad json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Reload(); err == nil {
+		t.Fatal("broken json must be rejected")
+	}
+	if cfg.GetAdmin() == nil {
+		t.Fatal("old config must be kept")
+	}
+}
+
+func writeTestConfig(t *testing.T, cfg *GlobalConfig) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(cfg.Path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,267 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+//current version of the config file layout, increase together with new migration step
+const SCHEMA_VERSION = 1
+
+//upgrade raw config from version i to i+1, where i is index at this list
+var migrations = []func(raw map[string]interface{}) error{
+	migrateV0,
+}
+
+//all problems found in config file
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "config : " + strings.Join(e, "; ")
+}
+
+//v0 had no version field, and user with plain password but hashPasswordFirstRun false was not able to login
+func migrateV0(raw map[string]interface{}) error {
+	users, _ := raw["users"].([]interface{})
+	for _, u := range users {
+		usr, ok := u.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		//hash of empty password would allow login without password
+		pwd, _ := usr["password"].(string)
+		if len(pwd) > 0 && !strings.HasPrefix(pwd, "$2") {
+			usr["hashPasswordFirstRun"] = true
+		}
+	}
+	if prev, ok := raw["preview"].(map[string]interface{}); ok {
+		if _, ok = prev["previewOnFirstRun"]; !ok {
+			prev["previewOnFirstRun"] = false
+		}
+	}
+	return nil
+}
+
+//upgrade config file content to the current schema, returns true in case content was changed
+func migrateConf(raw map[string]interface{}) (migrated bool, err error) {
+	v := 0
+	if sv, ok := raw["schemaVersion"].(float64); ok {
+		v = int(sv)
+	}
+	if v > SCHEMA_VERSION {
+		return false, fmt.Errorf("config schema version %d is newer than supported %d", v, SCHEMA_VERSION)
+	}
+	for ; v < SCHEMA_VERSION; v++ {
+		if err = migrations[v](raw); err != nil {
+			return false, fmt.Errorf("config migration from version %d failed : %v", v, err)
+		}
+		raw["schemaVersion"] = v + 1
+		migrated = true
+	}
+	return migrated, nil
+}
+
+//decode config file content, apply migrations and check for unknown keys
+func (cfg *GlobalConfig) decodeConf(p string, b []byte) error {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if unk := unknownKeys(raw, reflect.TypeOf(*cfg), ""); len(unk) > 0 {
+		return ValidationError{"unknown keys " + strings.Join(unk, ", ")}
+	}
+	migrated, err := migrateConf(raw)
+	if err != nil {
+		return err
+	}
+	if migrated && !cfg.checkOnly {
+		bp := fmt.Sprintf("%s.v%v.bak", p, schemaOf(b))
+		if err = ioutil.WriteFile(bp, b, 0600); err != nil {
+			return err
+		}
+		log.Printf("config : migrated %s to schema version %d, original saved at %s", p, SCHEMA_VERSION, bp)
+	}
+	if migrated {
+		if b, err = json.Marshal(raw); err != nil {
+			return err
+		}
+	}
+	cfg.migrated = migrated
+	cfg.fileKeys = flatKeys(raw, "", make(map[string]bool))
+
+	return json.Unmarshal(b, cfg)
+}
+
+func schemaOf(b []byte) int {
+	var v struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	_ = json.Unmarshal(b, &v)
+	return v.SchemaVersion
+}
+
+//list keys from raw json, that does not match any json field of type t
+func unknownKeys(raw map[string]interface{}, t reflect.Type, prefix string) (res []string) {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || len(f.PkgPath) > 0 && !f.Anonymous {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	for k, v := range raw {
+		ft, ok := fields[k]
+		if !ok {
+			res = append(res, prefix+k)
+			continue
+		}
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct {
+			continue
+		}
+		switch val := v.(type) {
+		case map[string]interface{}:
+			res = append(res, unknownKeys(val, ft, prefix+k+".")...)
+		case []interface{}:
+			for i, itm := range val {
+				if m, ok := itm.(map[string]interface{}); ok {
+					res = append(res, unknownKeys(m, ft, fmt.Sprintf("%s%s[%d].", prefix, k, i))...)
+				}
+			}
+		}
+	}
+	return res
+}
+
+//check config values, all problems are reported at once
+func (cfg *GlobalConfig) Validate() error {
+	var res ValidationError
+	if len(cfg.FilesPath) == 0 {
+		res = append(res, "filesPath is empty")
+	} else if inf, err := os.Stat(cfg.FilesPath); err != nil || !inf.IsDir() {
+		res = append(res, "filesPath "+cfg.FilesPath+" does not exist or not a directory")
+	}
+	isHttp := cfg.Http != nil && cfg.Http.Port > 0
+	isTLS := cfg.Tls != nil && cfg.Tls.Port > 0
+	if !isHttp && !isTLS {
+		res = append(res, "no http or https port configured")
+	}
+	for name, l := range map[string]*ListenConf{"http": cfg.Http, "https": cfg.Tls} {
+		if l == nil {
+			continue
+		}
+		if l.Port < 0 || l.Port > 65535 {
+			res = append(res, fmt.Sprintf("%s.port %d out of range", name, l.Port))
+		}
+		if !isAuthMethod(l.AuthMethod) {
+			res = append(res, fmt.Sprintf("%s.authMethod '%s' is not supported", name, l.AuthMethod))
+		}
+	}
+	if isTLS {
+		for key, p := range map[string]string{"tlsCert": cfg.TLSCert, "tlsKey": cfg.TLSKey} {
+			if _, err := os.Stat(p); err != nil {
+				res = append(res, fmt.Sprintf("%s file '%s' does not exist", key, p))
+			}
+		}
+	}
+	if cfg.UsesAuth("oidc") && (cfg.OIDC == nil || len(cfg.OIDC.Issuer) == 0 || len(cfg.OIDC.ClientID) == 0) {
+		res = append(res, "oidc.issuer and oidc.clientId required by oidc auth method")
+	}
+	if cfg.UsesAuth("ldap") && (cfg.LDAP == nil || len(cfg.LDAP.URL) == 0 || len(cfg.LDAP.BaseDN) == 0) {
+		res = append(res, "ldap.url and ldap.baseDN required by ldap auth method")
+	}
+	for _, p := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			res = append(res, fmt.Sprintf("trustedProxies '%s' is neither address nor network", p))
+		}
+	}
+	switch cfg.storeType() {
+	case STORE_JSON:
+	case STORE_BOLT:
+		if inf, err := os.Stat(filepath.Dir(cfg.storePath())); err != nil || !inf.IsDir() {
+			res = append(res, "folder of storage.path "+cfg.storePath()+" does not exist")
+		}
+	default:
+		res = append(res, fmt.Sprintf("storage.type '%s' is not supported", cfg.Storage.Type))
+	}
+	//users of other store are not known during check
+	if !cfg.checkOnly || cfg.storeType() == STORE_JSON {
+		res = append(res, cfg.validateUsers()...)
+	}
+	groups := make(map[string]bool)
+	for _, g := range cfg.Groups {
+		if g == nil || len(g.Name) == 0 {
+			res = append(res, "group with empty name")
+			continue
+		}
+		if groups[g.Name] {
+			res = append(res, "duplicate group "+g.Name)
+		}
+		groups[g.Name] = true
+	}
+	if len(res) > 0 {
+		return res
+	}
+
+	return nil
+}
+
+//problems of users, those are checked only when users are loaded
+func (cfg *GlobalConfig) validateUsers() (res []string) {
+	hasAdmin := false
+	names := make(map[string]bool)
+	for _, u := range cfg.Users {
+		if u == nil || len(u.Username) == 0 {
+			res = append(res, "user with empty username")
+			continue
+		}
+		if names[u.Username] {
+			res = append(res, "duplicate user "+u.Username)
+		}
+		names[u.Username] = true
+		if u.Rules.Validate() != nil {
+			res = append(res, "user "+u.Username+" has path rule with empty path or unknown mode")
+		}
+		hasAdmin = hasAdmin || u.Admin
+	}
+	if !hasAdmin {
+		res = append(res, "at least one admin user required")
+	}
+	return res
+}
+
+//read and validate config file at cfg.Path without applying it, environment and flags are taken into account.
+//Nothing is written, and users store is not opened, since running server holds it
+func (cfg *GlobalConfig) Check() error {
+	mod := &GlobalConfig{Path: cfg.Path, flags: cfg.flags, checkOnly: true}
+	if err := mod.parseConf(cfg.Path); err != nil {
+		return err
+	}
+	if err := mod.applyLayers(); err != nil {
+		return err
+	}
+
+	return mod.Validate()
+}
+
+func isAuthMethod(m string) bool {
+	switch m {
+	case "", "default", "none", "noauth", "proxy", "ip", "oidc", "ldap":
+		return true
+	}
+	return false
+}
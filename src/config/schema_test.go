@@ -0,0 +1,133 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const v0Conf = `{
+    "users": [{"username": "admin", "admin": true, "password": "admin", "hashPasswordFirstRun": false},
+        {"username": "nopwd", "password": ""}, {"username": "nofield"}],
+    "http": {"port": 8999, "ip": "127.0.0.1", "authMethod": "default"},
+    "filesPath": "%s",
+    "preview": {"threads": 1}
+}`
+
+func TestMigrateV0(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bf_")
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "bf.json")
+	if err := ioutil.WriteFile(p, []byte(strings.Replace(v0Conf, "%s", dir, 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &GlobalConfig{Path: p}
+	cfg.ReadConfigFile()
+	if cfg.SchemaVersion != SCHEMA_VERSION {
+		t.Fatal("schema version must be upgraded, but got", cfg.SchemaVersion)
+	}
+	if u, _ := cfg.GetUserByUsername("admin"); !u.FirstRun {
+		t.Error("plain password must be marked for hashing")
+	}
+	//empty password must stay unusable
+	for _, name := range []string{"nopwd", "nofield"} {
+		if u, _ := cfg.GetUserByUsername(name); u.FirstRun || len(u.Password) > 0 {
+			t.Error("empty password must not be hashed", name)
+		}
+	}
+	if _, err := os.Stat(p + ".v0.bak"); err != nil {
+		t.Error("original config must be saved", err)
+	}
+	b, _ := ioutil.ReadFile(p)
+	if !strings.Contains(string(b), `"schemaVersion": 1`) {
+		t.Error("migrated config must be written back")
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bf_")
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "bf.json")
+	conf := `{"schemaVersion": 1, "htpp": {}, "http": {"prot": 1}, "users": [{"username": "admin", "shares": [{"pth": "/"}]}]}`
+	if err := ioutil.WriteFile(p, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &GlobalConfig{}
+	err := cfg.parseConf(p)
+	if err == nil {
+		t.Fatal("unknown keys must be reported")
+	}
+	for _, k := range []string{"htpp", "http.prot", "users[0].shares[0].pth"} {
+		if !strings.Contains(err.Error(), k) {
+			t.Error("unknown key not reported", k)
+		}
+	}
+	conf = `{"schemaVersion": 99}`
+	_ = ioutil.WriteFile(p, []byte(conf), 0600)
+	if err = cfg.parseConf(p); err == nil {
+		t.Error("newer schema must be rejected")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := TContext{}
+	cfg.Init()
+	defer cfg.Clean(t)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	mod := cfg.CopyConfig()
+	mod.Http.Port = 70000
+	mod.Tls = &ListenConf{Port: 443, AuthMethod: "magic"}
+	mod.TLSCert = filepath.Join(cfg.ConfigPath, "missing.crt")
+	mod.FilesPath = filepath.Join(cfg.ConfigPath, "missing")
+	err := mod.Validate()
+	if err == nil {
+		t.Fatal("config must be invalid")
+	}
+	verr := err.(ValidationError)
+	if len(verr) != 5 {
+		t.Error("all problems must be reported, but got", verr)
+	}
+}
+
+func TestCheckNoSideEffects(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bf_")
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "bf.json")
+	//v0 file with users that would be migrated into bolt store
+	conf := strings.Replace(v0Conf, "%s", dir, 1)
+	conf = strings.Replace(conf, `"preview"`, `"storage": {"type": "bolt"}, "preview"`, 1)
+	if err := ioutil.WriteFile(p, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = ioutil.WriteFile(filepath.Join(dir, SECRETS_FILE), []byte(`{}`), 0644)
+	cfg := &GlobalConfig{Path: p}
+	if err := cfg.Check(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Error("check must not create files", len(files))
+	}
+	if b, _ := ioutil.ReadFile(p); string(b) != conf {
+		t.Error("check must not rewrite config")
+	}
+	if inf, _ := os.Stat(filepath.Join(dir, SECRETS_FILE)); inf.Mode().Perm() != 0644 {
+		t.Error("check must not change secrets file")
+	}
+
+	//store settings are validated without opening it
+	conf = strings.Replace(conf, `"type": "bolt"`, `"type": "bolt", "path": "`+filepath.Join(dir, "missing", "bf.db")+`"`, 1)
+	_ = ioutil.WriteFile(p, []byte(conf), 0600)
+	if err := cfg.Check(); err == nil || !strings.Contains(err.Error(), "storage.path") {
+		t.Error("missing store folder must be reported", err)
+	}
+	conf = strings.Replace(conf, `"type": "bolt"`, `"type": "mongo"`, 1)
+	_ = ioutil.WriteFile(p, []byte(conf), 0600)
+	if err := cfg.Check(); err == nil || !strings.Contains(err.Error(), "storage.type") {
+		t.Error("unknown store must be reported", err)
+	}
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"encoding/json"
+	"github.com/browsefile/backend/src/lib/utils"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//default secrets file name, kept next to the config file
+const SECRETS_FILE = "browsefile.secrets.json"
+
+//permissions for files that contain secrets
+const PERM_SECRET = 0600
+
+//shown to the client instead of secret value
+const SECRET_MASK = "******"
+
+//values that must not be readable by others, kept apart from the config file
+type Secrets struct {
+	//jwt signing key
+	Key           string `json:"key,omitempty"`
+	CaptchaSecret string `json:"captchaSecret,omitempty"`
+	TLSKey        string `json:"tlsKey,omitempty"`
+	//client secret of identity provider
+	OIDCSecret string `json:"oidcSecret,omitempty"`
+	//password of directory search account
+	LDAPBindPassword string `json:"ldapBindPassword,omitempty"`
+	//password hashes by username, in case users are kept at config file
+	Passwords map[string]string `json:"passwords,omitempty"`
+	//share password hashes by owner:path
+	SharePasswords map[string]string `json:"sharePasswords,omitempty"`
+	//second factor by username
+	TOTP map[string]*TOTPConfig `json:"totp,omitempty"`
+	//access token hashes by username:id
+	Tokens map[string]string `json:"tokens,omitempty"`
+	//app password hashes of older versions by username:id, dropped once migrated into tokens
+	AppPasswords map[string]string `json:"appPasswords,omitempty"`
+}
+
+//secrets file path, environment and flag take precedence over config file
+func (cfg *GlobalConfig) secretsPath() string {
+	p := cfg.SecretsPath
+	for _, o := range cfg.overrides {
+		if o.key == "secretsPath" {
+			p = o.value
+		}
+	}
+	if len(p) == 0 {
+		p = filepath.Join(confDir(cfg), SECRETS_FILE)
+	}
+	return p
+}
+
+//fill secrets that missed at config file from secrets file, secrets found at config file will be moved on next write
+func (cfg *GlobalConfig) loadSecrets() error {
+	p := cfg.secretsPath()
+	b, err := ioutil.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s := &Secrets{}
+	if len(b) > 0 {
+		if err = json.Unmarshal(b, s); err != nil {
+			return ValidationError{"secrets file " + p + " : " + err.Error()}
+		}
+		if inf, err := os.Stat(p); err == nil && inf.Mode().Perm()&0077 != 0 && cfg.checkOnly {
+			log.Printf("config : secrets file %s is accessible by others", p)
+		} else if err == nil && inf.Mode().Perm()&0077 != 0 {
+			log.Printf("config : secrets file %s is accessible by others, fixing permissions", p)
+			_ = os.Chmod(p, PERM_SECRET)
+		}
+	}
+	inFile := false
+	fill := func(v *string, sv, key string) {
+		if len(*v) > 0 {
+			inFile = true
+		} else if len(sv) > 0 {
+			*v = sv
+			if cfg.fileKeys != nil {
+				cfg.fileKeys[key] = true
+			}
+		}
+	}
+	fill(&cfg.Auth.Key, s.Key, "auth.key")
+	fill(&cfg.CaptchaConfig.Secret, s.CaptchaSecret, "captchaConfig.secret")
+	fill(&cfg.TLSKey, s.TLSKey, "tlsKey")
+	if cfg.OIDC != nil {
+		fill(&cfg.OIDC.ClientSecret, s.OIDCSecret, "oidc.clientSecret")
+	}
+	if cfg.LDAP != nil {
+		fill(&cfg.LDAP.BindPassword, s.LDAPBindPassword, "ldap.bindPassword")
+	}
+	for _, u := range cfg.Users {
+		fill(&u.Password, s.Passwords[u.Username], "")
+		for _, shr := range u.Shares {
+			fill(&shr.Password, s.SharePasswords[sharePasswordKey(u.Username, shr.Path)], "")
+		}
+		if u.TOTP != nil && len(u.TOTP.Secret) > 0 {
+			inFile = true
+		} else if t, ok := s.TOTP[u.Username]; ok {
+			u.TOTP = t
+		}
+		for _, t := range u.Tokens {
+			fill(&t.Hash, s.Tokens[u.Username+":"+t.ID], "")
+		}
+		for _, ap := range u.AppPasswords {
+			fill(&ap.Hash, s.AppPasswords[u.Username+":"+ap.ID], "")
+		}
+	}
+	if inFile && cfg.fileKeys != nil {
+		cfg.migrated = true
+	}
+
+	return nil
+}
+
+//split config into secrets and copy of config without them
+func (cfg *GlobalConfig) splitSecrets(withUsers bool) (*Secrets, *GlobalConfig) {
+	c := *cfg
+	s := &Secrets{Key: cfg.Auth.Key, CaptchaSecret: cfg.CaptchaConfig.Secret, TLSKey: cfg.TLSKey}
+	c.Auth = &Auth{Header: cfg.Auth.Header}
+	c.CaptchaConfig = &CaptchaConfig{Host: cfg.CaptchaConfig.Host, Key: cfg.CaptchaConfig.Key}
+	c.TLSKey = ""
+	if c.OIDC = cfg.OIDC.copyOIDC(); c.OIDC != nil {
+		s.OIDCSecret = c.OIDC.ClientSecret
+		c.OIDC.ClientSecret = ""
+	}
+	if c.LDAP = cfg.LDAP.copyLDAP(); c.LDAP != nil {
+		s.LDAPBindPassword = c.LDAP.BindPassword
+		c.LDAP.BindPassword = ""
+	}
+	c.Users = nil
+	if withUsers {
+		s.Passwords = make(map[string]string)
+		s.SharePasswords = make(map[string]string)
+		s.TOTP = make(map[string]*TOTPConfig)
+		s.Tokens = make(map[string]string)
+		for _, u := range cfg.Users {
+			cu := *u
+			cu.Password = ""
+			s.Passwords[u.Username] = u.Password
+			cu.Shares = make([]*ShareItem, len(u.Shares))
+			for i, shr := range u.Shares {
+				cs := *shr
+				if shr.IsProtected() {
+					s.SharePasswords[sharePasswordKey(u.Username, shr.Path)] = shr.Password
+					cs.Password = ""
+				}
+				cu.Shares[i] = &cs
+			}
+			if u.TOTP != nil {
+				s.TOTP[u.Username] = u.TOTP
+				cu.TOTP = nil
+			}
+			cu.Tokens = copyTokens(u.Tokens)
+			for _, t := range cu.Tokens {
+				s.Tokens[u.Username+":"+t.ID] = t.Hash
+				t.Hash = ""
+			}
+			c.Users = append(c.Users, &cu)
+		}
+	}
+	return s, &c
+}
+
+func sharePasswordKey(owner, p string) string {
+	return owner + ":" + strings.TrimSuffix(p, "/")
+}
+
+//write secrets file with owner only permissions
+func (cfg *GlobalConfig) writeSecrets(s *Secrets) error {
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	p := cfg.secretsPath()
+	if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(p, b, PERM_SECRET)
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretsSplit(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bf_")
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "bf.json")
+	conf := `{"schemaVersion": 1, "filesPath": "` + dir + `", "log": "stderr", "auth": {"key": "c2VjcmV0a2V5"},
+		"captchaConfig": {"key": "pub", "secret": "captcha-secret"},
+		"oidc": {"issuer": "https://idp", "clientId": "bf", "clientSecret": "oidc-secret"},
+		"ldap": {"url": "ldap://dir", "baseDN": "dc=org", "bindDN": "cn=svc", "bindPassword": "ldap-secret"},
+		"users": [{"username": "admin", "admin": true, "password": "$2a$10$hash",
+		"shares": [{"path": "/docs", "allowExternal": true, "password": "$2a$10$shr"}]}]}`
+	if err := ioutil.WriteFile(p, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.MkdirAll(filepath.Join(dir, "admin", "files", "docs"), 0755)
+	cfg := &GlobalConfig{Path: p}
+	cfg.ReadConfigFile()
+
+	//secrets must be moved out of the config file
+	b, _ := ioutil.ReadFile(p)
+	for _, s := range []string{"c2VjcmV0a2V5", "captcha-secret", "$2a$10$hash", "$2a$10$shr", "oidc-secret", "ldap-secret"} {
+		if strings.Contains(string(b), s) {
+			t.Error("config file must not contain secret", s)
+		}
+	}
+	sp := filepath.Join(dir, SECRETS_FILE)
+	inf, err := os.Stat(sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inf.Mode().Perm() != PERM_SECRET {
+		t.Error("secrets file must be readable by owner only, got", inf.Mode().Perm())
+	}
+	sec := &Secrets{}
+	b, _ = ioutil.ReadFile(sp)
+	if err = json.Unmarshal(b, sec); err != nil {
+		t.Fatal(err)
+	}
+	if sec.Key != "c2VjcmV0a2V5" || sec.CaptchaSecret != "captcha-secret" || sec.Passwords["admin"] != "$2a$10$hash" ||
+		sec.SharePasswords["admin:/docs"] != "$2a$10$shr" || sec.OIDCSecret != "oidc-secret" ||
+		sec.LDAPBindPassword != "ldap-secret" {
+		t.Error("secrets must be written to the secrets file", sec)
+	}
+
+	//secrets are read back from secrets file
+	cfg2 := &GlobalConfig{Path: p}
+	cfg2.ReadConfigFile()
+	if cfg2.Auth.Key != "c2VjcmV0a2V5" || cfg2.CaptchaConfig.Secret != "captcha-secret" || cfg2.OIDC.ClientSecret != "oidc-secret" ||
+		cfg2.LDAP.BindPassword != "ldap-secret" {
+		t.Error("secrets must be loaded from secrets file")
+	}
+	if u, _ := cfg2.GetUserByUsername("admin"); u.Password != "$2a$10$hash" || u.Shares[0].Password != "$2a$10$shr" {
+		t.Error("password hash must be loaded from secrets file")
+	}
+
+	//never reach the client
+	c := cfg2.CopyConfig()
+	if len(c.Auth.Key) > 0 || len(c.CaptchaConfig.Secret) > 0 || len(c.Users[0].Password) > 0 || len(c.OIDC.ClientSecret) > 0 || len(c.LDAP.BindPassword) > 0 ||
+		c.Users[0].Shares[0].Password != SECRET_MASK {
+		t.Error("copy must not contain secrets")
+	}
+	for _, v := range cfg2.EffectiveValues() {
+		if v.Key == "auth.key" && v.Value == cfg2.Auth.Key {
+			t.Error("secret value must be masked")
+		}
+	}
+	//update from client keeps secrets
+	cfg2.UpdateConfig(c)
+	if cfg2.Auth.Key != "c2VjcmV0a2V5" || cfg2.CaptchaConfig.Secret != "captcha-secret" || cfg2.OIDC.ClientSecret != "oidc-secret" ||
+		cfg2.LDAP.BindPassword != "ldap-secret" {
+		t.Error("empty secrets from client must keep current values")
+	}
+}
+
+func TestSecretsEnvFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bf_")
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "bf.json")
+	conf := `{"schemaVersion": 1, "filesPath": "` + dir + `", "log": "stderr",
+		"users": [{"username": "admin", "admin": true, "password": "$2a$10$hash"}]}`
+	if err := ioutil.WriteFile(p, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kp := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(kp, []byte("bW91bnRlZA==\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Setenv(ENV_PREFIX+"AUTH_KEY_FILE", kp)
+	defer os.Unsetenv(ENV_PREFIX + "AUTH_KEY_FILE")
+
+	cfg := &GlobalConfig{Path: p}
+	cfg.ReadConfigFile()
+	if cfg.Auth.Key != "bW91bnRlZA==" {
+		t.Fatal("key must be read from *_FILE, got", cfg.Auth.Key)
+	}
+	cfg.WriteConfig()
+	b, _ := ioutil.ReadFile(filepath.Join(dir, SECRETS_FILE))
+	if strings.Contains(string(b), "bW91bnRlZA==") {
+		t.Error("mounted secret must not be copied into secrets file")
+	}
+}
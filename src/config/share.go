@@ -5,10 +5,20 @@ import (
 	"encoding/base64"
 	"github.com/browsefile/backend/src/cnst"
 	"github.com/browsefile/backend/src/lib/utils"
-	"log"
-	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+)
+
+//permissions of local share consumer
+const (
+	//list and download only
+	SHARE_READ = "read"
+	//also create and modify files
+	SHARE_WRITE = "write"
+	//also delete, rename and move files
+	SHARE_FULL = "full"
 )
 
 //presents 1 share Path in filesystem, and access rules
@@ -20,8 +30,30 @@ type ShareItem struct {
 	AllowLocal bool `json:"allowLocal"`
 	//allowed by only specific users
 	AllowUsers []string `json:"allowedUsers"`
-	//uses for external DMZ share request
-	Hash string `json:"-"`
+	//allowed for members of groups
+	AllowGroups []string `json:"allowedGroups,omitempty"`
+	//identifies share at local links and unlock tokens, legacy external links used it as well. Kept when share is moved
+	Hash string `json:"hash,omitempty"`
+	//external links, each with own random token
+	Links []*ShareLink `json:"links,omitempty"`
+	//guests only upload files, content is never shown to them
+	Drop *DropConfig `json:"drop,omitempty"`
+	//permission per consumer username, SHARE_READ in case not set
+	Permissions map[string]string `json:"permissions,omitempty"`
+	//share can't be accessed before this time
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	//share with its links is deleted after this time
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	//share is deleted after this number of downloads, 0 - not limited
+	MaxDownloads int `json:"maxDownloads,omitempty"`
+	//downloads counted so far, only in case MaxDownloads set
+	Downloads int `json:"downloads,omitempty"`
+	//downloads being served now, reserved against MaxDownloads
+	pending int
+	//password hash, external share must be unlocked by it
+	Password string `json:"password,omitempty"`
+	//source was found missing at this time, share is deleted in case source does not come back during SHARE_STALE_GRACE
+	Stale *time.Time `json:"stale,omitempty"`
 }
 type AllowedShare struct {
 	*UserConfig
@@ -36,7 +68,7 @@ func (shr *ShareItem) IsAllowed(user string) (res bool) {
 
 	if ok && shr.AllowLocal && !usr.IsGuest() {
 		res = true
-	} else if shr.AllowExternal && len(shr.Hash) > 0 && user == cnst.GUEST {
+	} else if shr.AllowExternal && len(shr.Links) > 0 && user == cnst.GUEST {
 		res = true
 	} else {
 		for _, uname := range shr.AllowUsers {
@@ -45,9 +77,74 @@ func (shr *ShareItem) IsAllowed(user string) (res bool) {
 				break
 			}
 		}
+		if !res && ok && !usr.IsGuest() {
+			res = config.inGroups(user, shr.AllowGroups)
+		}
 	}
 
-	return
+	return res && shr.checkActive(time.Now()) == nil
+}
+
+//true in case external share must be unlocked by password
+func (shr *ShareItem) IsProtected() bool {
+	return len(shr.Password) > 0
+}
+
+//hide password hash, before share is sent to the client
+func (shr *ShareItem) MaskPassword() {
+	if shr.IsProtected() {
+		shr.Password = SECRET_MASK
+	}
+}
+
+//cnst.ErrInvalidOption in case share lifetime or downloads limit are wrong
+func (shr *ShareItem) Validate() error {
+	if shr.MaxDownloads < 0 || shr.NotBefore != nil && shr.ExpiresAt != nil && !shr.ExpiresAt.After(*shr.NotBefore) {
+		return cnst.ErrInvalidOption
+	}
+	for _, p := range shr.Permissions {
+		if p != SHARE_READ && p != SHARE_WRITE && p != SHARE_FULL {
+			return cnst.ErrInvalidOption
+		}
+	}
+	if shr.IsDrop() {
+		return shr.Drop.Validate()
+	}
+	return nil
+}
+
+//permission of local consumer, guests can only read
+func (shr *ShareItem) Permission(user string) string {
+	if p, ok := shr.Permissions[user]; ok && user != cnst.GUEST {
+		return p
+	}
+	return SHARE_READ
+}
+
+//true in case share lifetime or downloads limit is over
+func (shr *ShareItem) isExpired(now time.Time) bool {
+	return shr.ExpiresAt != nil && !now.Before(*shr.ExpiresAt) ||
+		shr.MaxDownloads > 0 && shr.Downloads >= shr.MaxDownloads
+}
+
+//cnst.ErrShareAccess in case share is not available yet, cnst.ErrShareExpired in case it is over
+func (shr *ShareItem) checkActive(now time.Time) error {
+	if shr.NotBefore != nil && now.Before(*shr.NotBefore) {
+		return cnst.ErrShareAccess
+	}
+	if shr.isExpired(now) {
+		return cnst.ErrShareExpired
+	}
+	return nil
+}
+
+func (shr *ShareItem) hasGroup(name string) bool {
+	for _, g := range shr.AllowGroups {
+		if g == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (shr *ShareItem) copyShare() (res *ShareItem) {
@@ -59,129 +156,62 @@ func (shr *ShareItem) copyShare() (res *ShareItem) {
 		AllowLocal:    shr.AllowLocal,
 		AllowUsers:    make([]string, len(shr.AllowUsers)),
 		Hash:          shr.Hash,
+		NotBefore:     copyTime(shr.NotBefore),
+		ExpiresAt:     copyTime(shr.ExpiresAt),
+		MaxDownloads:  shr.MaxDownloads,
+		Downloads:     shr.Downloads,
+		Password:      shr.Password,
+		Links:         copyLinks(shr.Links),
+		Stale:         copyTime(shr.Stale),
+		Drop:          shr.Drop.copyDrop(),
 	}
 	copy(res.AllowUsers, shr.AllowUsers)
-	return
-}
-
-func addSharePath(shr *ShareItem, own string) {
-	if shr.AllowLocal {
-		for _, u := range config.Users {
-			processSharePath(shr, u, own)
-
-		}
-	} else if len(shr.AllowUsers) > 0 {
-		for _, uName := range shr.AllowUsers {
-			u, _ := usersRam[uName]
-			processSharePath(shr, u, own)
-		}
-	}
-}
-
-//will create share path, or drop share item, in case share not exists
-func processSharePath(shr *ShareItem, u *UserConfig, own string) {
-	if u.Username == own {
-		return
+	if len(shr.AllowGroups) > 0 {
+		res.AllowGroups = make([]string, len(shr.AllowGroups))
+		copy(res.AllowGroups, shr.AllowGroups)
 	}
-	if !config.checkShareSymLinkPath(shr, u.Username, own) {
-		if owner, ok := config.GetUserByUsername(own); ok && owner.DeleteShare(shr.Path) {
-			_ = config.Update(owner)
+	if shr.Permissions != nil {
+		res.Permissions = make(map[string]string, len(shr.Permissions))
+		for k, v := range shr.Permissions {
+			res.Permissions[k] = v
 		}
-	} else {
-		config.checkExternalShareSymLinkPath(shr, own)
 	}
+	return
 }
 
-func delSharePath(shr *ShareItem, owner string) {
-	for _, u := range config.Users {
-		if owner == u.Username {
-			continue
-		}
-		p := shr.ResolveSymlinkName()
-
-		dp := filepath.Join(config.GetUserSharesPath(u.Username), owner, p)
-		_ = os.Remove(dp)
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
 	}
-	//drop external share
-	dp := filepath.Join(config.GetUserSharexPath(owner), shr.Hash)
-	_ = os.Remove(dp)
-
+	res := *t
+	return &res
 }
+
 func GenShareHash(userName, itmPath string) string {
 	return base64.StdEncoding.EncodeToString(md5.New().Sum([]byte(userName + itmPath)))
 }
 
-//returns true in case share good, otherwise original share path does not exists. Will create share if needed
-func (cfg *GlobalConfig) checkShareSymLinkPath(shr *ShareItem, consumer, owner string) (res bool) {
-	res = true
-	dp := filepath.Join(cfg.GetUserSharesPath(consumer), owner)
-	//check share exists at shares user dir
-	if !createPath(dp) {
-		log.Printf("config : Cant create share path at %s ", dp)
-
-	} else {
-		//destination path for symlink
-		sp := shr.ResolveSymlinkName()
-		var err error
-		dPath := filepath.Join(dp, sp)
-		//source path for symlink
-		sPath := filepath.Join(cfg.GetUserHomePath(owner), shr.Path)
-		if shr.IsAllowed(consumer) {
-			//drop existing share if exists
-			_ = os.Remove(dPath)
-
-			//check if share valid
-			if _, err = os.Stat(sPath); err != nil && os.IsNotExist(err) {
-				log.Printf("config :source '%s' does not exists for %s'", sPath, dPath)
-				res = false
-			} else if err = os.Symlink(sPath, dPath); err != nil && !os.IsExist(err) {
-				//drop not valid symlink, because dav client will fail to read it
-				_ = os.Remove(dPath)
-				log.Printf("config : Cant create share sym link from '%s' TO '%s'", sPath, dPath)
-			}
-			if err != nil {
-				_ = utils.ModPermission(0, 0, dPath)
-			}
-		}
+//hash of share at p, unique among shares of the user, since moved shares keep hash of their old path
+func (u *UserConfig) shareHash(p string) string {
+	h := GenShareHash(u.Username, p)
+	for i := 1; u.hasShareHash(h); i++ {
+		h = GenShareHash(u.Username, p+"#"+strconv.Itoa(i))
 	}
-	return res
+	return h
 }
-func (cfg *GlobalConfig) checkExternalShareSymLinkPath(shr *ShareItem, owner string) (res bool) {
-	res = true
-	dp := cfg.GetUserSharexPath(owner)
-	//check share exists at shares user dir
-	sp := shr.ResolveSymlinkName()
-	if !createPath(dp) {
-		log.Printf("config : Cant create external share path at %s ", dp)
 
-	} else {
-		var err error
-		dPath := filepath.Join(dp, sp)
-		//source path for symlink
-		sPath := filepath.Join(cfg.GetUserHomePath(owner), shr.Path)
-		//drop existing share if exists
-		_ = os.Remove(dPath)
-
-		//check if share valid
-		if _, err = os.Stat(sPath); err != nil && os.IsNotExist(err) {
-			log.Printf("config :source '%s' does not exists for %s'", sPath, dPath)
-			res = false
-		} else if err = os.Symlink(sPath, dPath); err != nil && !os.IsExist(err) {
-			//drop not valid symlink, because dav client will fail to read it
-			_ = os.Remove(dPath)
-			log.Printf("config : Cant create share sym link from '%s' TO '%s'", sPath, dPath)
-		}
-		if err != nil {
-			_ = utils.ModPermission(0, 0, dPath)
+func (u *UserConfig) hasShareHash(h string) bool {
+	for _, shr := range u.Shares {
+		if shr.Hash == h {
+			return true
 		}
 	}
-	return res
+	return false
 }
 
-//will create correct symlink name, err in case hash empty
-func (shr *ShareItem) ResolveSymlinkName() (string) {
-	d := filepath.Dir(shr.Path)
-	return strings.ReplaceAll(strings.TrimPrefix(shr.Path, d), "/", "") + "_" + shr.Hash
+//name of local share folder at shares of consumer, like name_hash
+func (shr *ShareItem) ResolveSymlinkName() string {
+	return shr.LinkName(shr.Hash)
 }
 
 //take the user from url, find it, after return user preview
@@ -202,7 +232,13 @@ func (cfg *GlobalConfig) GetSharePreviewPath(url string, isEx bool) (res, hash s
 
 			arr2 := strings.Split(arr[ind], "_")
 			hash = strings.Split(arr2[len(arr2)-1], "/")[0]
-			shr, user := cfg.GetExternal(hash)
+			var shr *ShareItem
+			var user *UserConfig
+			if isEx {
+				shr, user = cfg.GetExternal(hash)
+			} else {
+				shr, user = cfg.shareByHash(hash)
+			}
 			if shr != nil {
 				fName := ""
 				if len(filepath.Ext(arr[len(arr)-1])) > 0 {
@@ -214,3 +250,271 @@ func (cfg *GlobalConfig) GetSharePreviewPath(url string, isEx bool) (res, hash s
 	}
 	return res, hash
 }
+
+//active share by its hash at local links. Should be called under lock
+func (cfg *GlobalConfig) shareByHash(hash string) (*ShareItem, *UserConfig) {
+	for _, u := range cfg.Users {
+		for _, shr := range u.Shares {
+			if shr.Hash == hash && shr.checkActive(time.Now()) == nil {
+				return shr, u
+			}
+		}
+	}
+	return nil, nil
+}
+
+//share, its owner and path at owner home for url at shares(/owner/name_hash/sub) or external shares(/name_hash/sub),
+//nil share in case url is not inside any share. Should be called under lock
+func (cfg *GlobalConfig) findShare(url string, isEx bool) (*ShareItem, *UserConfig, string) {
+	url = strings.TrimPrefix(utils.SlashClean(url), "/")
+	for _, u := range cfg.Users {
+		rest := url
+		if !isEx {
+			if !strings.HasPrefix(url, u.Username+"/") {
+				continue
+			}
+			rest = strings.TrimPrefix(url, u.Username+"/")
+		}
+		for _, shr := range u.Shares {
+			if name, ok := shr.matchName(rest, isEx); ok && len(shr.Hash) > 0 {
+				return shr, u, filepath.Join(shr.Path, strings.TrimPrefix(rest, name))
+			}
+		}
+	}
+	return nil, nil, "/" + url
+}
+
+//share name at the start of url, like name_hash for local shares, or name_token for external ones
+func (shr *ShareItem) matchName(url string, isEx bool) (string, bool) {
+	if !isEx {
+		//hash may contain slashes
+		name := shr.ResolveSymlinkName()
+		return name, url == name || strings.HasPrefix(url, name+"/")
+	}
+	//name part of the link is outdated once share was moved, so token decides
+	name := strings.SplitN(url, "/", 2)[0]
+	for _, l := range shr.Links {
+		if strings.HasSuffix(name, "_"+l.Token) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+//error in case url points to share, that is not available yet or already expired
+func (cfg *GlobalConfig) CheckShare(url string, isEx bool) error {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	if shr, _, _ := cfg.findShare(url, isEx); shr != nil {
+		return shr.checkActive(time.Now())
+	}
+	return nil
+}
+
+//owner, share root and path at owner home of local share at url(/owner/name_hash/sub), with permission of the consumer.
+//Error in case url is not inside any share, or share is not available for the user
+func (cfg *GlobalConfig) ShareAccess(url, user string) (owner *UserConfig, root, p, perm string, err error) {
+	updateLock.RLock()
+	shr, u, p := cfg.findShare(url, false)
+	if shr == nil {
+		updateLock.RUnlock()
+		return nil, "", "", "", cnst.ErrNotExist
+	}
+	name, root := u.Username, shr.Path
+	updateLock.RUnlock()
+
+	owner, ok := cfg.GetUserByUsername(name)
+	if !ok {
+		return nil, "", "", "", cnst.ErrNotExist
+	}
+	shrs := owner.GetShares(root, false)
+	if len(shrs) == 0 {
+		return nil, "", "", "", cnst.ErrNotExist
+	}
+	if err = shrs[0].checkActive(time.Now()); err != nil {
+		return nil, "", "", "", err
+	}
+	if !shrs[0].IsAllowed(user) {
+		return nil, "", "", "", cnst.ErrShareAccess
+	}
+	return owner, root, p, shrs[0].Permission(user), nil
+}
+
+//owner and path at owner home for url inside share available to the user. Local shares(/owner/name_hash/sub) are checked against
+//access rules of the user, external ones(/name_token/sub) by the link. cnst.ErrNotExist in case url is not inside any share
+func (cfg *GlobalConfig) ResolveShare(url, user string, isEx bool) (owner, p string, err error) {
+	if !isEx {
+		o, _, p, _, err := cfg.ShareAccess(url, user)
+		if err != nil {
+			return "", "", err
+		}
+		return o.Username, p, nil
+	}
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	shr, u, p := cfg.findShare(url, true)
+	if shr == nil {
+		return "", "", cnst.ErrNotExist
+	}
+	now := time.Now()
+	if err = shr.checkActive(now); err != nil {
+		return "", "", err
+	}
+	name := strings.SplitN(strings.TrimPrefix(utils.SlashClean(url), "/"), "/", 2)[0]
+	for _, l := range shr.Links {
+		if strings.HasSuffix(name, "_"+l.Token) && !l.isExpired(now) {
+			return u.Username, p, nil
+		}
+	}
+	return "", "", cnst.ErrNotExist
+}
+
+//names of virtual folders at local shares of the user: owners, that share anything with the user at "/",
+//and shares of the owner available to the user at "/owner". False in case url is not a virtual folder
+func (cfg *GlobalConfig) ShareDirNames(url, user string) ([]string, bool) {
+	url = strings.TrimPrefix(utils.SlashClean(url), "/")
+	if strings.Contains(url, "/") {
+		return nil, false
+	}
+	res := []string{}
+	for _, owner := range cfg.GetUsers() {
+		if owner.Username == user || len(url) > 0 && owner.Username != url {
+			continue
+		}
+		for _, shr := range owner.Shares {
+			if !shr.IsAllowed(user) {
+				continue
+			}
+			if len(url) == 0 {
+				res = append(res, owner.Username)
+				break
+			}
+			res = append(res, shr.ResolveSymlinkName())
+		}
+	}
+	return res, len(url) == 0 || len(res) > 0
+}
+
+//shares of other users available for the user, sorted by owner in order users are configured
+func (cfg *GlobalConfig) SharesWith(user string) (res []*AllowedShare) {
+	for _, u := range cfg.GetUsers() {
+		if u.Username == user {
+			continue
+		}
+		for _, shr := range u.Shares {
+			if shr.IsAllowed(user) {
+				res = append(res, &AllowedShare{u, shr})
+			}
+		}
+	}
+	return res
+}
+
+//reserve download from share at url before it is served, so concurrent downloads can't exceed limit together.
+//Fails in case share is not available, reservation must be finished by FinishShareDownload
+func (cfg *GlobalConfig) ReserveShareDownload(url string, isEx bool) error {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	shr, _, _ := cfg.findShare(url, isEx)
+	if shr == nil {
+		return nil
+	}
+	if err := shr.checkActive(time.Now()); err != nil {
+		return err
+	}
+	if shr.MaxDownloads == 0 {
+		return nil
+	}
+	if shr.Downloads+shr.pending >= shr.MaxDownloads {
+		return cnst.ErrShareExpired
+	}
+	shr.pending++
+	return nil
+}
+
+//finish download reserved by ReserveShareDownload, counter is persisted in case download was served
+func (cfg *GlobalConfig) FinishShareDownload(url string, isEx, served bool) error {
+	updateLock.Lock()
+	shr, owner, _ := cfg.findShare(url, isEx)
+	if shr == nil || shr.MaxDownloads == 0 {
+		updateLock.Unlock()
+		return nil
+	}
+	if shr.pending > 0 {
+		shr.pending--
+	}
+	if !served {
+		updateLock.Unlock()
+		return nil
+	}
+	shr.Downloads++
+	name := owner.Username
+	updateLock.Unlock()
+
+	return cfg.SaveUser(name)
+}
+
+//rewrite shares of the owner at src or below it to dst, after files were moved. Recipients and links are kept.
+//Shares that were at dst are dropped, since their source was replaced
+func (cfg *GlobalConfig) MoveShares(owner, src, dst string) error {
+	src, dst = strings.TrimSuffix(utils.SlashClean(src), "/"), strings.TrimSuffix(utils.SlashClean(dst), "/")
+	if src == dst {
+		return nil
+	}
+	return cfg.modShares(owner, func(shr *ShareItem) bool {
+		if rel, ok := subPath(shr.Path, src); ok {
+			//hash stays, so local links and unlock tokens survive the move
+			shr.Path = dst + rel
+			shr.Stale = nil
+			return true
+		}
+		_, ok := subPath(shr.Path, dst)
+		return !ok
+	})
+}
+
+//drop shares of the owner at p or below it, after files were removed
+func (cfg *GlobalConfig) DropShares(owner, p string) error {
+	p = strings.TrimSuffix(utils.SlashClean(p), "/")
+	return cfg.modShares(owner, func(shr *ShareItem) bool {
+		_, ok := subPath(shr.Path, p)
+		return !ok
+	})
+}
+
+//apply fn to every share of the owner, share is dropped in case fn returns false. Owner saved in case shares changed
+func (cfg *GlobalConfig) modShares(owner string, fn func(shr *ShareItem) bool) error {
+	updateLock.Lock()
+	u, ok := usersRam[owner]
+	if !ok {
+		updateLock.Unlock()
+		return cnst.ErrNotExist
+	}
+	changed := false
+	shares := make([]*ShareItem, 0, len(u.Shares))
+	for _, shr := range u.Shares {
+		path, hash := shr.Path, shr.Hash
+		if !fn(shr) {
+			changed = true
+			continue
+		}
+		changed = changed || path != shr.Path || hash != shr.Hash
+		shares = append(shares, shr)
+	}
+	u.Shares = shares
+	updateLock.Unlock()
+	if !changed {
+		return nil
+	}
+	return cfg.SaveUser(owner)
+}
+
+//rest of p after base, false in case p is not base or inside it
+func subPath(p, base string) (string, bool) {
+	if p == base {
+		return "", true
+	} else if strings.HasPrefix(p, base+"/") {
+		return strings.TrimPrefix(p, base), true
+	}
+	return "", false
+}
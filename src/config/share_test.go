@@ -1,10 +1,11 @@
 package config
 
 import (
+	"github.com/browsefile/backend/src/cnst"
 	"os"
-	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSharePathMod(t *testing.T) {
@@ -43,19 +44,19 @@ func TestSharePathMod(t *testing.T) {
 	}
 	//test parent share present at user2
 	p := shrUp.ResolveSymlinkName()
-	_, err = cfg.User2FSShare.Stat(filepath.Join(cfg.Usr1.Username, p))
+	_, err = cfg.StatShare("user2", "/"+cfg.Usr1.Username+"/"+p)
 	if err != nil {
 		t.Fatal("parent share does not exists, but should be", err)
 	}
 	//test child share present at user2
 	p = shrDeep.ResolveSymlinkName()
-	_, err = cfg.User2FSShare.Stat(filepath.Join(cfg.Usr1.Username, p))
+	_, err = cfg.StatShare("user2", "/"+cfg.Usr1.Username+"/"+p)
 	if err == nil {
 		t.Fatal("share does not exists, but should be", err)
 	}
 	//test child share present at admin
 	p = shrDeep.ResolveSymlinkName()
-	_, err = cfg.AdminFSShare.Stat(filepath.Join(cfg.Usr1.Username, p))
+	_, err = cfg.StatShare("admin", "/"+cfg.Usr1.Username+"/"+p)
 	if err != nil {
 		t.Fatal("share does not exists, but should be", err)
 	}
@@ -77,19 +78,19 @@ func TestSharePathMod(t *testing.T) {
 
 	//test parent share present at user2
 	p = shrUp.ResolveSymlinkName()
-	_, err = cfg.User2FSShare.Stat(filepath.Join(cfg.Usr1.Username, p))
+	_, err = cfg.StatShare("user2", "/"+cfg.Usr1.Username+"/"+p)
 	if err == nil {
 		t.Fatal("parent share exists, but should not be", err)
 	}
 	//test child share present at user2
 	p = shrDeep.ResolveSymlinkName()
-	_, err = cfg.User2FSShare.Stat(filepath.Join(cfg.Usr1.Username, p))
+	_, err = cfg.StatShare("user2", "/"+cfg.Usr1.Username+"/"+p)
 	if err == nil {
 		t.Fatal("share exists, but should not be", err)
 	}
 	//test child share present at admin
 	p = shrDeep.ResolveSymlinkName()
-	_, err = cfg.AdminFSShare.Stat(filepath.Join(cfg.Usr1.Username, p))
+	_, err = cfg.StatShare("admin", "/"+cfg.Usr1.Username+"/"+p)
 	if err == nil {
 		t.Fatal("share exists, but should not be", err)
 	}
@@ -153,19 +154,19 @@ func TestDeleteUserAndShares(t *testing.T) {
 	_ = cfg.DeleteUser(cfg.Usr1.Username)
 	//test parent share present at user2
 	p := shrUp.ResolveSymlinkName()
-	_, err = cfg.User2FSShare.Stat(filepath.Join(cfg.Usr1.Username, p))
+	_, err = cfg.StatShare("user2", "/"+cfg.Usr1.Username+"/"+p)
 	if err == nil {
 		t.Fatal("parent share exists, but should not be", err)
 	}
 	//test child share present at user2
 	p = shrDeep.ResolveSymlinkName()
-	_, err = cfg.User2FSShare.Stat(filepath.Join(cfg.Usr1.Username, p))
+	_, err = cfg.StatShare("user2", "/"+cfg.Usr1.Username+"/"+p)
 	if err == nil {
 		t.Fatal("share exists, but should not be", err)
 	}
 	//test child share present at admin
 	p = shrDeep.ResolveSymlinkName()
-	_, err = cfg.AdminFSShare.Stat(filepath.Join(cfg.Usr1.Username, p))
+	_, err = cfg.StatShare("admin", "/"+cfg.Usr1.Username+"/"+p)
 	if err == nil {
 		t.Fatal("share exists, but should not be", err)
 	}
@@ -187,9 +188,15 @@ func TestExternal(t *testing.T) {
 	cfg.Usr1.AddShare(shrUp)
 	_ = cfg.Update(cfg.Usr1)
 
-	shrUp, cfg.Usr1 = cfg.GetExternal(shrUp.Hash)
+	if len(shrUp.Links) != 1 {
+		t.Fatal("external share must get link")
+	}
+	if shr, _ := cfg.GetExternal(shrUp.Hash); shr != nil {
+		t.Fatal("new share must not be found by legacy hash")
+	}
+	shrUp, cfg.Usr1 = cfg.GetExternal(shrUp.Links[0].Token)
 	if shrUp == nil || cfg.Usr1 == nil {
-		t.Fatal("should find at least 1 external share by hash")
+		t.Fatal("should find at least 1 external share by link token")
 	}
 	if !shrUp.IsAllowed("guest") {
 		t.Fatal("external share should be allowed to the guest")
@@ -200,7 +207,7 @@ func TestSharePreviewPath(t *testing.T) {
 	cfg.InitWithUsers(t)
 	defer cfg.Clean(t)
 
-	shrUp := &ShareItem{Path: "/so/path", AllowLocal: false, AllowExternal: false}
+	shrUp := &ShareItem{Path: "/so/path", AllowLocal: false, AllowExternal: true}
 	cfg.Usr1.AddShare(shrUp)
 	_ = cfg.Update(cfg.Usr1)
 	p := shrUp.ResolveSymlinkName()
@@ -208,8 +215,8 @@ func TestSharePreviewPath(t *testing.T) {
 	if !strings.HasSuffix(p, "preview/so/path") {
 		t.Fatal("wrong preview path for share consumer")
 	}
-	p, h := cfg.GetSharePreviewPath(shrUp.ResolveSymlinkName()+"/path", true)
-	if !strings.HasSuffix(p, "preview/so/path") || !strings.EqualFold(shrUp.Hash, h) {
+	p, h := cfg.GetSharePreviewPath(shrUp.LinkName(shrUp.Links[0].Token)+"/path", true)
+	if !strings.HasSuffix(p, "preview/so/path") || !strings.EqualFold(shrUp.Links[0].Token, h) {
 		t.Fatal("wrong ex share hash")
 	}
 }
@@ -232,3 +239,291 @@ func TestSharePreviewPath(t *testing.T) {
 	processSharePath(shrUp, cfg.GetAdmin(), cfg.Usr1.Username)
 }
 */
+
+func TestShareLifetime(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+
+	now := time.Now()
+	past, future, far := now.Add(-time.Hour), now.Add(time.Hour), now.Add(2*time.Hour)
+	expired := &ShareItem{Path: cfg.SharePathDeep, AllowExternal: true, AllowUsers: []string{"user2"}, ExpiresAt: &far}
+	later := &ShareItem{Path: cfg.SharePathUp, AllowUsers: []string{"user2"}, NotBefore: &future}
+	cfg.Usr1.AddShare(expired)
+	cfg.Usr1.AddShare(later)
+	_ = cfg.Update(cfg.Usr1)
+	if (&ShareItem{NotBefore: &future, ExpiresAt: &past}).Validate() == nil || (&ShareItem{MaxDownloads: -1}).Validate() == nil {
+		t.Error("wrong lifetime must be rejected")
+	}
+	if later.IsAllowed("user2") || cfg.CheckShare("/user1/"+later.ResolveSymlinkName(), false) != cnst.ErrShareAccess {
+		t.Error("share must not be available before start")
+	}
+	if _, err := cfg.StatShare("user2", "/user1/"+later.ResolveSymlinkName()); err == nil {
+		t.Error("share must not be resolved before start")
+	}
+
+	//share available after start
+	later.NotBefore = &past
+	cfg.sweepShares(now)
+	if len(cfg.Usr1.GetShares(cfg.SharePathDeep, false)) != 1 {
+		t.Fatal("share must be kept before expiration")
+	}
+	if _, err := cfg.StatShare("user2", "/user1/"+later.ResolveSymlinkName()); err != nil {
+		t.Error("share must be resolved once started", err)
+	}
+
+	expired.ExpiresAt = &past
+	if expired.IsAllowed("user2") || expired.IsAllowed("guest") {
+		t.Error("expired share must not be allowed")
+	}
+	exName := expired.LinkName(expired.Links[0].Token)
+	if shr, _ := cfg.GetExternal(expired.Links[0].Token); shr != nil {
+		t.Error("expired share must not be found")
+	}
+	if p, _ := cfg.GetSharePreviewPath("/"+exName, true); len(p) > 0 {
+		t.Error("expired share must not have preview path")
+	}
+	cfg.sweepShares(now)
+	if len(cfg.Usr1.GetShares(cfg.SharePathDeep, false)) != 0 {
+		t.Error("expired share must be deleted")
+	}
+	if _, _, err := cfg.ResolveShare("/"+exName, "guest", true); err == nil {
+		t.Error("external link of expired share must be deleted")
+	}
+	if _, err := cfg.StatShare("user2", "/user1/"+expired.ResolveSymlinkName()); err == nil {
+		t.Error("expired share must not be resolved")
+	}
+
+	limited := &ShareItem{Path: cfg.SharePathDeep, AllowExternal: true, MaxDownloads: 1}
+	cfg.Usr1.AddShare(limited)
+	_ = cfg.Update(cfg.Usr1)
+	p := "/" + limited.LinkName(limited.Links[0].Token) + "/real.jpg"
+	if err := cfg.ReserveShareDownload(p, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ReserveShareDownload(p, true); err != cnst.ErrShareExpired {
+		t.Error("concurrent download over limit must fail", err)
+	}
+	//failed download releases its slot
+	_ = cfg.FinishShareDownload(p, true, false)
+	if err := cfg.ReserveShareDownload(p, true); err != nil {
+		t.Fatal(err)
+	}
+	_ = cfg.FinishShareDownload(p, true, true)
+	if err := cfg.ReserveShareDownload(p, true); err != cnst.ErrShareExpired {
+		t.Error("downloads over limit must fail", err)
+	}
+}
+
+func TestShareLinks(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+
+	shr := &ShareItem{Path: cfg.SharePathUp, AllowExternal: true}
+	cfg.Usr1.AddShare(shr)
+	_ = cfg.Update(cfg.Usr1)
+	first := shr.Links[0].Token
+	if len(first) != 32 || strings.Contains(shr.LinkName(first), GenShareHash("user1", cfg.SharePathUp)) {
+		t.Fatal("link token must be random", first)
+	}
+
+	_, l, err := cfg.AddShareLink("user1", cfg.SharePathUp, "friends")
+	if err != nil || l.Label != "friends" {
+		t.Fatal("link must be added", err)
+	}
+	for _, tk := range []string{first, l.Token} {
+		if s, _ := cfg.GetExternal(tk); s == nil {
+			t.Error("every link must open the share")
+		}
+	}
+	_, rl, err := cfg.RotateShareLink("user1", cfg.SharePathUp, l.Token)
+	if err != nil || rl.Token == l.Token || rl.Label != "friends" {
+		t.Fatal("link must be rotated", err)
+	}
+	if s, _ := cfg.GetExternal(l.Token); s != nil {
+		t.Error("rotated token must not open the share")
+	}
+	if _, _, err = cfg.ResolveShare("/"+shr.LinkName(rl.Token), "guest", true); err != nil {
+		t.Error("rotated link must be resolved", err)
+	}
+	if err = cfg.RevokeShareLink("user1", cfg.SharePathUp, first); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := cfg.GetExternal(first); s != nil {
+		t.Error("revoked token must not open the share")
+	}
+	if s, _ := cfg.GetExternal(rl.Token); s == nil {
+		t.Error("other links must stay")
+	}
+	if _, _, err = cfg.AddShareLink("user1", cfg.SharePathDeep, ""); err != cnst.ErrNotExist {
+		t.Error("link can't be added to missed share", err)
+	}
+}
+
+func TestShareLinksLegacy(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+
+	//share from config written before links were introduced
+	shr := &ShareItem{Path: cfg.SharePathUp, AllowExternal: true}
+	cfg.Usr1.Shares = append(cfg.Usr1.Shares, shr)
+	cfg.Verify()
+	hash := GenShareHash("user1", cfg.SharePathUp)
+	if len(shr.Links) != 1 || shr.Links[0].Token != hash || shr.Links[0].ExpiresAt == nil {
+		t.Fatal("legacy hash must be migrated to expiring link")
+	}
+	if s, _ := cfg.GetExternal(hash); s == nil {
+		t.Error("legacy hash must work during migration window")
+	}
+
+	cfg.sweepShares(shr.Links[0].ExpiresAt.Add(time.Second))
+	if s, _ := cfg.GetExternal(hash); s != nil || len(shr.Links) != 0 {
+		t.Error("legacy link must be dropped after migration window")
+	}
+}
+
+func TestAccessLogRetention(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+
+	shr := &ShareItem{Path: cfg.SharePathUp, AllowLocal: true}
+	cfg.Usr1.AddShare(shr)
+	_ = cfg.Update(cfg.Usr1)
+	url := "/user1/" + shr.ResolveSymlinkName()
+
+	now := time.Now()
+	for _, d := range []int{10, 2, 0} {
+		e := &AccessEntry{Time: now.AddDate(0, 0, -d), Action: ACCESS_LIST, User: "user2"}
+		if err := cfg.LogAccess(url+"/a", false, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cfg.LogAccess("/user1/missing", false, &AccessEntry{}); err != nil {
+		t.Error("access outside of shares must be ignored", err)
+	}
+	l, err := cfg.AccessLog("user1", cfg.SharePathUp)
+	if err != nil || len(l) != 3 || l[0].Path != cfg.SharePathUp+"/a" {
+		t.Fatal("wrong log", err, len(l))
+	}
+
+	cfg.ShareLogDays = -1
+	cfg.PruneAccessLogs(now)
+	if l, _ = cfg.AccessLog("user1", "/"); len(l) != 3 {
+		t.Error("negative retention keeps records forever", len(l))
+	}
+	cfg.ShareLogDays = 5
+	cfg.PruneAccessLogs(now)
+	if l, _ = cfg.AccessLog("user1", "/"); len(l) != 2 || !l[0].Time.After(now.AddDate(0, 0, -5)) {
+		t.Error("old records must be dropped", len(l))
+	}
+}
+
+func TestMoveShares(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+
+	up := &ShareItem{Path: cfg.SharePathUp, AllowExternal: true, AllowUsers: []string{"user2"}}
+	deep := &ShareItem{Path: cfg.SharePathDeep, AllowUsers: []string{"admin"}}
+	other := &ShareItem{Path: "/testing", AllowLocal: true}
+	cfg.Usr1.AddShare(up)
+	cfg.Usr1.AddShare(deep)
+	cfg.Usr1.AddShare(other)
+	_ = cfg.Update(cfg.Usr1)
+	token, hash := up.Links[0].Token, up.Hash
+
+	if err := cfg.MoveShares("user1", cfg.SharePathUp, "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	usr1, _ := cfg.GetUserByUsername("user1")
+	if len(usr1.GetShares("/moved", false)) != 1 || len(usr1.GetShares("/moved/share", false)) != 1 {
+		t.Fatal("shares must follow moved path")
+	}
+	if len(usr1.GetShares("/testing", false)) != 1 {
+		t.Error("share with similar name must stay")
+	}
+	moved := usr1.GetShares("/moved", false)[0]
+	if !moved.IsAllowed("user2") || moved.Links[0].Token != token {
+		t.Error("recipients and links must be kept")
+	}
+	//local links and unlock tokens are bound to hash
+	if moved.Hash != hash {
+		t.Error("hash must be kept on move")
+	}
+	cfg.ReadConfigFile()
+	if usr1, _ = cfg.GetUserByUsername("user1"); usr1.GetShares("/moved", false)[0].Hash != hash {
+		t.Error("hash must be kept after reload")
+	}
+	again := &ShareItem{Path: cfg.SharePathUp, AllowLocal: true}
+	usr1.AddShare(again)
+	if again.Hash == hash {
+		t.Error("new share at old path must not take hash of moved share")
+	}
+	usr1.DeleteShare(cfg.SharePathUp)
+	//old link name still opens the share
+	if _, _, err := cfg.ResolveShare("/test_"+token+"/share", "guest", true); err != nil {
+		t.Error("external link must survive move", err)
+	}
+	if _, err := cfg.StatShare("admin", "/user1/"+usr1.GetShares("/moved/share", false)[0].ResolveSymlinkName()); err == nil {
+		t.Error("share source was not moved on disk, so it must not exist")
+	}
+
+	//share at destination is replaced
+	if err := cfg.MoveShares("user1", "/testing", "/moved/share"); err != nil {
+		t.Fatal(err)
+	}
+	usr1, _ = cfg.GetUserByUsername("user1")
+	if shrs := usr1.GetShares("/moved/share", false); len(shrs) != 1 || !shrs[0].AllowLocal {
+		t.Error("replaced share must be dropped")
+	}
+	if err := cfg.DropShares("user1", "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	usr1, _ = cfg.GetUserByUsername("user1")
+	if len(usr1.Shares) != 0 {
+		t.Error("shares must be dropped")
+	}
+}
+
+func TestSweepMissingSource(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+
+	shr := &ShareItem{Path: cfg.SharePathDeep, AllowExternal: true}
+	cfg.Usr1.AddShare(shr)
+	_ = cfg.Update(cfg.Usr1)
+	share := func() *ShareItem {
+		u, _ := cfg.GetUserByUsername("user1")
+		if shrs := u.GetShares(cfg.SharePathDeep, false); len(shrs) == 1 {
+			return shrs[0]
+		}
+		return nil
+	}
+	now := time.Now()
+	if cfg.sweepShares(now); share() == nil || share().Stale != nil {
+		t.Fatal("share with source must be kept")
+	}
+	//source can come back, like after rename that is followed by share move
+	if err := cfg.User1FS.RemoveAll(cfg.SharePathDeep); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.sweepShares(now); share() == nil || share().Stale == nil {
+		t.Fatal("share of missing source must be marked only")
+	}
+	_ = cfg.User1FS.Mkdir(cfg.SharePathDeep, cnst.PERM_DEFAULT, 0, 0)
+	if cfg.sweepShares(now); share() == nil || share().Stale != nil {
+		t.Fatal("share of restored source must be kept")
+	}
+	_ = cfg.User1FS.RemoveAll(cfg.SharePathDeep)
+	cfg.sweepShares(now)
+	if cfg.sweepShares(now.Add(SHARE_STALE_GRACE / 2)); share() == nil {
+		t.Fatal("share must be kept during grace period")
+	}
+	if cfg.sweepShares(now.Add(SHARE_STALE_GRACE)); share() != nil {
+		t.Error("share of source missing longer than grace period must be deleted")
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+)
+
+//users storage types
+const (
+	//users and shares kept inside config file
+	STORE_JSON = "json"
+	//users and shares kept in embedded bolt database
+	STORE_BOLT = "bolt"
+)
+
+//where to keep users and their shares
+type StorageConf struct {
+	//json(default) or bolt
+	Type string `json:"type"`
+//database file path, by default next to the config file
+	Path string `json:"path"`
+}
+
+//persistent storage for users and their shares, ram index is filled from it at start and serves all reads
+type UserStore interface {
+	//load all users
+	Users() ([]*UserConfig, error)
+	//insert or replace single user
+	PutUser(u *UserConfig) error
+	DeleteUser(username string) error
+	Close() error
+}
+
+//keeps users inside config file, every change rewrites whole file
+type jsonStore struct {
+	cfg *GlobalConfig
+}
+
+func (s *jsonStore) Users() ([]*UserConfig, error) {
+	return s.cfg.Users, nil
+}
+func (s *jsonStore) PutUser(u *UserConfig) error {
+	s.cfg.WriteConfig()
+	return nil
+}
+func (s *jsonStore) DeleteUser(username string) error {
+	s.cfg.WriteConfig()
+	return nil
+}
+func (s *jsonStore) Close() error {
+	return nil
+}
+
+//true in case users are written to the config file
+func (cfg *GlobalConfig) isJsonStore() bool {
+	_, ok := cfg.store.(*jsonStore)
+	return cfg.store == nil || ok
+}
+
+//open users store according config, users from config file will be migrated once in case store is empty
+func (cfg *GlobalConfig) openStore() (err error) {
+	if cfg.store != nil {
+		_ = cfg.store.Close()
+		cfg.store = nil
+	}
+	if cfg.Storage == nil || len(cfg.Storage.Type) == 0 || cfg.Storage.Type == STORE_JSON {
+		cfg.store = &jsonStore{cfg}
+		return nil
+	}
+	switch cfg.Storage.Type {
+	case STORE_BOLT:
+		cfg.store, err = OpenBoltStore(cfg.storePath())
+	default:
+		err = cnst.ErrInvalidOption
+	}
+	if err != nil {
+		return err
+	}
+	users, err := cfg.store.Users()
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 && len(cfg.Users) > 0 {
+		return cfg.migrateUsers()
+	}
+	cfg.Users = users
+
+	return nil
+}
+
+//type of users store according settings, json config file by default
+func (cfg *GlobalConfig) storeType() string {
+	if cfg.Storage == nil || len(cfg.Storage.Type) == 0 {
+		return STORE_JSON
+	}
+	return cfg.Storage.Type
+}
+
+//database file path
+func (cfg *GlobalConfig) storePath() string {
+	if len(cfg.Storage.Path) == 0 {
+		return filepath.Join(filepath.Dir(cfg.Path), "browsefile.db")
+	}
+	return cfg.Storage.Path
+}
+
+//one-shot move of users from config file into the store, original file stays as backup
+func (cfg *GlobalConfig) migrateUsers() error {
+	if err := MigrateUsers(cfg.Users, cfg.store); err != nil {
+		return err
+	}
+	if b, err := ioutil.ReadFile(cfg.Path); err == nil {
+		if err = ioutil.WriteFile(cfg.Path+".pre-migration", b, 0600); err != nil {
+			log.Println("config : can't backup config file", err)
+		}
+	}
+	log.Printf("config : migrated %d users from %s to %s store", len(cfg.Users), cfg.Path, cfg.Storage.Type)
+	//drop users from config file
+	cfg.WriteConfig()
+
+	return nil
+}
+
+//copy users into the store
+func MigrateUsers(users []*UserConfig, to UserStore) error {
+	for _, u := range users {
+		if err := to.PutUser(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//persist user by username into the store
+func (cfg *GlobalConfig) SaveUser(username string) error {
+	if cfg.store == nil {
+		return nil
+	}
+	updateLock.RLock()
+	u, ok := usersRam[username]
+	if ok {
+		u = u.copyUser()
+	}
+	updateLock.RUnlock()
+	if !ok {
+		return cnst.ErrNotExist
+	}
+
+	return cfg.store.PutUser(u)
+}
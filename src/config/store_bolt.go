@@ -0,0 +1,71 @@
+package config
+
+import (
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var usersBucket = []byte("users")
+
+//keeps every user as json document by username key, so update touches only one record
+type boltStore struct {
+	db *bbolt.DB
+}
+
+//open or create bolt database at the path
+func OpenBoltStore(p string) (UserStore, error) {
+	if !createPath(filepath.Dir(p)) {
+		return nil, os.ErrPermission
+	}
+	db, err := bbolt.Open(p, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(usersBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db}, nil
+}
+
+func (s *boltStore) Users() (res []*UserConfig, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			u := &UserConfig{}
+			if err := json.Unmarshal(v, u); err != nil {
+				return err
+			}
+			res = append(res, u)
+			return nil
+		})
+	})
+	return res, err
+}
+
+func (s *boltStore) PutUser(u *UserConfig) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(usersBucket).Put([]byte(u.Username), b)
+	})
+}
+
+func (s *boltStore) DeleteUser(username string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(usersBucket).Delete([]byte(username))
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBoltStoreMigrate(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+
+	dbPath := filepath.Join(cfg.ConfigPath, "users.db")
+	cfg.Storage = &StorageConf{Type: STORE_BOLT, Path: dbPath}
+	cfg.WriteConfig()
+	cfg.ReadConfigFile()
+	defer cfg.store.Close()
+
+	if _, err := os.Stat(cfg.Path + ".pre-migration"); err != nil {
+		t.Error("config backup must be created", err)
+	}
+	b, err := ioutil.ReadFile(cfg.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "user1") {
+		t.Error("users must be dropped from config file")
+	}
+	users, err := cfg.store.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 {
+		t.Fatal("all users must be migrated, but got", len(users))
+	}
+
+	//modify single user
+	usr, _ := cfg.GetUserByUsername("user1")
+	usr.Locale = "it"
+	if err = cfg.Update(usr); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.DeleteUser("user2"); err != nil {
+		t.Fatal(err)
+	}
+	//read again from the database
+	cfg.ReadConfigFile()
+	usr, ok := cfg.GetUserByUsername("user1")
+	if !ok || usr.Locale != "it" {
+		t.Error("user update must be persisted")
+	}
+	if _, ok = cfg.GetUserByUsername("user2"); ok {
+		t.Error("user delete must be persisted")
+	}
+	if cfg.GetAdmin() == nil {
+		t.Error("admin must be loaded from store")
+	}
+}
+
+func TestJsonStorePersist(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	if !cfg.isJsonStore() {
+		t.Fatal("json store must be default")
+	}
+	usr, _ := cfg.GetUserByUsername("user1")
+	usr.Locale = "it"
+	if err := cfg.Update(usr); err != nil {
+		t.Fatal(err)
+	}
+	cfg2 := GlobalConfig{Path: cfg.Path}
+	cfg2.ReadConfigFile()
+	defer cfg.ReadConfigFile()
+	if u, ok := cfg2.GetUserByUsername("user1"); !ok || u.Locale != "it" {
+		t.Error("user update must be written to the config file")
+	}
+}
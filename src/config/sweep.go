@@ -0,0 +1,120 @@
+package config
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//delete expired shares and links, with given interval. Blocks forever
+func (cfg *GlobalConfig) SweepShares(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	now := time.Now()
+	cfg.sweepShares(now)
+	//access logs are rewritten, so pruned rarely
+	cfg.PruneAccessLogs(now)
+	pruned := now
+	for now := range t.C {
+		cfg.sweepShares(now)
+		if now.Sub(pruned) >= time.Hour {
+			cfg.PruneAccessLogs(now)
+			pruned = now
+		}
+	}
+}
+
+//share with missing source is kept this long, so moved or restored source gets it back
+const SHARE_STALE_GRACE = 7 * 24 * time.Hour
+
+//drop shares and links expired at now, and shares which source is missing longer than SHARE_STALE_GRACE
+func (cfg *GlobalConfig) sweepShares(now time.Time) {
+	type ownShare struct {
+		shr   *ShareItem
+		owner string
+		path  string
+	}
+	//sources are checked without lock, disk can be slow
+	var all []ownShare
+	updateLock.RLock()
+	for _, u := range cfg.Users {
+		for _, shr := range u.Shares {
+			all = append(all, ownShare{shr, u.Username, shr.Path})
+		}
+	}
+	updateLock.RUnlock()
+	missing := make(map[*ShareItem]string)
+	for _, s := range all {
+		if _, err := os.Stat(filepath.Join(cfg.GetUserHomePath(s.owner), s.path)); os.IsNotExist(err) {
+			missing[s.shr] = s.path
+		}
+	}
+
+	var expired, stale []ownShare
+	saved := make(map[string]bool)
+	updateLock.Lock()
+	for _, u := range cfg.Users {
+		keep := make([]*ShareItem, 0, len(u.Shares))
+		for _, shr := range u.Shares {
+			if shr.isExpired(now) {
+				expired = append(expired, ownShare{shr, u.Username, shr.Path})
+				continue
+			}
+			//share moved meanwhile is checked on next sweep
+			if p, ok := missing[shr]; ok && p == shr.Path {
+				if shr.Stale == nil {
+					t := now
+					shr.Stale = &t
+					log.Printf("config : source of share %s of %s is missing, share is deleted in %v", shr.Path, u.Username, SHARE_STALE_GRACE)
+					saved[u.Username] = true
+				} else if now.Sub(*shr.Stale) >= SHARE_STALE_GRACE {
+					stale = append(stale, ownShare{shr, u.Username, shr.Path})
+					continue
+				}
+			} else if !ok && shr.Stale != nil {
+				shr.Stale = nil
+				saved[u.Username] = true
+			}
+			keep = append(keep, shr)
+			if cfg.sweepLinks(shr, u.Username, now) {
+				saved[u.Username] = true
+			}
+		}
+		if len(keep) != len(u.Shares) {
+			u.Shares = keep
+		}
+	}
+	updateLock.Unlock()
+
+	for _, s := range expired {
+		log.Printf("config : share %s of %s expired, deleted", s.shr.Path, s.owner)
+		saved[s.owner] = true
+	}
+	for _, s := range stale {
+		log.Printf("config : source of share %s of %s missing since %v, deleted", s.path, s.owner, s.shr.Stale.Format(time.RFC3339))
+		saved[s.owner] = true
+	}
+	for name := range saved {
+		if err := cfg.SaveUser(name); err != nil {
+			log.Println("config : can't save user", name, err)
+		}
+	}
+}
+
+//drop expired links of the share, true in case any was dropped. Should be called under lock
+func (cfg *GlobalConfig) sweepLinks(shr *ShareItem, owner string, now time.Time) bool {
+	keep := make([]*ShareLink, 0, len(shr.Links))
+	for _, l := range shr.Links {
+		if l.isExpired(now) {
+			log.Printf("config : link %s of share %s of %s expired, deleted", l.Label, shr.Path, owner)
+			continue
+		}
+		keep = append(keep, l)
+	}
+	if len(keep) == len(shr.Links) {
+		return false
+	}
+	shr.Links = keep
+	return true
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"crypto/subtle"
+	"github.com/browsefile/backend/src/cnst"
+	"strings"
+	"time"
+)
+
+//scopes of personal access token
+const (
+	//list, download and search files
+	SCOPE_READ = "read"
+	//upload, modify and delete files
+	SCOPE_WRITE = "write"
+	//create and modify shares
+	SCOPE_SHARE = "share"
+	//manage users and settings, in case owner is admin
+	SCOPE_ADMIN = "admin"
+)
+
+//prefix of token value, tells tokens from passwords and session tokens
+const TOKEN_PREFIX = "bft_"
+
+//last usage is persisted not more often, so every request does not rewrite users store
+const TOKEN_TOUCH_INTERVAL = time.Minute
+
+//named token of the user, used by scripts through API and by webdav clients instead of password
+type AccessToken struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	//sha256 hash of token value
+	Hash      string     `json:"hash,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	Created   time.Time  `json:"created"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	LastUsed  *time.Time `json:"lastUsed,omitempty"`
+	//migrated app password, its value has no prefix and it is accepted by webdav only
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+//webdav password of older versions, same hash as token
+type appPassword struct {
+	ID       string     `json:"id"`
+	Label    string     `json:"label,omitempty"`
+	Hash     string     `json:"hash,omitempty"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+}
+
+//move app passwords into tokens with read and write scopes, so webdav clients keep working. True in case any migrated
+func (u *UserConfig) migrateAppPasswords() bool {
+	if len(u.AppPasswords) == 0 {
+		return false
+	}
+	for _, ap := range u.AppPasswords {
+		//hash was lost, password can't be checked anyway
+		if len(ap.Hash) == 0 {
+			continue
+		}
+		name := ap.Label
+		if len(name) == 0 {
+			name = "app password"
+		}
+		u.Tokens = append(u.Tokens, &AccessToken{ID: ap.ID, Name: name, Hash: ap.Hash, Scopes: []string{SCOPE_READ, SCOPE_WRITE},
+			Created: ap.Created, LastUsed: copyTime(ap.LastUsed), Legacy: true})
+	}
+	u.AppPasswords = nil
+	return true
+}
+
+func copyTokens(tokens []*AccessToken) []*AccessToken {
+	if tokens == nil {
+		return nil
+	}
+	res := make([]*AccessToken, len(tokens))
+	for i, t := range tokens {
+		cp := *t
+		cp.Scopes = append([]string(nil), t.Scopes...)
+		cp.ExpiresAt = copyTime(t.ExpiresAt)
+		cp.LastUsed = copyTime(t.LastUsed)
+		res[i] = &cp
+	}
+	return res
+}
+
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *AccessToken) IsExpired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+func isScope(s string) bool {
+	switch s {
+	case SCOPE_READ, SCOPE_WRITE, SCOPE_SHARE, SCOPE_ADMIN:
+		return true
+	}
+	return false
+}
+
+//new token of the user, plain value is returned only once. Admin scope is granted only to admins
+func (cfg *GlobalConfig) AddToken(username, name string, scopes []string, expiresAt *time.Time) (*AccessToken, string, error) {
+	now := time.Now()
+	if len(scopes) == 0 || expiresAt != nil && !now.Before(*expiresAt) {
+		return nil, "", cnst.ErrInvalidOption
+	}
+	for _, s := range scopes {
+		if !isScope(s) {
+			return nil, "", cnst.ErrInvalidOption
+		}
+	}
+	if len(name) == 0 {
+		name = "token"
+	}
+	plain := TOKEN_PREFIX + randomHex(20)
+	t := &AccessToken{ID: randomHex(4), Name: name, Hash: hashSecret(plain), Scopes: append([]string(nil), scopes...),
+		Created: now, ExpiresAt: copyTime(expiresAt)}
+	err := cfg.modUser(username, func(u *UserConfig) error {
+		if t.HasScope(SCOPE_ADMIN) && !u.Admin {
+			return cnst.ErrInvalidOption
+		}
+		u.Tokens = append(u.Tokens, t)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	res := copyTokens([]*AccessToken{t})[0]
+	res.Hash = ""
+	return res, plain, nil
+}
+
+//revoke token by id
+func (cfg *GlobalConfig) DeleteToken(username, id string) error {
+	return cfg.modUser(username, func(u *UserConfig) error {
+		for i, t := range u.Tokens {
+			if t.ID == id {
+				u.Tokens = append(u.Tokens[:i], u.Tokens[i+1:]...)
+				return nil
+			}
+		}
+		return cnst.ErrNotExist
+	})
+}
+
+//owner and copy of active token by its value, username is optional. Last usage of token is updated.
+//Migrated app passwords have no prefix, those are checked only with username of webdav login
+func (cfg *GlobalConfig) CheckToken(username, plain string) (*UserConfig, *AccessToken, bool) {
+	legacy := !strings.HasPrefix(plain, TOKEN_PREFIX)
+	if legacy && len(username) == 0 {
+		return nil, nil, false
+	}
+	h := hashSecret(plain)
+	now := time.Now()
+	var owner *UserConfig
+	var res *AccessToken
+	save := false
+	updateLock.Lock()
+	for _, u := range cfg.Users {
+		if len(username) > 0 && u.Username != username {
+			continue
+		}
+		for _, t := range u.Tokens {
+			if t.Legacy != legacy {
+				continue
+			}
+			if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(h)) == 1 && !t.IsExpired(now) {
+				save = t.LastUsed == nil || now.Sub(*t.LastUsed) > TOKEN_TOUCH_INTERVAL
+				if save {
+					t.LastUsed = &now
+				}
+				owner, res = u, copyTokens([]*AccessToken{t})[0]
+			}
+		}
+	}
+	updateLock.Unlock()
+	if owner == nil {
+		return nil, nil, false
+	}
+	if save {
+		if err := cfg.SaveUser(owner.Username); err != nil {
+			return nil, nil, false
+		}
+	}
+	u, ok := cfg.GetUserByUsername(owner.Username)
+	return u, res, ok
+}
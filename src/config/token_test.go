@@ -0,0 +1,117 @@
+package config
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokens(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	if _, _, err := cfg.AddToken("user1", "ci", []string{"read", "sudo"}, nil); err != cnst.ErrInvalidOption {
+		t.Fatal("unknown scope must fail", err)
+	}
+	if _, _, err := cfg.AddToken("user1", "ci", []string{SCOPE_ADMIN}, nil); err != cnst.ErrInvalidOption {
+		t.Fatal("admin scope must be granted only to admins", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if _, _, err := cfg.AddToken("user1", "ci", []string{SCOPE_READ}, &past); err != cnst.ErrInvalidOption {
+		t.Fatal("expired token must not be created", err)
+	}
+	tk, plain, err := cfg.AddToken("user1", "ci", []string{SCOPE_READ, SCOPE_WRITE}, nil)
+	if err != nil || len(tk.Hash) > 0 || len(plain) == 0 {
+		t.Fatal(err)
+	}
+	if _, _, ok := cfg.CheckToken("user2", plain); ok {
+		t.Fatal("token of other user must fail")
+	}
+	if _, _, ok := cfg.CheckToken("", plain+"x"); ok {
+		t.Fatal("wrong token must fail")
+	}
+	u, res, ok := cfg.CheckToken("", plain)
+	if !ok || u.Username != "user1" || !res.HasScope(SCOPE_WRITE) || res.HasScope(SCOPE_SHARE) {
+		t.Fatal("token must pass")
+	}
+	if u.Tokens[0].LastUsed == nil || u.Tokens[0].Hash == plain {
+		t.Fatal("wrong token state")
+	}
+	//hashes are kept at secrets
+	s, c := cfg.splitSecrets(true)
+	if len(s.Tokens["user1:"+tk.ID]) == 0 {
+		t.Fatal("hash must be at secrets")
+	}
+	for _, cu := range c.Users {
+		for _, ct := range cu.Tokens {
+			if len(ct.Hash) > 0 {
+				t.Fatal("hash must not be at config")
+			}
+		}
+	}
+
+	soon := time.Now().Add(50 * time.Millisecond)
+	_, short, _ := cfg.AddToken("user1", "short", []string{SCOPE_READ}, &soon)
+	time.Sleep(60 * time.Millisecond)
+	if _, _, ok = cfg.CheckToken("user1", short); ok {
+		t.Fatal("expired token must fail")
+	}
+	if err = cfg.DeleteToken("user1", tk.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok = cfg.CheckToken("user1", plain); ok {
+		t.Fatal("revoked token must fail")
+	}
+}
+
+func TestAppPasswordsMigration(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bf_")
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "bf.json")
+	//app passwords of older versions, hash of one kept at config file, of other at secrets file
+	conf := `{"schemaVersion": 1, "filesPath": "` + dir + `", "log": "stderr", "auth": {"key": "c2VjcmV0a2V5"},
+		"users": [{"username": "user1", "admin": true, "password": "$2a$10$hash", "appPasswords": [
+		{"id": "a1", "label": "phone", "hash": "` + hashSecret("0123456789abcdef") + `", "created": "2020-01-01T00:00:00Z"},
+		{"id": "a2", "created": "2020-01-01T00:00:00Z"}]}]}`
+	sec := `{"appPasswords": {"user1:a2": "` + hashSecret("fedcba9876543210") + `"}}`
+	if err := ioutil.WriteFile(p, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, SECRETS_FILE), []byte(sec), PERM_SECRET); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &GlobalConfig{Path: p}
+	cfg.ReadConfigFile()
+
+	for _, plain := range []string{"0123456789abcdef", "fedcba9876543210"} {
+		_, tk, ok := cfg.CheckToken("user1", plain)
+		if !ok || !tk.Legacy || !tk.HasScope(SCOPE_READ) || !tk.HasScope(SCOPE_WRITE) {
+			t.Fatal("app password must be migrated into webdav token", plain)
+		}
+		//webdav only
+		if _, _, ok = cfg.CheckToken("", plain); ok {
+			t.Error("migrated app password must need username")
+		}
+	}
+	if _, _, ok := cfg.CheckToken("user1", "1"); ok {
+		t.Error("wrong password must fail")
+	}
+
+	//migrated once, tokens are read back after restart
+	b, _ := ioutil.ReadFile(p)
+	if strings.Contains(string(b), "appPasswords") {
+		t.Error("app passwords must be dropped from config file")
+	}
+	cfg2 := &GlobalConfig{Path: p}
+	cfg2.ReadConfigFile()
+	if u, _ := cfg2.GetUserByUsername("user1"); len(u.Tokens) != 2 || u.Tokens[0].Name != "phone" {
+		t.Fatal("migrated tokens must be kept", u.Tokens)
+	}
+	if _, _, ok := cfg2.CheckToken("user1", "fedcba9876543210"); !ok {
+		t.Error("migrated token must pass after restart")
+	}
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/browsefile/backend/src/cnst"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//totp parameters, the ones authenticator apps use by default
+const (
+	TOTP_ISSUER = "Browsefile"
+	TOTP_PERIOD = 30
+	TOTP_DIGITS = 6
+	//codes of neighbour periods are accepted, because clocks drift
+	TOTP_SKEW = 1
+	//count of recovery codes, generated once second factor enabled
+	TOTP_RECOVERY_CODES = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//codes are truncated to TOTP_DIGITS
+var totpModulus = uint32(math.Pow10(TOTP_DIGITS))
+
+//second factor of web login
+type TOTPConfig struct {
+	//base32 encoded shared secret
+	Secret string `json:"secret"`
+	//false until user confirmed enrollment by valid code
+	Enabled bool `json:"enabled"`
+	//sha256 hashes of unused recovery codes
+	Recovery []string `json:"recovery,omitempty"`
+	//last accepted period, so code can't be used twice
+	LastStep int64 `json:"lastStep,omitempty"`
+}
+
+func (t *TOTPConfig) copyTOTP() *TOTPConfig {
+	if t == nil {
+		return nil
+	}
+	res := *t
+	res.Recovery = append([]string(nil), t.Recovery...)
+	return &res
+}
+
+//true in case web login needs second factor
+func (u *UserConfig) HasTOTP() bool {
+	return u.TOTP != nil && u.TOTP.Enabled
+}
+
+//clear password, second factor and token hashes, before user is sent to the client.
+//Second factor and tokens are replaced by copies, so shallow copy of user can be masked
+func (u *UserConfig) MaskSecrets() {
+	u.Password = ""
+	if u.TOTP != nil {
+		u.TOTP = &TOTPConfig{Enabled: u.TOTP.Enabled}
+	}
+	u.Tokens = copyTokens(u.Tokens)
+	for _, t := range u.Tokens {
+		t.Hash = ""
+	}
+}
+
+//random string of n bytes, hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func hashSecret(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+//code of the secret for given period
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, v%totpModulus), nil
+}
+
+//true in case code is valid at now, and was not used before
+func (t *TOTPConfig) checkCode(code string, now time.Time) bool {
+	code = strings.TrimSpace(code)
+	step := now.Unix() / TOTP_PERIOD
+	for s := step - TOTP_SKEW; s <= step+TOTP_SKEW; s++ {
+		if s <= t.LastStep {
+			continue
+		}
+		if c, err := totpCode(t.Secret, s); err == nil && subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			t.LastStep = s
+			return true
+		}
+	}
+	return false
+}
+
+//consume recovery code, true in case it was not used before
+func (t *TOTPConfig) useRecovery(code string) bool {
+	h := hashSecret(strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1)))
+	for i, r := range t.Recovery {
+		if subtle.ConstantTimeCompare([]byte(r), []byte(h)) == 1 {
+			t.Recovery = append(t.Recovery[:i], t.Recovery[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+//otpauth uri of the secret, authenticator apps read it from QR code
+func TOTPURI(username, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", TOTP_ISSUER)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	q.Set("period", fmt.Sprint(TOTP_PERIOD))
+	return "otpauth://totp/" + url.PathEscape(TOTP_ISSUER+":"+username) + "?" + q.Encode()
+}
+
+//modify live user under lock, user saved in case fn succeeded
+func (cfg *GlobalConfig) modUser(username string, fn func(u *UserConfig) error) error {
+	updateLock.Lock()
+	u, ok := usersRam[username]
+	if !ok {
+		updateLock.Unlock()
+		return cnst.ErrNotExist
+	}
+	err := fn(u)
+	updateLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return cfg.SaveUser(username)
+}
+
+//start enrollment of second factor with new secret, it is not required until confirmed
+func (cfg *GlobalConfig) EnrollTOTP(username string) (secret, uri string, err error) {
+	b := make([]byte, 20)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = totpEncoding.EncodeToString(b)
+	err = cfg.modUser(username, func(u *UserConfig) error {
+		if u.HasTOTP() {
+			return cnst.ErrExist
+		}
+		u.TOTP = &TOTPConfig{Secret: secret}
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return secret, TOTPURI(username, secret), nil
+}
+
+//enable second factor once user proved, that authenticator has the secret. Returns recovery codes, shown only once
+func (cfg *GlobalConfig) ConfirmTOTP(username, code string) (recovery []string, err error) {
+	err = cfg.modUser(username, func(u *UserConfig) error {
+		if u.TOTP == nil || u.TOTP.Enabled {
+			return cnst.ErrInvalidOption
+		}
+		if !u.TOTP.checkCode(code, time.Now()) {
+			return cnst.ErrWrongCode
+		}
+		u.TOTP.Enabled = true
+		u.TOTP.Recovery = nil
+		for i := 0; i < TOTP_RECOVERY_CODES; i++ {
+			r := randomHex(5)
+			recovery = append(recovery, r[:5]+"-"+r[5:])
+			u.TOTP.Recovery = append(u.TOTP.Recovery, hashSecret(r))
+		}
+		return nil
+	})
+	return recovery, err
+}
+
+//turn off second factor, pending enrollment is dropped as well
+func (cfg *GlobalConfig) DisableTOTP(username string) error {
+	return cfg.modUser(username, func(u *UserConfig) error {
+		if u.TOTP == nil {
+			return cnst.ErrNotExist
+		}
+		u.TOTP = nil
+		return nil
+	})
+}
+
+//check second factor by authenticator code, or by recovery code, that can be used once
+func (cfg *GlobalConfig) CheckTOTP(username, code string) error {
+	return cfg.modUser(username, func(u *UserConfig) error {
+		if !u.HasTOTP() {
+			return cnst.ErrInvalidOption
+		}
+		if !u.TOTP.checkCode(code, time.Now()) && !u.TOTP.useRecovery(code) {
+			return cnst.ErrWrongCode
+		}
+		return nil
+	})
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	//rfc 6238 test vector, truncated to 6 digits
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	if c, _ := totpCode(secret, 59/TOTP_PERIOD); c != "287082" {
+		t.Fatal("wrong code", c)
+	}
+	if c, _ := totpCode(secret, 1111111109/TOTP_PERIOD); c != "081804" {
+		t.Fatal("wrong code", c)
+	}
+	if c, _ := totpCode(secret, 0); len(c) != TOTP_DIGITS {
+		t.Fatal("code must have TOTP_DIGITS", c)
+	}
+	tc := &TOTPConfig{Secret: secret}
+	now := time.Unix(59, 0)
+	if !tc.checkCode("287082", now) {
+		t.Fatal("valid code must pass")
+	}
+	if tc.checkCode("287082", now) {
+		t.Fatal("code must not be accepted twice")
+	}
+}
+
+func TestTOTPEnroll(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	secret, uri, err := cfg.EnrollTOTP("user1")
+	if err != nil || len(secret) == 0 || len(uri) == 0 {
+		t.Fatal(err)
+	}
+	if u, _ := cfg.GetUserByUsername("user1"); u.HasTOTP() {
+		t.Fatal("second factor must not be required until confirmed")
+	}
+	if _, err = cfg.ConfirmTOTP("user1", "000000"); err != cnst.ErrWrongCode {
+		t.Fatal("wrong code must fail", err)
+	}
+	code, _ := totpCode(secret, time.Now().Unix()/TOTP_PERIOD)
+	recovery, err := cfg.ConfirmTOTP("user1", code)
+	if err != nil || len(recovery) != TOTP_RECOVERY_CODES {
+		t.Fatal(err)
+	}
+	if _, _, err = cfg.EnrollTOTP("user1"); err != cnst.ErrExist {
+		t.Fatal("enrollment must fail once enabled")
+	}
+	//code of confirmation can't be reused
+	if err = cfg.CheckTOTP("user1", code); err != cnst.ErrWrongCode {
+		t.Fatal("used code must fail", err)
+	}
+	if err = cfg.CheckTOTP("user1", recovery[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.CheckTOTP("user1", recovery[0]); err != cnst.ErrWrongCode {
+		t.Fatal("recovery code must be used once")
+	}
+	u, _ := cfg.GetUserByUsername("user1")
+	if !u.HasTOTP() || len(u.TOTP.Recovery) != TOTP_RECOVERY_CODES-1 {
+		t.Fatal("wrong second factor state")
+	}
+	u.MaskSecrets()
+	if len(u.TOTP.Secret) > 0 || len(u.TOTP.Recovery) > 0 {
+		t.Fatal("secret must be masked")
+	}
+	if err = cfg.DisableTOTP("user1"); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ = cfg.GetUserByUsername("user1"); u.HasTOTP() {
+		t.Fatal("second factor must be disabled")
+	}
+}
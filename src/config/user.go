@@ -40,6 +40,16 @@ type UserConfig struct {
 	//create files/folders according this ownership
 	UID int `json:"uid"`
 	GID int `json:"gid"`
+	//storage limits, global default is used in case not set
+	Quota *Quota `json:"quota,omitempty"`
+	//access rules for paths inside home, applied to shares of this user as well
+	Rules PathRules `json:"pathRules,omitempty"`
+	//second factor of web login
+	TOTP *TOTPConfig `json:"totp,omitempty"`
+	//personal access tokens of scripts and webdav clients
+	Tokens []*AccessToken `json:"tokens,omitempty"`
+	//webdav passwords of older versions, read only once to be migrated into tokens
+	AppPasswords []*appPassword `json:"appPasswords,omitempty"`
 }
 
 func (u *UserConfig) copyUser() (res *UserConfig) {
@@ -57,6 +67,10 @@ func (u *UserConfig) copyUser() (res *UserConfig) {
 		GID:          u.GID,
 		DavHandler:   u.DavHandler,
 		IpAuth:       make([]string, len(u.IpAuth)),
+		Quota:        u.Quota.copyQuota(),
+		Rules:        u.Rules,
+		TOTP:         u.TOTP.copyTOTP(),
+		Tokens:       copyTokens(u.Tokens),
 	}
 	copy(res.IpAuth, u.IpAuth)
 	res.Shares = make([]*ShareItem, len(u.Shares))
@@ -95,15 +109,19 @@ func (u *UserConfig) DeleteShare(relPath string) (res bool) {
 }
 func (u *UserConfig) deleteShare(relPath string) (res bool) {
 	res = false
-
-	for i, shr := range u.Shares {
-		if strings.HasPrefix(relPath, shr.Path) || strings.HasPrefix(shr.Path, relPath) {
-			u.Shares = append(u.Shares[:i], u.Shares[i+1:]...)
-			delSharePath(shr, u.Username)
-			res = true
+	//exact share first, otherwise overlapping share of nested path can be removed instead
+	i := -1
+	for j, shr := range u.Shares {
+		if shr.Path == strings.TrimSuffix(relPath, "/") {
+			i = j
 			break
+		} else if i < 0 && (strings.HasPrefix(relPath, shr.Path) || strings.HasPrefix(shr.Path, relPath)) {
+			i = j
 		}
-
+	}
+	if i >= 0 {
+		u.Shares = append(u.Shares[:i], u.Shares[i+1:]...)
+		res = true
 	}
 	return res
 }
@@ -112,10 +130,14 @@ func (u *UserConfig) deleteShare(relPath string) (res bool) {
 func (u *UserConfig) AddShare(shr *ShareItem) (res bool) {
 
 	shr.Path = strings.TrimSuffix(shr.Path, "/")
+	shr.Hash = u.shareHash(shr.Path)
 	u.Shares = append(u.Shares, shr)
-	shr.Hash = GenShareHash(u.Username, shr.Path)
+	if shr.AllowExternal && len(shr.Links) == 0 {
+		shr.Links = []*ShareLink{NewShareLink("")}
+	} else if !shr.AllowExternal {
+		shr.Links = nil
+	}
 	res = true
-	addSharePath(shr, u.Username)
 	return
 }
 
@@ -165,6 +187,12 @@ func (cfg *GlobalConfig) GetUsers() (res []*UserConfig) {
 }
 
 func (cfg *GlobalConfig) AddUser(u *UserConfig) error {
+	if err := cfg.addUser(u); err != nil {
+		return err
+	}
+	return cfg.SaveUser(u.Username)
+}
+func (cfg *GlobalConfig) addUser(u *UserConfig) error {
 	updateLock.Lock()
 	defer updateLock.Unlock()
 	_, exists := usersRam[u.Username]
@@ -178,6 +206,12 @@ func (cfg *GlobalConfig) AddUser(u *UserConfig) error {
 	return nil
 }
 func (cfg *GlobalConfig) UpdatePassword(u *UserConfig) error {
+	if err := cfg.updatePassword(u); err != nil {
+		return err
+	}
+	return cfg.SaveUser(u.Username)
+}
+func (cfg *GlobalConfig) updatePassword(u *UserConfig) error {
 	updateLock.Lock()
 	defer updateLock.Unlock()
 	i := cfg.getUserIndex(u.Username)
@@ -190,6 +224,12 @@ func (cfg *GlobalConfig) UpdatePassword(u *UserConfig) error {
 	return nil
 }
 func (cfg *GlobalConfig) Update(u *UserConfig) error {
+	if err := cfg.update(u); err != nil {
+		return err
+	}
+	return cfg.SaveUser(u.Username)
+}
+func (cfg *GlobalConfig) update(u *UserConfig) error {
 	updateLock.Lock()
 	defer updateLock.Unlock()
 	i := cfg.getUserIndex(u.Username)
@@ -206,6 +246,8 @@ func (cfg *GlobalConfig) Update(u *UserConfig) error {
 		cfg.Users[i].LockPassword = u.LockPassword
 		cfg.Users[i].UID = u.UID
 		cfg.Users[i].GID = u.GID
+		cfg.Users[i].Quota = u.Quota.copyQuota()
+		cfg.Users[i].Rules = u.Rules.copyRules()
 		cfg.RefreshUserRam()
 	} else {
 		return errors.New("User does not exists " + u.Username)
@@ -216,19 +258,34 @@ func (cfg *GlobalConfig) Update(u *UserConfig) error {
 
 //delete user by username
 func (cfg *GlobalConfig) DeleteUser(username string) error {
+	inGroups, err := cfg.deleteUser(username)
+	if err != nil {
+		return err
+	}
+	cfg.ResetUsage(username)
+	if cfg.store == nil {
+		return nil
+	}
+	if err = cfg.store.DeleteUser(username); err != nil {
+		return err
+	}
+	if inGroups && !cfg.isJsonStore() {
+		//groups are kept at config file
+		cfg.WriteConfig()
+	}
+	return nil
+}
+func (cfg *GlobalConfig) deleteUser(username string) (inGroups bool, err error) {
 	updateLock.Lock()
 	defer updateLock.Unlock()
 	i := cfg.getUserIndex(username)
 	if i >= 0 {
-		for _, shr := range cfg.Users[i].Shares {
-			cfg.Users[i].deleteShare(shr.Path)
-		}
-
 		cfg.Users = append(cfg.Users[:i], cfg.Users[i+1:]...)
 	}
+	inGroups = cfg.leaveGroups(username)
 	cfg.RefreshUserRam()
 
-	return nil
+	return inGroups, nil
 }
 
 //get user index in cfg users array
@@ -6,6 +6,7 @@ import (
 	"github.com/browsefile/backend/src/lib/utils"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strings"
 )
 
@@ -22,6 +23,8 @@ type Context struct {
 	*Params
 	FitFilter
 	Rendered bool
+	//personal access token of request, nil in case of session
+	Token *config.AccessToken
 }
 
 //params in URL request
@@ -44,6 +47,8 @@ type Params struct {
 	FilePaths []string
 
 	Auth string
+	//token, that unlocks password protected external share
+	ShareAuth string
 
 	Checksum string
 
@@ -65,6 +70,9 @@ type Params struct {
 	Order string
 	//is share request
 	IsShare bool
+	//permission of the user and share root at owner home, in case request writes into local share of other user
+	SharePerm string
+	ShareRoot string
 	//requestURL
 	URL    string
 	Method string
@@ -72,47 +80,43 @@ type Params struct {
 	REQ    *http.Request
 }
 
-//cut user home path from for non download routes
-func (c *Context) CutPath(path string) string {
-	if c.Router != cnst.R_DOWNLOAD {
-		if c.IsShare {
-			if c.IsExternal {
-				path = strings.TrimPrefix(path, c.GetUserSharexPath())
-			} else {
-				path = strings.TrimPrefix(path, c.GetUserSharesPath())
-			}
-
-		} else {
-			path = strings.TrimPrefix(path, c.GetUserHomePath())
-		}
-	}
-	return path
-
-}
 func (c *Context) GetUserHomePath() string {
 	return c.Config.GetUserHomePath(c.User.Username)
 }
 func (c *Context) GetUserPreviewPath() string {
 	return c.Config.GetUserPreviewPath(c.User.Username)
 }
-func (c *Context) GetUserSharesPath() string {
-	return c.Config.GetUserSharesPath(c.User.Username)
-}
-func (c *Context) GetUserSharexPath() string {
-	return c.Config.GetUserSharexPath(c.User.Username)
-}
-func (c *Context) ResolvePathContext(i *File) (p string, fs FileSystem) {
+
+//file system of current context: user files, local or external shares
+func (c *Context) FileSystemContext() FileSystem {
 	if c.IsExternal {
-		fs = c.User.FileSystemSharex
-		p = c.GetUserSharexPath()
+		return c.User.FileSystemSharex
 	} else if c.IsShare {
-		fs = c.User.FileSystemShares
-		p = c.GetUserSharesPath()
-	} else {
-		fs = c.User.FileSystem
-		p = c.GetUserHomePath()
+		return c.User.FileSystemShares
 	}
-	return
+	return c.User.FileSystem
+}
+
+//absolute path of p at current context file system, share paths are mapped to owner home
+func (c *Context) AbsPath(p string) (string, error) {
+	if fs, ok := c.FileSystemContext().(*ShareFS); ok {
+		return fs.Resolve(p)
+	}
+	return filepath.Join(c.GetUserHomePath(), utils.SlashClean(p)), nil
+}
+
+//path rules and path at the owner home for p at current context file system, shares use rules of the share owner
+func (c *Context) PathRules(p string) (config.PathRules, string) {
+	if c.IsShare || c.IsExternal {
+		return c.Config.SharePathRules(p, c.IsExternal)
+	}
+	return c.User.Rules, p
+}
+
+//error in case p at current context file system can't be read according path rules
+func (c *Context) CheckRead(p string) error {
+	r, rp := c.PathRules(p)
+	return r.CheckRead(rp)
 }
 
 func (c *Context) GenPreview(out string) {
@@ -150,11 +154,15 @@ func (c *Context) GetAuthConfig() *config.ListenConf {
 
 // MakeInfo gets the file information, and replace user in context in case share rquest
 func (c *Context) MakeInfo() (*File, error) {
-	p, _, err := c.ResolveContextUser()
-	info, err, path := utils.GetFileInfo(p, c.URL)
+	if _, err := c.ResolveContextUser(); err != nil {
+		return nil, err
+	}
+	info, err := c.FileSystemContext().Stat(c.URL)
 	if err != nil {
 		return nil, err
 	}
+	//virtual folders of shares have no path
+	path, _ := c.AbsPath(c.URL)
 	i := &File{
 		URL:         c.URL,
 		VirtualPath: utils.SlashClean(c.URL),
@@ -173,8 +181,13 @@ func (c *Context) MakeInfo() (*File, error) {
 	return i, nil
 }
 
-// build correct path, and replace user in context in case external share
-func (c *Context) ResolveContextUser() (p, previewPath string, err error) {
+// build preview path, and replace user in context in case external share
+func (c *Context) ResolveContextUser() (previewPath string, err error) {
+	if c.IsShare || c.IsExternal {
+		if err = c.Config.CheckShare(c.URL, c.IsExternal); err != nil {
+			return "", err
+		}
+	}
 	if c.IsExternal {
 		var h string
 
@@ -182,20 +195,25 @@ func (c *Context) ResolveContextUser() (p, previewPath string, err error) {
 
 		itm, usr := c.Config.GetExternal(h)
 		if itm == nil {
-			return "", "", cnst.ErrNotExist
+			return "", cnst.ErrNotExist
 		}
 		if !itm.IsAllowed(c.User.Username) {
-			return "", "", cnst.ErrShareAccess
+			return "", cnst.ErrShareAccess
+		}
+		if itm.IsProtected() && !CheckShareToken(c.Config, c.ShareAuth, itm) {
+			return "", cnst.ErrShareLocked
+		}
+		//content of file drop is never shown through external link
+		if itm.IsDrop() && c.Method != http.MethodPost {
+			return "", cnst.ErrShareAccess
 		}
 		c.User = ToUserModel(usr, c.Config)
-		p = c.GetUserSharexPath()
 
 	} else if c.IsShare {
 		previewPath, _ = c.Config.GetSharePreviewPath(c.URL, false)
-		p = c.GetUserSharesPath()
 
 	} else {
-		p, previewPath = c.GetUserHomePath(), c.GetUserPreviewPath()
+		previewPath = c.GetUserPreviewPath()
 	}
 	return
 }
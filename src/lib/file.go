@@ -69,101 +69,77 @@ type Listing struct {
 	AllowGeneratePreview bool `json:"allowGeneratePreview"`
 }
 
-//recursively fetch share/file paths
+//recursively fetch share/file paths, relative to the file system of current context
 func (i *File) GetListing(c *Context) (files []os.FileInfo, paths []string, err error) {
-	p, fs := c.ResolvePathContext(i)
+	fs := c.FileSystemContext()
 	//fetch all files
 	if c.IsRecursive {
-		files, paths = i.listRecurs(c, filepath.Join(p, i.VirtualPath))
-	} else {
-		//only list content
-		inf, err := fs.Stat(i.VirtualPath)
-		if err != nil {
-			return nil, nil, err
+		//own listing starts from the folder itself
+		if inf, err := fs.Stat(i.VirtualPath); err == nil && !c.IsShare && c.CheckRead(i.VirtualPath) == nil &&
+			(c.FitFilter == nil || c.FitFilter(inf.Name(), i.VirtualPath)) {
+			files, paths = append(files, inf), append(paths, i.VirtualPath)
 		}
-		if inf.IsDir() {
-			f, err := fs.OpenFile(i.VirtualPath, os.O_RDONLY, 0, c.User.UID, c.User.GID)
-			if err != nil {
-				return nil, nil, err
-			}
-			defer f.Close()
-			// Reads the directory and gets the information about the files.
-			names, err := f.Readdirnames(-1)
-			for _, n := range names {
-				nMod := filepath.Join(i.VirtualPath, n)
-				paths = append(paths, nMod)
-				inf, err := fs.Stat(nMod)
-				if err != nil {
-					return nil, nil, err
-				}
-				files = append(files, inf)
-			}
-			if err != nil {
-				return nil, nil, err
-			}
-		} else {
-			p := filepath.Join(fs.String(), i.VirtualPath)
-			if c.IsShare {
-				inf, p, err = utils.ResolveSymlink(p)
-				if err != nil {
-					return nil, nil, err
-				}
-			}
-			files = append(files, inf)
-			paths = append(paths, i.VirtualPath)
+		fr, pr := i.listRecurs(c, fs, i.VirtualPath)
+		return append(files, fr...), append(paths, pr...), nil
+	}
+	//only list content
+	inf, err := fs.Stat(i.VirtualPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !inf.IsDir() {
+		return []os.FileInfo{inf}, []string{i.VirtualPath}, nil
+	}
+	// Reads the directory and gets the information about the files.
+	names, err := fs.ReadDirNames(i.VirtualPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, n := range names {
+		nMod := filepath.Join(i.VirtualPath, n)
+		if r, rp := c.PathRules(nMod); r.IsHidden(rp) {
+			continue
+		}
+		inf, err := fs.Stat(nMod)
+		//share source was removed
+		if os.IsNotExist(err) && c.IsShare {
+			continue
+		} else if err != nil {
+			return nil, nil, err
 		}
+		paths = append(paths, nMod)
+		files = append(files, inf)
 	}
 	return files, paths, nil
-
 }
-func (i *File) listRecurs(c *Context, path string) (files []os.FileInfo, paths []string) {
-	err := filepath.Walk(path,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return nil
-			}
-
-			if c.IsShare {
-				//get files from current user shares folder
-				info, shrPath, _ := utils.ResolveSymlink(path)
-				if info.IsDir() {
-					infDir, err := os.OpenFile(shrPath, os.O_RDONLY, 0)
-					if err != nil {
-						return nil
-					}
-					//step to reduce problem in recursion
-					names, err := infDir.Readdir(-1)
-					if err != nil {
-						return nil
-					}
-					for _, name := range names {
-						if name.IsDir() {
-							fr, pr := i.listRecurs(c, filepath.Join(path, name.Name()))
-							files = append(files, fr...)
-							paths = append(paths, pr...)
-						} else {
-							if c.FitFilter != nil && c.FitFilter(name.Name(), shrPath) ||
-								c.FitFilter == nil {
-								files = append(files, name)
-								paths = append(paths, c.CutPath(filepath.Join(path, name.Name())))
-							}
-						}
-					}
 
-				}
-				return nil
-
-			} else {
-				if c.FitFilter != nil && c.FitFilter(info.Name(), path) || c.FitFilter == nil {
-					files = append(files, info)
-					paths = append(paths, c.CutPath(path))
-				}
-			}
-
-			return nil
-		})
+//files and folders inside p, that fit the filter and can be read according path rules
+func (i *File) listRecurs(c *Context, fs FileSystem, p string) (files []os.FileInfo, paths []string) {
+	names, err := fs.ReadDirNames(p)
 	if err != nil {
 		log.Println(err)
+		return nil, nil
+	}
+	for _, n := range names {
+		np := filepath.Join(p, n)
+		//skip whole tree, that can't be read according path rules
+		if c.CheckRead(np) != nil {
+			continue
+		}
+		info, err := fs.Stat(np)
+		if err != nil {
+			continue
+		}
+		//shares show files only
+		if (!info.IsDir() || !c.IsShare) && (c.FitFilter == nil || c.FitFilter(info.Name(), np)) {
+			files = append(files, info)
+			paths = append(paths, np)
+		}
+		if info.IsDir() {
+			fr, pr := i.listRecurs(c, fs, np)
+			files = append(files, fr...)
+			paths = append(paths, pr...)
+		}
 	}
 	return files, paths
 }
@@ -186,15 +162,7 @@ func (i *File) ProcessList(c *Context) error {
 		return err
 	}
 	for ind, f := range files {
-		name := f.Name()
-
-		//resolve share symlink
-		if c.IsShare && !c.IsRecursive {
-			f, _, _ = utils.ResolveSymlink(filepath.Join(i.Path, name))
-		}
-
 		if f.IsDir() {
-			name += "/"
 			dirCount++
 		} else {
 			fileCount++
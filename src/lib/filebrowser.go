@@ -10,6 +10,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
 // ReCaptcha settings.
@@ -24,8 +25,8 @@ type ReCaptcha struct {
 type FileBrowser struct {
 	// The static assets.
 	Assets *rice.Box
-	// ReCaptcha host, key and secret.
-	ReCaptcha *ReCaptcha
+	// ReCaptcha host, key and secret, replaced on config reload.
+	reCaptcha atomic.Value
 	// NewFS should build a new file system for a given path.
 	NewFS FSBuilder
 	//generates preview
@@ -33,6 +34,19 @@ type FileBrowser struct {
 	Config *config.GlobalConfig
 }
 
+//current reCaptcha settings, safe to call while config is reloaded
+func (fb *FileBrowser) GetReCaptcha() *ReCaptcha {
+	r, _ := fb.reCaptcha.Load().(*ReCaptcha)
+	if r == nil {
+		return &ReCaptcha{}
+	}
+	return r
+}
+
+func (fb *FileBrowser) SetReCaptcha(r *ReCaptcha) {
+	fb.reCaptcha.Store(r)
+}
+
 // FileSystem is the interface to work with the file system.
 type FileSystem interface {
 	Mkdir(name string, perm os.FileMode, uid, gid int) error
@@ -40,6 +54,7 @@ type FileSystem interface {
 	RemoveAll(name string) error
 	Rename(oldName, newName string) error
 	Stat(name string) (os.FileInfo, error)
+	ReadDirNames(name string) ([]string, error)
 	Copy(src, dst string, uid, gid int) error
 	String() string
 }
@@ -50,8 +65,9 @@ type UserModel struct {
 	FileSystem FileSystem `json:"-"`
 	// FileSystem is the virtual file system the user has access, uses to store previews.
 	FileSystemPreview FileSystem `json:"-"`
-	FileSystemShares  FileSystem `json:"-"`
-	FileSystemSharex  FileSystem `json:"-"`
+	//virtual file systems of local and external shares
+	FileSystemShares FileSystem `json:"-"`
+	FileSystemSharex FileSystem `json:"-"`
 }
 
 // FSBuilder is the File System Builder.
@@ -79,21 +95,8 @@ func (fb *FileBrowser) Setup() (bool, error) {
 		needUpdate = true
 		fb.Config.SetKey(bytes)
 	}
-	users := fb.Config.GetUsers()
-	for _, u := range users {
-		if u.FirstRun {
-			u.FirstRun = false
-			needUpdate = true
-			u.Password, err = HashPassword(u.Password)
-			if err != nil {
-				log.Println(err)
-			}
-		}
-	}
-
-	if needUpdate {
-		fb.Config.Users = users
-		fb.Config.RefreshUserRam()
+	if fb.HashFirstRunPasswords() {
+		needUpdate = true
 	}
 	fb.Pgen = new(preview.PreviewGen)
 	fb.Pgen.Setup(fb.Config.Threads, fb.Config.ScriptPath)
@@ -114,12 +117,41 @@ func (fb *FileBrowser) Setup() (bool, error) {
 	return needUpdate, nil
 }
 
+//hash plain passwords of users marked as first run, returns true in case any user was modified
+func (fb *FileBrowser) HashFirstRunPasswords() (needUpdate bool) {
+	var err error
+	var hashed []string
+	users := fb.Config.GetUsers()
+	for _, u := range users {
+		if u.FirstRun {
+			u.FirstRun = false
+			needUpdate = true
+			u.Password, err = HashPassword(u.Password)
+			if err != nil {
+				log.Println(err)
+			}
+			hashed = append(hashed, u.Username)
+		}
+	}
+
+	if needUpdate {
+		fb.Config.Users = users
+		fb.Config.RefreshUserRam()
+		for _, name := range hashed {
+			if err = fb.Config.SaveUser(name); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	return needUpdate
+}
+
 func ToUserModel(u *config.UserConfig, cfg *config.GlobalConfig) *UserModel {
 	return &UserModel{u,
 		utils.Dir(cfg.GetUserHomePath(u.Username)),
 		utils.Dir(cfg.GetUserPreviewPath(u.Username)),
-		utils.Dir(cfg.GetUserSharesPath(u.Username)),
-		utils.Dir(cfg.GetUserSharexPath(u.Username)),
+		NewShareFS(cfg, u.Username, false),
+		NewShareFS(cfg, u.Username, true),
 	}
 }
 
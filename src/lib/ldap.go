@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/browsefile/backend/src/config"
+	"github.com/go-ldap/ldap/v3"
+	"io/ioutil"
+	"net/url"
+	"time"
+)
+
+//timeout of directory requests
+const LDAP_TIMEOUT = 10 * time.Second
+
+//user entry of directory, that passed bind
+type LDAPIdentity struct {
+	DN string
+	//group DNs and their common names
+	Groups []string
+}
+
+func ldapTLS(l *config.LDAPConfig) (*tls.Config, error) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return nil, err
+	}
+	res := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: l.InsecureSkipVerify}
+	if len(l.CACert) > 0 {
+		b, err := ioutil.ReadFile(l.CACert)
+		if err != nil {
+			return nil, err
+		}
+		res.RootCAs = x509.NewCertPool()
+		if !res.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.New("ldap : no certificates at " + l.CACert)
+		}
+	}
+	return res, nil
+}
+
+//check password of the user by bind as its entry, found by search filter
+func LDAPAuth(l *config.LDAPConfig, username, password string) (*LDAPIdentity, error) {
+	if len(username) == 0 || len(password) == 0 {
+		return nil, errors.New("ldap : empty credentials")
+	}
+	tc, err := ldapTLS(l)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ldap.DialURL(l.URL, ldap.DialWithTLSConfig(tc))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(LDAP_TIMEOUT)
+	if l.StartTLS {
+		if err = conn.StartTLS(tc); err != nil {
+			return nil, err
+		}
+	}
+	if len(l.BindDN) > 0 {
+		if err = conn.Bind(l.BindDN, l.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap : search account bind failed %v", err)
+		}
+	}
+	attr := l.GetGroupAttribute()
+	rs, err := conn.Search(ldap.NewSearchRequest(l.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2,
+		int(LDAP_TIMEOUT/time.Second), false, fmt.Sprintf(l.GetUserFilter(), ldap.EscapeFilter(username)),
+		[]string{"dn", attr}, nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(rs.Entries) != 1 {
+		return nil, fmt.Errorf("ldap : %d entries found for %s", len(rs.Entries), username)
+	}
+	e := rs.Entries[0]
+	if err = conn.Bind(e.DN, password); err != nil {
+		return nil, err
+	}
+	res := &LDAPIdentity{DN: e.DN}
+	for _, g := range e.GetAttributeValues(attr) {
+		res.Groups = append(res.Groups, g)
+		//cn of group DN, so groups can be mapped by name
+		if dn, err := ldap.ParseDN(g); err == nil && len(dn.RDNs) > 0 && len(dn.RDNs[0].Attributes) > 0 {
+			res.Groups = append(res.Groups, dn.RDNs[0].Attributes[0].Value)
+		}
+	}
+	return res, nil
+}
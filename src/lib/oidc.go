@@ -0,0 +1,352 @@
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/browsefile/backend/src/config"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//lifetime of login attempt, from redirect to provider till callback
+	OIDC_STATE_TTL = 10 * time.Minute
+	//lifetime of token, that is exchanged for session after callback
+	OIDC_LOGIN_TTL = time.Minute
+	//how long provider configuration and keys are cached
+	OIDC_CACHE_TTL = time.Hour
+)
+
+var (
+	oidcClient    = &http.Client{Timeout: 10 * time.Second}
+	oidcProviders = make(map[string]*OIDCProvider)
+	oidcLock      = new(sync.Mutex)
+)
+
+//endpoints of identity provider, discovered from issuer
+type OIDCProvider struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+	//signing keys by key id
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+//claims of state token, kept at cookie between redirect to provider and callback
+type OIDCStateClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.StandardClaims
+}
+
+//claims of token, issued by callback and exchanged for session
+type OIDCLoginClaims struct {
+	User string `json:"oidcUser"`
+	jwt.StandardClaims
+}
+
+//provider of the issuer, configuration is fetched once and cached
+func GetOIDCProvider(issuer string) (*OIDCProvider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	oidcLock.Lock()
+	p, ok := oidcProviders[issuer]
+	//fetched is updated by fetchKeys under the lock
+	fresh := ok && time.Since(p.fetched) < OIDC_CACHE_TTL
+	oidcLock.Unlock()
+	if fresh {
+		return p, nil
+	}
+	p = &OIDCProvider{}
+	if err := getJSON(issuer+"/.well-known/openid-configuration", p); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc : issuer mismatch %s", p.Issuer)
+	}
+	if len(p.AuthURL) == 0 || len(p.TokenURL) == 0 || len(p.JWKSURL) == 0 {
+		return nil, errors.New("oidc : incomplete provider configuration")
+	}
+	if err := p.fetchKeys(); err != nil {
+		return nil, err
+	}
+	oidcLock.Lock()
+	oidcProviders[issuer] = p
+	oidcLock.Unlock()
+	return p, nil
+}
+
+func getJSON(u string, v interface{}) error {
+	rs, err := oidcClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc : %s responded %d", u, rs.StatusCode)
+	}
+	return json.NewDecoder(rs.Body).Decode(v)
+}
+
+//load rsa signing keys of the provider
+func (p *OIDCProvider) fetchKeys() error {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(p.JWKSURL, &set); err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (len(k.Use) > 0 && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return errors.New("oidc : provider has no rsa signing keys")
+	}
+	oidcLock.Lock()
+	p.keys, p.fetched = keys, time.Now()
+	oidcLock.Unlock()
+	return nil
+}
+
+func (p *OIDCProvider) key(kid string) (*rsa.PublicKey, bool) {
+	oidcLock.Lock()
+	defer oidcLock.Unlock()
+	if k, ok := p.keys[kid]; ok {
+		return k, true
+	}
+	//single key without id
+	if len(kid) == 0 && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+//url of provider login page
+func (p *OIDCProvider) AuthCodeURL(o *config.OIDCConfig, redirect, state, nonce, verifier string) string {
+	scopes := []string{"openid"}
+	for _, s := range o.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", o.ClientID)
+	q.Set("redirect_uri", redirect)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", PKCEChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + q.Encode()
+}
+
+//exchange authorization code for id token
+func (p *OIDCProvider) Exchange(o *config.OIDCConfig, code, verifier, redirect string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirect)
+	form.Set("client_id", o.ClientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequest(http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(o.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	}
+	rs, err := oidcClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer rs.Body.Close()
+	var res struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err = json.NewDecoder(rs.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if rs.StatusCode != http.StatusOK || len(res.IDToken) == 0 {
+		return "", fmt.Errorf("oidc : token exchange failed %d %s", rs.StatusCode, res.Error)
+	}
+	return res.IDToken, nil
+}
+
+//claims of id token, in case it is signed by provider, issued for this client and bound to nonce
+func (p *OIDCProvider) Verify(o *config.OIDCConfig, idToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("oidc : unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		if k, ok := p.key(kid); ok {
+			return k, nil
+		}
+		//keys rotated
+		if err := p.fetchKeys(); err != nil {
+			return nil, err
+		}
+		if k, ok := p.key(kid); ok {
+			return k, nil
+		}
+		return nil, errors.New("oidc : unknown signing key " + kid)
+	}
+	t, err := jwt.ParseWithClaims(idToken, claims, keyFunc)
+	if err != nil || !t.Valid {
+		return nil, fmt.Errorf("oidc : invalid id token %v", err)
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(o.Issuer, "/") {
+		return nil, errors.New("oidc : wrong issuer " + iss)
+	}
+	if !hasAudience(claims["aud"], o.ClientID) {
+		return nil, errors.New("oidc : token issued for other client")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("oidc : token without expiration")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("oidc : wrong nonce")
+	}
+	return claims, nil
+}
+
+func hasAudience(aud interface{}, client string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == client
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == client {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//username and groups from id token claims
+func OIDCIdentity(o *config.OIDCConfig, claims jwt.MapClaims) (username string, groups []string) {
+	username, _ = claims[o.GetUsernameClaim()].(string)
+	switch g := claims[o.GetGroupsClaim()].(type) {
+	case string:
+		groups = strings.Fields(strings.Replace(g, ",", " ", -1))
+	case []interface{}:
+		for _, v := range g {
+			if s, ok := v.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+	return
+}
+
+//random url safe string
+func RandomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//S256 code challenge of PKCE verifier
+func PKCEChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func signClaims(cfg *config.GlobalConfig, claims jwt.Claims) (string, error) {
+	k, err := cfg.GetKeyBytes()
+	if err != nil {
+		return "", err
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k)
+}
+
+func parseClaims(cfg *config.GlobalConfig, token string, claims jwt.Claims) bool {
+	if len(token) == 0 {
+		return false
+	}
+	t, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return cfg.GetKeyBytes()
+	})
+	return err == nil && t.Valid
+}
+
+//signed state of login attempt
+func GenOIDCState(cfg *config.GlobalConfig, state, nonce, verifier string) (string, error) {
+	return signClaims(cfg, OIDCStateClaims{state, nonce, verifier, jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(OIDC_STATE_TTL).Unix(),
+		Subject:   "oidc-state",
+		Issuer:    "Browse File",
+	}})
+}
+
+func CheckOIDCState(cfg *config.GlobalConfig, token string) (*OIDCStateClaims, bool) {
+	var claims OIDCStateClaims
+	if !parseClaims(cfg, token, &claims) || claims.Subject != "oidc-state" {
+		return nil, false
+	}
+	return &claims, true
+}
+
+//signed short lived token of user, logged in by provider
+func GenOIDCLogin(cfg *config.GlobalConfig, username string) (string, error) {
+	return signClaims(cfg, OIDCLoginClaims{username, jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(OIDC_LOGIN_TTL).Unix(),
+		Subject:   "oidc",
+		Issuer:    "Browse File",
+	}})
+}
+
+func CheckOIDCLogin(cfg *config.GlobalConfig, token string) (*config.UserConfig, bool) {
+	var claims OIDCLoginClaims
+	if !parseClaims(cfg, token, &claims) || claims.Subject != "oidc" {
+		return nil, false
+	}
+	return cfg.GetUserByUsername(claims.User)
+}
@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/browsefile/backend/src/config"
+	"github.com/dgrijalva/jwt-go"
+	"time"
+)
+
+//lifetime of token, that unlocks password protected share
+const SHARE_TOKEN_TTL = time.Hour
+
+//claims of token, that unlocks single external share
+type ShareClaims struct {
+	Share string `json:"share"`
+	jwt.StandardClaims
+}
+
+//token is bound to the password hash, so it is revoked once share password changed
+func passwordFingerprint(hash string) string {
+	s := sha256.Sum256([]byte(hash))
+	return hex.EncodeToString(s[:8])
+}
+
+//signed short lived token, that unlocks share
+func GenShareToken(cfg *config.GlobalConfig, shr *config.ShareItem) (string, error) {
+	claims := ShareClaims{
+		shr.Hash,
+		jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(SHARE_TOKEN_TTL).Unix(),
+			Id:        passwordFingerprint(shr.Password),
+			Issuer:    "Browse File",
+		},
+	}
+	k, err := cfg.GetKeyBytes()
+	if err != nil {
+		return "", err
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k)
+}
+
+//true in case token is valid and unlocks given share
+func CheckShareToken(cfg *config.GlobalConfig, token string, shr *config.ShareItem) bool {
+	if len(token) == 0 {
+		return false
+	}
+	var claims ShareClaims
+	t, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return cfg.GetKeyBytes()
+	})
+	return err == nil && t.Valid && claims.Share == shr.Hash && claims.Id == passwordFingerprint(shr.Password)
+}
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib/utils"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+//read only file system of shares available to the user, share paths are mapped to owner home at request time.
+//Local shares are at /owner/name_hash, external ones at /name_token
+type ShareFS struct {
+	cfg      *config.GlobalConfig
+	user     string
+	external bool
+}
+
+func NewShareFS(cfg *config.GlobalConfig, user string, external bool) *ShareFS {
+	return &ShareFS{cfg, user, external}
+}
+
+//absolute path of name inside share, os.ErrNotExist in case name is virtual folder or share is not available
+func (fs *ShareFS) Resolve(name string) (string, error) {
+	owner, p, err := fs.cfg.ResolveShare(name, fs.user, fs.external)
+	if err != nil {
+		return "", os.ErrNotExist
+	}
+	return filepath.Join(fs.cfg.GetUserHomePath(owner), p), nil
+}
+
+//entries of virtual folder at name, false in case name is inside share
+func (fs *ShareFS) VirtualDir(name string) ([]string, bool) {
+	if fs.external {
+		//external share is reachable by its link only
+		return []string{}, utils.SlashClean(name) == "/"
+	}
+	return fs.cfg.ShareDirNames(name, fs.user)
+}
+
+func (fs *ShareFS) Mkdir(name string, perm os.FileMode, uid, gid int) error {
+	return os.ErrPermission
+}
+
+func (fs *ShareFS) OpenFile(name string, flag int, perm os.FileMode, uid, gid int) (*os.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	p, err := fs.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, flag, perm)
+}
+
+func (fs *ShareFS) RemoveAll(name string) error {
+	return os.ErrPermission
+}
+
+func (fs *ShareFS) Rename(oldName, newName string) error {
+	return os.ErrPermission
+}
+
+func (fs *ShareFS) Stat(name string) (os.FileInfo, error) {
+	if _, ok := fs.VirtualDir(name); ok {
+		return utils.DirInfo(path.Base(utils.SlashClean(name)), time.Time{}), nil
+	}
+	p, err := fs.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (fs *ShareFS) ReadDirNames(name string) ([]string, error) {
+	if names, ok := fs.VirtualDir(name); ok {
+		return names, nil
+	}
+	p, err := fs.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return utils.Dir(p).ReadDirNames("/")
+}
+
+func (fs *ShareFS) Copy(src, dst string, uid, gid int) error {
+	return os.ErrPermission
+}
+
+func (fs *ShareFS) String() string {
+	if fs.external {
+		return "external shares of " + fs.user
+	}
+	return "shares of " + fs.user
+}
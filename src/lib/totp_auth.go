@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"github.com/browsefile/backend/src/config"
+	"github.com/dgrijalva/jwt-go"
+	"time"
+)
+
+//lifetime of token, that is exchanged for session once second factor passed
+const TOTP_TOKEN_TTL = 5 * time.Minute
+
+//claims of token, issued after password is checked, but before second factor.
+//It has no username claim, so it is never accepted as session token
+type TOTPClaims struct {
+	User string `json:"totpUser"`
+	jwt.StandardClaims
+}
+
+//signed short lived token, that proves password of the user
+func GenTOTPToken(cfg *config.GlobalConfig, u *config.UserConfig) (string, error) {
+	claims := TOTPClaims{
+		u.Username,
+		jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(TOTP_TOKEN_TTL).Unix(),
+			Id:        passwordFingerprint(u.Password),
+			Subject:   "totp",
+			Issuer:    "Browse File",
+		},
+	}
+	k, err := cfg.GetKeyBytes()
+	if err != nil {
+		return "", err
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k)
+}
+
+//user, whose password is proved by token. Token is revoked once password changed
+func CheckTOTPToken(cfg *config.GlobalConfig, token string) (*config.UserConfig, bool) {
+	if len(token) == 0 {
+		return nil, false
+	}
+	var claims TOTPClaims
+	t, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return cfg.GetKeyBytes()
+	})
+	if err != nil || !t.Valid || claims.Subject != "totp" {
+		return nil, false
+	}
+	u, ok := cfg.GetUserByUsername(claims.User)
+	if !ok || claims.Id != passwordFingerprint(u.Password) {
+		return nil, false
+	}
+	return u, true
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // A Dir uses the native file system restricted to a specific directory tree.
@@ -88,6 +89,19 @@ func (d Dir) Stat(name string) (os.FileInfo, error) {
 	return os.Stat(name)
 }
 
+// ReadDirNames returns names of the directory entries in this directory context.
+func (d Dir) ReadDirNames(name string) ([]string, error) {
+	if name = d.resolve(name); name == "" {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
 // Copy copies a file or directory from src to dst. If it is
 // a directory, all of the files and sub-directories will be copied.
 func (d Dir) Copy(src, dst string, uid, gid int) error {
@@ -123,3 +137,32 @@ func (d Dir) Copy(src, dst string, uid, gid int) error {
 func (d Dir) String() string {
 	return string(d)
 }
+
+//info of directory, that exists only virtually
+type dirInfo struct {
+	name string
+	mod  time.Time
+}
+
+func DirInfo(name string, mod time.Time) os.FileInfo {
+	return &dirInfo{name, mod}
+}
+
+func (i *dirInfo) Name() string       { return i.name }
+func (i *dirInfo) Size() int64        { return 0 }
+func (i *dirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (i *dirInfo) ModTime() time.Time { return i.mod }
+func (i *dirInfo) IsDir() bool        { return true }
+func (i *dirInfo) Sys() interface{}   { return nil }
+
+//info of existing file, presented under other name
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func NamedInfo(inf os.FileInfo, name string) os.FileInfo {
+	return &namedInfo{inf, name}
+}
+
+func (i *namedInfo) Name() string { return i.name }
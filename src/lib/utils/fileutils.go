@@ -6,6 +6,7 @@ import (
 	"archive/zip"
 	"github.com/browsefile/backend/src/cnst"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
@@ -68,17 +69,6 @@ func GetFileType(name string) (res bool, t string) {
 	return
 }
 
-//should get information about file.
-func GetFileInfo(scope, urlPath string) (info os.FileInfo, err error, path string) {
-	dir := Dir(scope)
-	info, err = dir.Stat(urlPath)
-	path = filepath.Join(scope, urlPath)
-	if err != nil {
-		return nil, err, ""
-	}
-	return info, err, path
-}
-
 func Exists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -123,8 +113,8 @@ func ModPermission(uid, gid int, path string) (err error) {
 
 }
 
-//write archive file to writer, paths - absolute files paths, filesFolder - absolute path for users folder, this method will trim user folder path from archive
-func ServeArchiveCompress(paths []string, filesFolder string, writer io.Writer, infos []os.FileInfo) (err error) {
+//write archive file to writer, paths - absolute files paths, names - paths of the files inside archive
+func ServeArchiveCompress(paths, names []string, writer io.Writer, infos []os.FileInfo) (err error) {
 	archive := zip.NewWriter(writer)
 	defer func() {
 		err = archive.Flush()
@@ -137,7 +127,7 @@ func ServeArchiveCompress(paths []string, filesFolder string, writer io.Writer,
 		}
 	}()
 	for i, f := range paths {
-		p := CutUserPath(f, filesFolder)
+		p := names[i]
 		file, err := os.OpenFile(f, os.O_RDONLY, 0)
 		if err != nil {
 			return err
@@ -163,21 +153,114 @@ func ServeArchiveCompress(paths []string, filesFolder string, writer io.Writer,
 	}
 	return err
 }
-func ResolveSymlink(p string) (inf os.FileInfo, realPath string, err error) {
-	realPath, err = filepath.EvalSymlinks(p)
+//write data to the temp file at the same folder, sync it and rename over destination, so readers never see partial file
+func WriteFileAtomic(p string, data []byte, perm os.FileMode) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".tmp")
 	if err != nil {
-		return nil, "", err
+		return err
 	}
-	inf, err = os.Stat(realPath)
-	return
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
 }
 
-/**
-removes users path, and trim next prefix userName/files
-filesPath - path for users data directory
-*/
-func CutUserPath(p, filesPath string) string {
-	p = strings.TrimPrefix(p, filesPath)
-	arr := strings.SplitN(p, "/", 4)
-	return arr[len(arr)-1]
+//line based diff, result lines prefixed by ' ' unchanged, '-' removed from a, '+' added from b
+func DiffLines(a, b []string) (res []string) {
+	//cut common prefix and suffix, usually diff is small
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	for _, l := range a[:pre] {
+		res = append(res, " "+l)
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if len(ma)*len(mb) > 4000000 {
+		//too big for lcs, show as full replace
+		for _, l := range ma {
+			res = append(res, "-"+l)
+		}
+		for _, l := range mb {
+			res = append(res, "+"+l)
+		}
+	} else {
+		res = append(res, lcsDiff(ma, mb)...)
+	}
+	for _, l := range a[len(a)-suf:] {
+		res = append(res, " "+l)
+	}
+	return res
+}
+
+func lcsDiff(a, b []string) (res []string) {
+	//lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			res = append(res, " "+a[i])
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			res = append(res, "-"+a[i])
+			i++
+		} else {
+			res = append(res, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		res = append(res, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		res = append(res, "+"+b[j])
+	}
+	return res
+}
+
+//total size and count of regular files under path, symlinks are not followed
+func DirSize(p string) (size, files int64) {
+	_ = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+			files++
+		}
+		return nil
+	})
+	return size, files
 }
@@ -1,6 +1,9 @@
 package utils
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 var testSlashClean = []struct {
 	Value  string
@@ -63,6 +66,14 @@ func TestSlashClean(t *testing.T) {
 		}
 	}
 }
+func TestDiffLines(t *testing.T) {
+	a := strings.Split("a b c d e", " ")
+	b := strings.Split("a c d x e", " ")
+	res := strings.Join(DiffLines(a, b), ",")
+	if res != " a,-b, c, d,+x, e" {
+		t.Errorf("Incorrect diff; got: %v", res)
+	}
+}
 func BenchmarkGetOnExtension(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		GetFileType("f.jpg")
@@ -0,0 +1,151 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestPathRulesResource(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	_ = cfg.User1FS.Mkdir("/drop", cnst.PERM_DEFAULT, 0, 0)
+	cfg.Usr1.Rules = config.PathRules{
+		{Path: cfg.SharePathDeep, Mode: config.RULE_HIDDEN},
+		{Path: "/t.txt", Mode: config.RULE_READ_ONLY},
+		{Path: "/drop", Mode: config.RULE_UPLOAD_ONLY},
+	}
+	_ = cfg.Update(cfg.Usr1)
+
+	//hidden folder is not listed
+	dat := map[string]interface{}{"u": cfg.SharePathUp}
+	_, rs, _ := cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+	ValidateListingResp(rs, t, 7)
+	for u, code := range map[string]int{cfg.SharePathDeep + "/t.jpg": http.StatusNotFound, "/drop/": http.StatusForbidden, "/t.txt": http.StatusOK} {
+		dat = map[string]interface{}{"u": u}
+		if _, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false); rs.StatusCode != code {
+			t.Error("wrong read status for", u, rs.StatusCode)
+		}
+	}
+
+	dat = map[string]interface{}{"u": "/t.txt", "method": http.MethodPut, "body": bytes.NewBufferString("x")}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("read only file must not be modified", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": "/t.txt", "method": http.MethodDelete}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("read only file must not be deleted", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": "/t.txt", "method": http.MethodPatch, "destination": "/t2.txt", "action": "rename"}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("read only file must not be renamed", rs.StatusCode)
+	}
+
+	dat = map[string]interface{}{"u": "/drop/a.txt", "method": http.MethodPost, "body": bytes.NewBufferString("x")}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusOK {
+		t.Error("upload only folder must accept new files", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": "/drop/a.txt", "method": http.MethodPut, "body": bytes.NewBufferString("y")}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("uploaded file must not be replaced", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": "/drop/a.txt"}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("uploaded file must not be downloaded", rs.StatusCode)
+	}
+
+	//hidden path can't be shared
+	dat = map[string]interface{}{"u": "/", "method": http.MethodPost, "body": bytes.NewBufferString(`{"path":"` + cfg.SharePathDeep + `","allowLocal":true}`)}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true); rs.StatusCode != http.StatusNotFound {
+		t.Error("hidden path must not be shared", rs.StatusCode)
+	}
+}
+
+func TestPathRulesShareSearch(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	cfg.Usr1.Rules = config.PathRules{{Path: cfg.SharePathDeep, Mode: config.RULE_HIDDEN}}
+	_ = cfg.Update(cfg.Usr1)
+
+	//consumers see share without hidden content of the owner
+	p := "/" + cfg.Usr1.Username + "/" + cfg.Usr1.GetShares(cfg.SharePathUp, false)[0].ResolveSymlinkName()
+	dat := map[string]interface{}{"u": p, "query": "type:i "}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SEARCH, dat, cfg.GetAdmin(), t, true)
+	ValidateListingResp(rs, t, 2)
+
+	dat = map[string]interface{}{"u": p + "/share/t.jpg"}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.GetAdmin(), t, true); rs.StatusCode != http.StatusNotFound {
+		t.Error("hidden file must not be read through share", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": "/", "query": "type:i "}
+	_, rs, _ = cfg.MakeRequest(cnst.R_SEARCH, dat, cfg.Usr1, t, false)
+	ValidateListingResp(rs, t, 4)
+}
+
+func TestPathRulesDav(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	cfg.Usr1.Rules = config.PathRules{
+		{Path: cfg.SharePathDeep, Mode: config.RULE_HIDDEN},
+		{Path: "/t.txt", Mode: config.RULE_READ_ONLY},
+	}
+	_ = cfg.Update(cfg.Usr1)
+	fs := &davRulesFS{newDavFS(cfg.GlobalConfig, "user1"), cfg.GlobalConfig, "user1"}
+	ctx := context.TODO()
+
+	if _, err := fs.Stat(ctx, cnst.WEB_DAV_URL+"/files"+cfg.SharePathDeep); !os.IsNotExist(err) {
+		t.Error("hidden folder must not exist", err)
+	}
+	f, err := fs.OpenFile(ctx, cnst.WEB_DAV_URL+"/files"+cfg.SharePathUp, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, _ := f.Readdir(0)
+	_ = f.Close()
+	if len(infos) != 7 {
+		t.Error("hidden folder must not be listed", len(infos))
+	}
+	if err = fs.RemoveAll(ctx, cnst.WEB_DAV_URL+"/files/t.txt"); !os.IsPermission(err) {
+		t.Error("read only file must not be removed", err)
+	}
+	if _, err = fs.OpenFile(ctx, cnst.WEB_DAV_URL+"/files/t.txt", os.O_RDWR|os.O_TRUNC, 0); !os.IsPermission(err) {
+		t.Error("read only file must not be modified", err)
+	}
+	if err = fs.Rename(ctx, cnst.WEB_DAV_URL+"/files/t.jpg", cnst.WEB_DAV_URL+"/files/t2.jpg"); err != nil {
+		t.Error("not restricted file must be renamed", err)
+	}
+}
+
+func TestPathRulesKeptOnUpdate(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	cfg.Usr1.Rules = config.PathRules{{Path: "/t.txt", Mode: config.RULE_READ_ONLY}}
+	_ = cfg.Update(cfg.Usr1)
+
+	put := func(data string) int {
+		body := bytes.NewBufferString(`{"what":"user","which":"all","data":` + data + `}`)
+		dat := map[string]interface{}{"u": "/user1", "method": http.MethodPut, "body": body}
+		_, rs, _ := cfg.MakeRequest(cnst.R_USERS, dat, cfg.GetAdmin(), t, false)
+		return rs.StatusCode
+	}
+	if c := put(`{"username":"user1","allowNew":true}`); c != http.StatusOK {
+		t.Fatal("update must pass", c)
+	}
+	if u, _ := cfg.GetUserByUsername("user1"); len(u.Rules) != 1 {
+		t.Error("omitted rules must be kept", u.Rules)
+	}
+	if c := put(`{"username":"user1","pathRules":[]}`); c != http.StatusOK {
+		t.Fatal("update must pass", c)
+	}
+	if u, _ := cfg.GetUserByUsername("user1"); len(u.Rules) != 0 {
+		t.Error("admin can clear rules", u.Rules)
+	}
+}
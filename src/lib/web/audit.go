@@ -0,0 +1,73 @@
+package web
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"log"
+	"net/http"
+	"time"
+)
+
+//response writer, that counts bytes sent to the client
+type countingWriter struct {
+	http.ResponseWriter
+	n      int64
+	status int
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+//serve share request by fn, and record served access into the log of the share owner
+func auditShare(c *fb.Context, fn func(c *fb.Context) (int, error)) (int, error) {
+	if !c.IsShare && !c.IsExternal {
+		return fn(c)
+	}
+	shrURL := c.URL
+	if len(c.FilePaths) > 0 {
+		shrURL = c.FilePaths[0]
+	}
+	multi := len(c.FilePaths) > 1
+	//user is replaced by share owner while request is served
+	e := &config.AccessEntry{IP: clientIP(c.Config, c.REQ)}
+	if !c.User.IsGuest() {
+		e.User = c.User.Username
+	}
+	w := &countingWriter{ResponseWriter: c.RESP}
+	c.RESP = w
+	code, err := fn(c)
+	c.RESP = w.ResponseWriter
+	if err != nil || code >= http.StatusBadRequest {
+		return code, err
+	}
+
+	switch {
+	case c.Router == cnst.R_PLAYLIST:
+		e.Action = config.ACCESS_PLAYLIST
+	case len(c.PreviewType) > 0:
+		e.Action = config.ACCESS_PREVIEW
+	case c.Router != cnst.R_DOWNLOAD:
+		e.Action = config.ACCESS_LIST
+	case multi || c.File == nil || c.File.IsDir:
+		e.Action = config.ACCESS_ZIP
+	default:
+		e.Action = config.ACCESS_DOWNLOAD
+	}
+	e.Time, e.Bytes = time.Now(), w.n
+	if lErr := c.Config.LogAccess(shrURL, c.IsExternal, e); lErr != nil {
+		log.Println(lErr)
+	}
+	return code, err
+}
@@ -1,6 +1,8 @@
 package web
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/browsefile/backend/src/cnst"
 	"github.com/browsefile/backend/src/config"
@@ -17,16 +19,53 @@ import (
 )
 
 var (
-	authKeySession = make(map[string]bool)
+	//expiration of verified webdav credentials by hash of Authorization header
+	authKeySession = make(map[string]time.Time)
 	authKeyLock    = new(sync.RWMutex)
 )
 
+//verified webdav password is trusted without hashing for this duration
+const AUTH_CACHE_TTL = 5 * time.Minute
+
+func authCacheKey(auth string) string {
+	h := sha256.Sum256([]byte(auth))
+	return hex.EncodeToString(h[:])
+}
+
+func isDavAuthCached(auth string) bool {
+	authKeyLock.RLock()
+	exp, ok := authKeySession[authCacheKey(auth)]
+	authKeyLock.RUnlock()
+	return ok && time.Now().Before(exp)
+}
+
+func cacheDavAuth(auth string) {
+	now := time.Now()
+	authKeyLock.Lock()
+	for k, e := range authKeySession {
+		if now.After(e) {
+			delete(authKeySession, k)
+		}
+	}
+	authKeySession[authCacheKey(auth)] = now.Add(AUTH_CACHE_TTL)
+	authKeyLock.Unlock()
+}
+
+//forget verified credentials, so changed passwords and revoked tokens apply immediately
+func clearDavAuth() {
+	authKeyLock.Lock()
+	authKeySession = make(map[string]time.Time)
+	authKeyLock.Unlock()
+}
+
 const reCaptchaAPI = "/recaptcha/api/siteverify"
 
 type cred struct {
 	Password  string `json:"password"`
 	Username  string `json:"username"`
 	ReCaptcha string `json:"recaptcha"`
+	//code of second factor, in case it is sent together with password
+	OTP string `json:"otp"`
 }
 
 // reCaptcha checks the reCaptcha code.
@@ -76,28 +115,54 @@ func authDavHandler(c *fb.Context, w http.ResponseWriter, r *http.Request) (res
 		http.Error(w, "Not authorized", http.StatusUnauthorized)
 		return
 	}
+	ip := clientIP(c.Config, r)
+	if rejectBlocked(w, ipKey(ip)) {
+		http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+		return
+	}
 
+	//tokens are checked every time, so revocation, expiry and scopes apply to each request
+	if u, t, isToken := c.Config.CheckToken(username, password); isToken {
+		if !davTokenAllows(t, r.Method) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		c.User = fb.ToUserModel(u, c.Config)
+		c.Token = t
+		return true
+	}
+	//directory users are created on first login
+	isLDAP := cfgM.AuthMethod == "ldap"
 	user, ok := c.Config.GetUserByUsername(username)
-	if !ok {
+	if !ok && !isLDAP {
+		loginFailed(ip, username, "webdav")
 		http.Error(w, "Not authorized", http.StatusUnauthorized)
 		return
 	}
 	auth := r.Header.Get("Authorization")
-	authKeyLock.RLock()
-	isAuth := authKeySession[auth]
-	authKeyLock.RUnlock()
-	if !isAuth {
-		//very expensive operation, need to minimize hash function call
-		if !fb.CheckPasswordHash(password, user.Password) {
-			log.Println("Wrong Password for user", username)
+	if !ok || !isDavAuthCached(auth) {
+		//clients already verified are not locked out, only new guesses are
+		if rejectBlocked(w, ipKey(ip), userKey(username)) {
+			http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+		//user password can't pass second factor, so only tokens are accepted then
+		if isLDAP {
+			//binds are cached by own ttl, so directory password change is applied
+			if user, ok = ldapUser(c, username, password); !ok || user.HasTOTP() {
+				loginFailed(ip, username, "webdav")
+				http.Error(w, "Not authorized", http.StatusUnauthorized)
+				return
+			}
+		} else if user.HasTOTP() || !fb.CheckPasswordHash(password, user.Password) {
+			//very expensive operation, need to minimize hash function call
+			loginFailed(ip, username, "webdav")
 			http.Error(w, "Not authorized", http.StatusUnauthorized)
 			return
+		} else {
+			cacheDavAuth(auth)
 		}
-
-		auth := r.Header.Get("Authorization")
-		authKeyLock.Lock()
-		authKeySession[auth] = true
-		authKeyLock.Unlock()
+		loginSucceeded(userKey(username))
 	}
 	c.User = fb.ToUserModel(user, c.Config)
 
@@ -129,6 +194,9 @@ func authHandler(c *fb.Context) (int, error) {
 		c.User = fb.ToUserModel(uc, c.Config)
 
 		return printToken(c)
+	} else if cfgM.AuthMethod == "oidc" {
+		//passwords are checked by identity provider, second factor is still checked here
+		return oidcLogin(c)
 	}
 
 	// Receive the credentials from the request and unmarshal them.
@@ -142,10 +210,14 @@ func authHandler(c *fb.Context) (int, error) {
 	if err != nil {
 		return http.StatusForbidden, err
 	}
+	ip := clientIP(c.Config, c.REQ)
+	if rejectBlocked(c.RESP, ipKey(ip), userKey(cred.Username)) {
+		return http.StatusTooManyRequests, nil
+	}
 
 	// If ReCaptcha is enabled, check the code.
-	if len(c.ReCaptcha.Secret) > 0 {
-		ok, err := reCaptcha(c.ReCaptcha.Host, c.ReCaptcha.Secret, cred.ReCaptcha)
+	if rc := c.GetReCaptcha(); len(rc.Secret) > 0 {
+		ok, err := reCaptcha(rc.Host, rc.Secret, cred.ReCaptcha)
 		if err != nil {
 			return http.StatusForbidden, err
 		}
@@ -154,26 +226,114 @@ func authHandler(c *fb.Context) (int, error) {
 		}
 	}
 
-	uc, ok := c.Config.GetUserByUsername(cred.Username)
+	var uc *config.UserConfig
+	var ok bool
+	if cfgM.AuthMethod == "ldap" && cred.Username != cnst.GUEST {
+		//password is checked by directory, user is created on first login
+		uc, ok = ldapUser(c, cred.Username, cred.Password)
+	} else if uc, ok = c.Config.GetUserByUsername(cred.Username); ok && !uc.IsGuest() {
+		// Checks if the password is correct.
+		ok = fb.CheckPasswordHash(cred.Password, uc.Password)
+	}
 	if !ok {
+		loginFailed(ip, cred.Username, "web")
 		return http.StatusForbidden, nil
 	}
-	if !uc.IsGuest() {
-		// Checks if the password is correct.
-		if !ok || !fb.CheckPasswordHash(cred.Password, uc.Password) {
-			return http.StatusForbidden, nil
-		}
+	if uc.HasTOTP() {
+		return secondFactor(c, uc, cred.OTP)
 	}
+	loginSucceeded(userKey(uc.Username))
 
 	c.User = fb.ToUserModel(uc, c.Config)
 	return printToken(c)
 }
 
+//second step of login, in case code is missed responds with token, that is exchanged for session at /auth/totp
+func secondFactor(c *fb.Context, uc *config.UserConfig, code string) (int, error) {
+	if len(code) == 0 {
+		t, err := fb.GenTOTPToken(c.Config, uc)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return renderJSON(c, map[string]string{"secondFactor": "totp", "token": t})
+	}
+	ip := clientIP(c.Config, c.REQ)
+	if rejectBlocked(c.RESP, ipKey(ip), userKey(uc.Username)) {
+		return http.StatusTooManyRequests, nil
+	}
+	if err := c.Config.CheckTOTP(uc.Username, code); err != nil {
+		loginFailed(ip, uc.Username, "totp")
+		return cnst.ErrorToHTTP(err, false), nil
+	}
+	loginSucceeded(userKey(uc.Username))
+	c.User = fb.ToUserModel(uc, c.Config)
+	return printToken(c)
+}
+
+//finish login by second factor code, and token from first step
+func totpAuthHandler(c *fb.Context) (int, error) {
+	if c.Method != http.MethodPost || c.REQ.Body == nil {
+		return http.StatusMethodNotAllowed, nil
+	}
+	var cred struct {
+		Token string `json:"token"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(c.REQ.Body).Decode(&cred); err != nil {
+		return http.StatusBadRequest, err
+	}
+	uc, ok := fb.CheckTOTPToken(c.Config, cred.Token)
+	if !ok || len(cred.Code) == 0 {
+		return http.StatusForbidden, nil
+	}
+	return secondFactor(c, uc, cred.Code)
+}
+
+//unlock password protected external share, responds with share token
+func shareAuthHandler(c *fb.Context) (int, error) {
+	if c.Method != http.MethodPost || c.REQ.Body == nil {
+		return http.StatusMethodNotAllowed, nil
+	}
+	var cred struct {
+		//external share url, like /name_hash
+		Share    string `json:"share"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(c.REQ.Body).Decode(&cred); err != nil {
+		return http.StatusBadRequest, err
+	}
+	_, h := c.Config.GetSharePreviewPath(cred.Share, true)
+	shr, _ := c.Config.GetExternal(h)
+	if shr == nil || !shr.IsAllowed(cnst.GUEST) {
+		return http.StatusNotFound, nil
+	}
+	if shr.IsProtected() {
+		//password hash is checked only in case share and address are not delayed by failed attempts
+		ip := clientIP(c.Config, c.REQ)
+		if rejectBlocked(c.RESP, ipKey(ip), shareKey(shr.Hash)) {
+			return http.StatusTooManyRequests, nil
+		}
+		if !fb.CheckPasswordHash(cred.Password, shr.Password) {
+			shareUnlockFailed(ip, shr.Hash)
+			return http.StatusForbidden, nil
+		}
+		loginSucceeded(shareKey(shr.Hash))
+	}
+	signed, err := fb.GenShareToken(c.Config, shr)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	c.RESP.Header().Set("Content-Type", "cty")
+	c.RESP.Write([]byte(signed))
+	return 0, nil
+}
+
 // renewAuthHandler is used when the front-end already has a JWT token
 // and is checking if it is up to date. If so, updates its info.
 func renewAuthHandler(c *fb.Context) (int, error) {
 	ok, u := validateAuth(c)
-	if !ok {
+	//personal access token can't be exchanged for session
+	if !ok || c.Token != nil {
 		return http.StatusForbidden, nil
 	}
 	c.User = u
@@ -192,7 +352,9 @@ func printToken(c *fb.Context) (int, error) {
 	// hash so it never arrives to the user.
 	u := fb.UserModel{}
 	u = *c.User
-	u.Password = ""
+	uc := *c.User.UserConfig
+	uc.MaskSecrets()
+	u.UserConfig = &uc
 
 	// Builds the claims.
 	claims := Claims{
@@ -230,7 +392,7 @@ func printToken(c *fb.Context) (int, error) {
 type extractor []string
 
 func (e extractor) ExtractToken(r *http.Request) (string, error) {
-	token, _ := request.HeaderExtractor{cnst.H_XAUTH}.ExtractToken(r)
+	token, _ := request.MultiExtractor{request.HeaderExtractor{cnst.H_XAUTH}, request.AuthorizationHeaderExtractor}.ExtractToken(r)
 
 	// Checks if the token isn't empty and if it contains two dots.
 	// The former prevents incompatibility with URLs that previously
@@ -282,6 +444,13 @@ func validateAuth(c *fb.Context) (bool, *fb.UserModel) {
 			return false, nil
 		}
 
+	} else if tok := requestToken(c.REQ); len(tok) > 0 {
+		var t *config.AccessToken
+		if u, t, ok = c.Config.CheckToken("", tok); !ok {
+			log.Println("Wrong access token")
+			return false, nil
+		}
+		c.Token = t
 	} else {
 		token, err := request.ParseFromRequest(c.REQ, extractor{}, keyFunc, request.WithClaims(&claims))
 
@@ -289,6 +458,10 @@ func validateAuth(c *fb.Context) (bool, *fb.UserModel) {
 			log.Println(err)
 			return false, nil
 		}
+		//tokens of share unlock and second factor have no user
+		if claims.UserConfig == nil {
+			return false, nil
+		}
 
 		u, ok = c.Config.GetUserByUsername(claims.Username)
 		if !ok {
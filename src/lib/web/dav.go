@@ -3,8 +3,16 @@ package web
 import (
 	"context"
 	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
 	"github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/utils"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
@@ -17,18 +25,33 @@ func ServeDav(c *lib.Context, w http.ResponseWriter, r *http.Request) {
 		w = newResponseWriterNoBody(w)
 	}
 
-	// If this request modified the files and the user doesn't have permission, or modify any share
-	// to do so, return forbidden.
+	// If this request modified the files and the user doesn't have permission, or modify share
+	// without write permission, return forbidden.
+	var src, dst *davTarget
 	if r.Method == "PUT" || r.Method == "POST" || r.Method == "MKCOL" ||
 		r.Method == "DELETE" || r.Method == "COPY" || r.Method == "MOVE" {
-		if (strings.HasPrefix(r.URL.Path, cnst.WEB_DAV_URL+"/shares") ||
-			!strings.HasPrefix(r.URL.Path, cnst.WEB_DAV_URL+"/files")) ||
-			!(c.User.AllowEdit || c.User.AllowNew) {
+		src = resolveDavTarget(c, r.URL.Path)
+		if d := r.Header.Get("Destination"); len(d) > 0 && (r.Method == "COPY" || r.Method == "MOVE") {
+			dst = resolveDavTarget(c, davDestination(d))
+			if !dst.canWrite(c, false) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		//copy source is only read
+		if src == nil || r.Method != "COPY" && !src.canWrite(c, r.Method == "DELETE" || r.Method == "MOVE") {
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 	}
 
+	if fs, ok := c.User.DavHandler.FileSystem.(*davRulesFS); ok {
+		if err := fs.checkRequest(r); err != nil {
+			w.WriteHeader(cnst.ErrorToHTTP(err, false))
+			return
+		}
+	}
+
 	// Excerpt from RFC4918, section 9.4:
 	//
 	// 		GET, when applied to a collection, may return the contents of an
@@ -47,8 +70,179 @@ func ServeDav(c *lib.Context, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	var qr *quotaReader
+	switch r.Method {
+	case "PUT":
+		var err error
+		//quota of the share owner, in case upload goes into share
+		qc, p := src.context(c), src.path
+		if qr, err = checkUploadQuota(qc, p, r.Body, r.ContentLength); err != nil {
+			w.WriteHeader(cnst.ErrorToHTTP(err, false))
+			return
+		}
+		r.Body = ioutil.NopCloser(qr)
+		w = &quotaResponseWriter{w, qr}
+		defer func() {
+			if qr.exceeded {
+				removeExceeded(qc, p)
+			}
+			qr.finish()
+		}()
+	case "COPY":
+		//usage is counted again after copy, once reservation is not pending
+		owner := c.User.Username
+		if dst != nil {
+			owner = dst.owner.Username
+		}
+		c.Config.BeginWrite(owner)
+		defer c.Config.EndWrite(owner)
+		if err := checkDavCopyQuota(c, src, owner); err != nil {
+			w.WriteHeader(cnst.ErrorToHTTP(err, false))
+			return
+		}
+	}
+
 	// Runs the WebDAV.
 	c.User.DavHandler.ServeHTTP(w, r)
+
+	switch r.Method {
+	case "PUT", "MKCOL":
+		src.chown(c)
+	case "COPY", "MOVE":
+		dst.chown(c)
+	}
+	if r.Method == "MOVE" {
+		moveDavShares(c, src, dst)
+	}
+	if r.Method == "DELETE" {
+		dropDavShares(c, src)
+	}
+	switch r.Method {
+	case "PUT", "POST", "DELETE", "COPY", "MOVE":
+		//count usage again, because dav operations can replace or remove whole trees
+		for _, t := range []*davTarget{src, dst} {
+			if t != nil {
+				c.Config.ResetUsage(t.owner.Username)
+			}
+		}
+	}
+}
+
+//file modified by webdav request, at user files or at local share of other user
+type davTarget struct {
+	owner *config.UserConfig
+	//path at owner home
+	path string
+	//permission of the user and share root at owner home, empty for user files
+	perm string
+	root string
+}
+
+//target of webdav name, nil in case name is neither at user files, nor inside share available for the user
+func resolveDavTarget(c *lib.Context, name string) *davTarget {
+	name = path.Clean("/" + name)
+	files, shares := cnst.WEB_DAV_URL+"/files", cnst.WEB_DAV_URL+"/shares"
+	switch {
+	case name == files || strings.HasPrefix(name, files+"/"):
+		return &davTarget{owner: c.User.UserConfig, path: davFilesPath(name)}
+	case strings.HasPrefix(name, shares+"/"):
+		owner, root, p, perm, err := c.Config.ShareAccess(strings.TrimPrefix(name, shares), c.User.Username)
+		if err != nil {
+			return nil
+		}
+		return &davTarget{owner: owner, path: p, perm: perm, root: root}
+	}
+	return nil
+}
+
+//true in case user can modify target, full - target itself is removed or moved
+func (t *davTarget) canWrite(c *lib.Context, full bool) bool {
+	if t == nil {
+		return false
+	}
+	if len(t.perm) == 0 {
+		return c.User.AllowEdit || c.User.AllowNew
+	}
+	if full {
+		//share root is managed by owner only
+		return t.perm == config.SHARE_FULL && t.path != t.root
+	}
+	return t.perm == config.SHARE_WRITE || t.perm == config.SHARE_FULL
+}
+
+//context of the target owner, used to count quota of the owner
+func (t *davTarget) context(c *lib.Context) *lib.Context {
+	if t.owner.Username == c.User.Username {
+		return c
+	}
+	oc := *c
+	oc.User = lib.ToUserModel(t.owner, c.Config)
+	return &oc
+}
+
+//files created at share of other user belongs to share owner
+func (t *davTarget) chown(c *lib.Context) {
+	if t == nil || t.owner.Username == c.User.Username {
+		return
+	}
+	root := filepath.Join(c.Config.GetUserHomePath(t.owner.Username), t.path)
+	_ = filepath.Walk(root, func(p string, _ os.FileInfo, err error) error {
+		if err == nil {
+			err = utils.ModPermission(t.owner.UID, t.owner.GID, p)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return nil
+	})
+}
+
+//shares of moved files follow them, in case files stay at home of the same owner, otherwise shares are dropped
+func moveDavShares(c *lib.Context, src, dst *davTarget) {
+	if src == nil || dst == nil || utils.Exists(filepath.Join(c.Config.GetUserHomePath(src.owner.Username), src.path)) {
+		//nothing was moved
+		return
+	}
+	var err error
+	if src.owner.Username == dst.owner.Username {
+		err = c.Config.MoveShares(src.owner.Username, src.path, dst.path)
+	} else {
+		err = c.Config.DropShares(src.owner.Username, src.path)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+//shares of deleted files are dropped, like at resource API
+func dropDavShares(c *lib.Context, src *davTarget) {
+	if src == nil || utils.Exists(filepath.Join(c.Config.GetUserHomePath(src.owner.Username), src.path)) {
+		//nothing was deleted
+		return
+	}
+	if err := c.Config.DropShares(src.owner.Username, src.path); err != nil {
+		log.Println(err)
+	}
+}
+
+//webdav path of destination header
+func davDestination(d string) string {
+	u, err := url.Parse(d)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+//user home relative path from webdav files url
+func davFilesPath(p string) string {
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(p, cnst.WEB_DAV_URL+"/files"), "/")
+}
+
+//reserve size of copy source at quota of destination owner, usage is counted again after copy
+func checkDavCopyQuota(c *lib.Context, src *davTarget, owner string) error {
+	size, files := utils.DirSize(filepath.Join(c.Config.GetUserHomePath(src.owner.Username), utils.SlashClean(src.path)))
+	return c.Config.ReserveQuota(owner, size, files)
 }
 
 // responseWriterNoBody is a wrapper used to suprress the body of the response
@@ -0,0 +1,319 @@
+package web
+
+import (
+	"context"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/utils"
+	"golang.org/x/net/webdav"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//webdav file system of the user, own files are at /wd/files and shares of other users at /wd/shares.
+//Shares are mapped to owner home at request time
+type davFS struct {
+	cfg      *config.GlobalConfig
+	username string
+	shares   *fb.ShareFS
+}
+
+func newDavFS(cfg *config.GlobalConfig, username string) *davFS {
+	return &davFS{cfg, username, fb.NewShareFS(cfg, username, false)}
+}
+
+//absolute path for webdav name, or entries in case name is virtual folder
+func (fs *davFS) resolve(name string) (string, []string, error) {
+	name = path.Clean("/" + name)
+	files, shares := cnst.WEB_DAV_URL+"/files", cnst.WEB_DAV_URL+"/shares"
+	switch {
+	case name == "/":
+		return "", []string{cnst.WEB_DAV_FOLDER}, nil
+	case name == cnst.WEB_DAV_URL:
+		return "", []string{"files", "shares"}, nil
+	case name == files || strings.HasPrefix(name, files+"/"):
+		return filepath.Join(fs.cfg.GetUserHomePath(fs.username), filepath.FromSlash(davFilesPath(name))), nil, nil
+	case name == shares || strings.HasPrefix(name, shares+"/"):
+		rel := "/" + strings.TrimPrefix(strings.TrimPrefix(name, shares), "/")
+		if names, ok := fs.shares.VirtualDir(rel); ok {
+			return "", names, nil
+		}
+		p, err := fs.shares.Resolve(rel)
+		return p, nil, err
+	}
+	return "", nil, os.ErrNotExist
+}
+
+//true in case p is user home, that can't be removed or renamed
+func (fs *davFS) isHome(p string) bool {
+	return p == filepath.Clean(fs.cfg.GetUserHomePath(fs.username))
+}
+
+//true in case user can modify name, create - name does not exist yet. Checked here as well as at request,
+//because webdav handler writes not only on PUT and MKCOL, LOCK creates missing file for example
+func (fs *davFS) canWrite(name string, create bool) bool {
+	name = path.Clean("/" + name)
+	shares := cnst.WEB_DAV_URL + "/shares"
+	if strings.HasPrefix(name, shares+"/") {
+		_, _, _, perm, err := fs.cfg.ShareAccess(strings.TrimPrefix(name, shares), fs.username)
+		return err == nil && (perm == config.SHARE_WRITE || perm == config.SHARE_FULL)
+	}
+	u, ok := fs.cfg.GetUserByUsername(fs.username)
+	if !ok {
+		return false
+	} else if create {
+		return u.AllowNew
+	}
+	return u.AllowEdit
+}
+
+func (fs *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p, names, err := fs.resolve(name)
+	if err != nil {
+		return err
+	} else if names != nil {
+		return os.ErrExist
+	} else if !fs.canWrite(name, true) {
+		return os.ErrPermission
+	}
+	return os.Mkdir(p, perm)
+}
+
+func (fs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p, names, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	} else if names != nil {
+		if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+			return nil, os.ErrPermission
+		}
+		return &davDir{fs: fs, name: name, names: names}, nil
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		if _, err = os.Stat(p); !fs.canWrite(name, os.IsNotExist(err)) {
+			return nil, os.ErrPermission
+		}
+	}
+	f, err := os.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fs *davFS) RemoveAll(ctx context.Context, name string) error {
+	p, names, err := fs.resolve(name)
+	if err != nil {
+		return err
+	} else if names != nil || fs.isHome(p) {
+		return os.ErrPermission
+	}
+	return os.RemoveAll(p)
+}
+
+func (fs *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	src, names, err := fs.resolve(oldName)
+	if err != nil {
+		return err
+	} else if names != nil || fs.isHome(src) {
+		return os.ErrPermission
+	}
+	dst, names, err := fs.resolve(newName)
+	if err != nil {
+		return err
+	} else if names != nil || fs.isHome(dst) {
+		return os.ErrPermission
+	}
+	return os.Rename(src, dst)
+}
+
+func (fs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	p, names, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	} else if names != nil {
+		return utils.DirInfo(path.Base(path.Clean("/"+name)), time.Time{}), nil
+	}
+	return os.Stat(p)
+}
+
+//webdav folder, that exists only virtually
+type davDir struct {
+	fs    *davFS
+	name  string
+	names []string
+	pos   int
+}
+
+func (d *davDir) Close() error {
+	return nil
+}
+
+func (d *davDir) Read(p []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *davDir) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (d *davDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *davDir) Readdir(count int) (res []os.FileInfo, err error) {
+	for d.pos < len(d.names) && (count <= 0 || len(res) < count) {
+		n := d.names[d.pos]
+		d.pos++
+		//entries are named by virtual names, like name_hash of shares
+		if inf, err := d.fs.Stat(context.TODO(), path.Join(d.name, n)); err == nil {
+			res = append(res, utils.NamedInfo(inf, n))
+		}
+	}
+	if count > 0 && len(res) == 0 {
+		return nil, io.EOF
+	}
+	return res, nil
+}
+
+func (d *davDir) Stat() (os.FileInfo, error) {
+	return d.fs.Stat(context.TODO(), d.name)
+}
+
+//webdav file system, that applies path rules of the user at files and rules of share owners at shares
+type davRulesFS struct {
+	webdav.FileSystem
+	cfg      *config.GlobalConfig
+	username string
+}
+
+//path rules and path at owner home for webdav name
+func (fs *davRulesFS) rules(name string) (config.PathRules, string) {
+	name = path.Clean("/" + name)
+	files, shares := cnst.WEB_DAV_URL+"/files", cnst.WEB_DAV_URL+"/shares"
+	switch {
+	case name == files || strings.HasPrefix(name, files+"/"):
+		u, ok := fs.cfg.GetUserByUsername(fs.username)
+		if !ok {
+			return nil, name
+		}
+		return u.Rules, davFilesPath(name)
+	case strings.HasPrefix(name, shares+"/"):
+		return fs.cfg.SharePathRules(strings.TrimPrefix(name, shares), false)
+	}
+	return nil, name
+}
+
+//check path rules before request is served, so client gets exact status instead of generic webdav error
+func (fs *davRulesFS) checkRequest(r *http.Request) error {
+	rules, p := fs.rules(r.URL.Path)
+	switch r.Method {
+	case "GET", "HEAD":
+		return rules.CheckRead(p)
+	case "PUT":
+		if _, err := fs.FileSystem.Stat(r.Context(), r.URL.Path); err == nil {
+			return rules.CheckWrite(p)
+		}
+		return rules.CheckCreate(p)
+	case "MKCOL":
+		return rules.CheckCreate(p)
+	case "DELETE", "MOVE":
+		return rules.CheckWrite(p)
+	case "COPY":
+		return rules.CheckReadAll(p)
+	}
+	return nil
+}
+
+func (fs *davRulesFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if r, p := fs.rules(name); r.CheckCreate(p) != nil {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *davRulesFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	r, p := fs.rules(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		var err error
+		if _, sErr := fs.FileSystem.Stat(ctx, name); sErr == nil {
+			err = r.CheckWrite(p)
+		} else {
+			err = r.CheckCreate(p)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if r.IsHidden(p) {
+		return nil, os.ErrNotExist
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if r.CheckRead(p) != nil {
+		//upload only folder still can be opened, to show it, but content is not listed
+		if inf, err := f.Stat(); err != nil || !inf.IsDir() {
+			f.Close()
+			return nil, os.ErrPermission
+		}
+		return &davRulesFile{File: f, fs: fs, name: name, noList: true}, nil
+	}
+	return &davRulesFile{File: f, fs: fs, name: name}, nil
+}
+
+func (fs *davRulesFS) RemoveAll(ctx context.Context, name string) error {
+	if r, p := fs.rules(name); r.CheckWrite(p) != nil {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fs *davRulesFS) Rename(ctx context.Context, oldName, newName string) error {
+	if r, p := fs.rules(oldName); r.CheckWrite(p) != nil {
+		return os.ErrPermission
+	}
+	r, p := fs.rules(newName)
+	if _, err := fs.FileSystem.Stat(ctx, newName); err == nil {
+		if r.CheckWrite(p) != nil {
+			return os.ErrPermission
+		}
+	} else if r.CheckCreate(p) != nil {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs *davRulesFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if r, p := fs.rules(name); r.IsHidden(p) {
+		return nil, os.ErrNotExist
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+//hides folder content according path rules
+type davRulesFile struct {
+	webdav.File
+	fs     *davRulesFS
+	name   string
+	noList bool
+}
+
+func (f *davRulesFile) Readdir(count int) (res []os.FileInfo, err error) {
+	if f.noList {
+		return nil, nil
+	}
+	infos, err := f.File.Readdir(count)
+	for _, inf := range infos {
+		if r, p := f.fs.rules(path.Join(f.name, inf.Name())); !r.IsHidden(p) {
+			res = append(res, inf)
+		}
+	}
+	return res, err
+}
@@ -4,16 +4,35 @@ import (
 	"github.com/browsefile/backend/src/cnst"
 	fb "github.com/browsefile/backend/src/lib"
 	"github.com/browsefile/backend/src/lib/utils"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // downloadHandler creates an archive in one of the supported formats (zip, tar,
 // tar.gz or tar.bz2) and sends it to be downloaded.
 func downloadHandler(c *fb.Context) (code int, err error) {
+	shrURL := c.URL
+	if len(c.FilePaths) > 0 {
+		shrURL = c.FilePaths[0]
+	}
+	//slot is reserved before content is sent, so concurrent requests can't exceed download limit,
+	//and counted only in case download was served
+	counted, err := reserveShareDownload(c, shrURL)
+	if err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
+	if counted {
+		defer func() {
+			if fErr := c.Config.FinishShareDownload(shrURL, c.IsExternal, err == nil); fErr != nil {
+				log.Println(fErr)
+			}
+		}()
+	}
 	if len(c.FilePaths) <= 1 {
 		if len(c.FilePaths) == 1 {
 			c.URL = c.FilePaths[0]
@@ -22,6 +41,9 @@ func downloadHandler(c *fb.Context) (code int, err error) {
 		if len(c.URL) == 0 {
 			return http.StatusBadRequest, cnst.ErrInvalidOption
 		}
+		if err = c.CheckRead(c.URL); err != nil {
+			return cnst.ErrorToHTTP(err, false), err
+		}
 		c.File, err = c.MakeInfo()
 		if err != nil {
 			return cnst.ErrorToHTTP(err, false), err
@@ -33,7 +55,7 @@ func downloadHandler(c *fb.Context) (code int, err error) {
 			return downloadFileHandler(c)
 		} else {
 			//todo: remove redundant makeInfo for single file
-			c.FilePaths = []string{utils.CutUserPath(c.File.Path, c.Config.FilesPath)}
+			c.FilePaths = []string{c.File.VirtualPath}
 		}
 	}
 	code, err, infos := prepareFiles(c)
@@ -49,6 +71,21 @@ func downloadHandler(c *fb.Context) (code int, err error) {
 	return code, err
 }
 
+//reserve download from share at p against its limit, previews and continued ranges are not counted.
+//Returns true in case download was reserved
+func reserveShareDownload(c *fb.Context, p string) (bool, error) {
+	if !c.IsShare && !c.IsExternal || len(c.PreviewType) > 0 || c.Method == http.MethodHead {
+		return false, nil
+	}
+	if r := c.REQ.Header.Get("Range"); len(r) > 0 && !strings.HasPrefix(r, "bytes=0-") {
+		return false, nil
+	}
+	if err := c.Config.ReserveShareDownload(p, c.IsExternal); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 //take c.FilePaths as input, and put absolute path back as a result, also recursively fetch folders for shares/files
 func prepareFiles(c *fb.Context) (int, error, []os.FileInfo) {
 	var resultFiles = make([]string, 0, len(c.FilePaths))
@@ -65,6 +102,9 @@ func prepareFiles(c *fb.Context) (int, error, []os.FileInfo) {
 		if c.IsExternal {
 			c.User = extUsrMod
 		}
+		if err = c.CheckRead(p); err != nil {
+			return cnst.ErrorToHTTP(err, false), err, nil
+		}
 		c.File, err = c.MakeInfo()
 		if err != nil {
 			return cnst.ErrorToHTTP(err, false), err, nil
@@ -98,8 +138,17 @@ func serveDownload(c *fb.Context, infos []os.FileInfo) error {
 	} else {
 		name += ".zip"
 	}
+	//files are named inside archive by their path at current context
+	paths, names := make([]string, len(c.FilePaths)), make([]string, len(c.FilePaths))
+	for i, p := range c.FilePaths {
+		var err error
+		if paths[i], err = c.AbsPath(p); err != nil {
+			return err
+		}
+		names[i] = strings.TrimPrefix(utils.SlashClean(p), "/")
+	}
 	c.RESP.Header().Set("Content-Disposition", "attachment; filename*=utf-8''"+url.PathEscape(name))
-	return utils.ServeArchiveCompress(c.FilePaths, c.Config.FilesPath, c.RESP, infos)
+	return utils.ServeArchiveCompress(paths, names, c.RESP, infos)
 }
 
 //download single file, include preview
@@ -118,7 +167,7 @@ func downloadFileHandler(c *fb.Context) (int, error) {
 	//serve icon
 	if len(c.PreviewType) > 0 {
 		var prevPath string
-		_, prevPath, err = c.ResolveContextUser()
+		prevPath, err = c.ResolveContextUser()
 		if c.IsExternal {
 			prevPath = filepath.Join(prevPath, c.URL)
 		}
@@ -156,7 +205,12 @@ func downloadFileHandler(c *fb.Context) (int, error) {
 	}
 	//serve fullsize file
 	if file != nil {
-		http.ServeContent(c.RESP, c.REQ, stat.Name(), stat.ModTime(), file)
+		w := &countingWriter{ResponseWriter: c.RESP}
+		http.ServeContent(w, c.REQ, stat.Name(), stat.ModTime(), file)
+		//client went away or file was cut, so download must not be counted
+		if c.Method != http.MethodHead && len(c.REQ.Header.Get("Range")) == 0 && w.status == http.StatusOK && w.n < stat.Size() {
+			return 0, io.ErrUnexpectedEOF
+		}
 		return 0, nil
 	}
 
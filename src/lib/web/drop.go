@@ -0,0 +1,142 @@
+package web
+
+import (
+	"fmt"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//renamed names tried for upload, in case original one is taken
+const DROP_RENAME_ATTEMPTS = 100
+
+//upload into file drop by external link(/name_token/file), uploader never sees content of the share
+func dropUploadHandler(c *fb.Context) (int, error) {
+	// Discard any invalid upload before returning to avoid connection
+	// reset error.
+	defer func() {
+		io.Copy(ioutil.Discard, c.REQ.Body)
+	}()
+	//files only, right inside the share
+	rel := strings.Trim(c.URL, "/")
+	i := strings.Index(rel, "/")
+	if i < 0 || strings.HasSuffix(c.URL, "/") || strings.Contains(rel[i+1:], "/") {
+		return http.StatusBadRequest, cnst.ErrInvalidOption
+	}
+	shrURL, name := "/"+rel[:i], rel[i+1:]
+	if name == "." || name == ".." {
+		return http.StatusBadRequest, cnst.ErrInvalidOption
+	}
+	//link, password and lifetime checks, user became share owner
+	if _, err := c.ResolveContextUser(); err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
+	drop, owner, shrPath, err := c.Config.ReserveDrop(shrURL)
+	if err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
+	done := false
+	defer func() {
+		if !done {
+			c.Config.ReleaseDrop(shrURL)
+		}
+	}()
+	if drop.MaxSize > 0 && c.REQ.ContentLength > drop.MaxSize {
+		return http.StatusRequestEntityTooLarge, cnst.ErrTooLarge
+	}
+	limit := &sizeLimitReader{Reader: c.REQ.Body, max: drop.MaxSize}
+	body, err := newUploadReader(c, limit, c.REQ.ContentLength, 0, 1)
+	if err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
+	defer body.finish()
+	f, p, err := createDropFile(c, drop, path.Join(shrPath, name))
+	if err != nil {
+		//release reserved file
+		c.Config.ResetUsage(c.User.Username)
+		if err == cnst.ErrExist {
+			return http.StatusConflict, err
+		}
+		return cnst.ErrorToHTTP(err, false), err
+	}
+	n, err := io.Copy(f, body)
+	_ = f.Close()
+	if limit.exceeded || body.exceeded || err != nil {
+		removeExceeded(c, p)
+		if limit.exceeded {
+			return http.StatusRequestEntityTooLarge, cnst.ErrTooLarge
+		} else if body.exceeded {
+			return http.StatusInsufficientStorage, cnst.ErrQuotaExceeded
+		}
+		return cnst.ErrorToHTTP(err, false), err
+	}
+	body.commit()
+	done = true
+
+	e := &config.DropEntry{Time: time.Now(), Share: shrPath, Name: path.Base(p), Size: n, IP: clientIP(c.Config, c.REQ)}
+	log.Printf("drop : %s uploaded %s (%d bytes) into %s of %s", e.IP, e.Name, e.Size, shrPath, owner)
+	if err = c.Config.LogDrop(owner, e); err != nil {
+		log.Println(err)
+	}
+	return http.StatusOK, nil
+}
+
+//create file for upload at p, according collision rule of the drop. Files are created exclusively,
+//so uploads never replace existing or concurrently uploaded ones
+func createDropFile(c *fb.Context, drop *config.DropConfig, p string) (*os.File, string, error) {
+	ext := filepath.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	np := p
+	for i := 0; i <= DROP_RENAME_ATTEMPTS; i++ {
+		if i > 0 {
+			np = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		if err := c.User.Rules.CheckCreate(np); err != nil {
+			return nil, "", err
+		}
+		f, err := c.User.FileSystem.OpenFile(np, os.O_WRONLY|os.O_CREATE|os.O_EXCL, cnst.PERM_DEFAULT, c.User.UID, c.User.GID)
+		if err == nil {
+			return f, np, nil
+		}
+		if !os.IsExist(err) {
+			return nil, "", err
+		}
+		if drop.OnConflict == config.DROP_REJECT {
+			return nil, "", cnst.ErrExist
+		}
+	}
+	return nil, "", cnst.ErrExist
+}
+
+//upload body limited by max size of the drop, not limited in case max is not positive
+type sizeLimitReader struct {
+	io.Reader
+	max      int64
+	read     int64
+	exceeded bool
+}
+
+func (l *sizeLimitReader) Read(p []byte) (n int, err error) {
+	if l.max <= 0 {
+		return l.Reader.Read(p)
+	}
+	//one extra byte is read, to tell exact size from bigger one
+	if rest := l.max - l.read + 1; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err = l.Reader.Read(p)
+	if l.read += int64(n); l.read > l.max {
+		l.exceeded = true
+		return n - int(l.read-l.max), cnst.ErrTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,124 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileDrop(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	setDrop := func(drop string) {
+		dat := map[string]interface{}{"u": "/", "method": http.MethodPost,
+			"body": bytes.NewBufferString(`{"path":"` + cfg.SharePathUp + `","allowExternal":true,"drop":` + drop + `}`)}
+		if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true); rs.StatusCode != http.StatusOK {
+			t.Fatal("drop share status", rs.StatusCode)
+		}
+	}
+	setDrop(`{"maxSize":5,"maxFiles":2}`)
+	usr1, _ := cfg.GetUserByUsername("user1")
+	shr := usr1.GetShares(cfg.SharePathUp, false)[0]
+	root := "/" + shr.LinkName(shr.Links[0].Token)
+	upload := func(name, body string) int {
+		dat := map[string]interface{}{"u": root + "/" + name, cnst.P_EXSHARE: "1", "method": http.MethodPost, "body": bytes.NewBufferString(body)}
+		_, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Guest, t, true)
+		return rs.StatusCode
+	}
+
+	//content is never shown to the guest
+	dat := map[string]interface{}{"u": root, cnst.P_EXSHARE: "1"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusForbidden {
+		t.Error("file drop must not be listed", rs.StatusCode)
+	}
+	dat["u"] = root + "/t.txt"
+	if _, rs, _ := cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusForbidden {
+		t.Error("file drop must not be downloaded", rs.StatusCode)
+	}
+
+	if code := upload("a.txt", "hi"); code != http.StatusOK {
+		t.Fatal("upload into drop", code)
+	}
+	if code := upload("a.txt", "hi"); code != http.StatusOK {
+		t.Fatal("upload with same name must be renamed", code)
+	}
+	home := filepath.Join(cfg.GetUserHomePath("user1"), cfg.SharePathUp)
+	for _, n := range []string{"a.txt", "a (1).txt"} {
+		if _, err := os.Stat(filepath.Join(home, n)); err != nil {
+			t.Error("uploaded file must be saved", err)
+		}
+	}
+	if code := upload("b.txt", "hi"); code != http.StatusInsufficientStorage {
+		t.Error("uploads over limit must fail", code)
+	}
+	if code := upload("sub/b.txt", "hi"); code != http.StatusBadRequest {
+		t.Error("upload into sub folder must fail", code)
+	}
+
+	//share update keeps uploads counter
+	setDrop(`{"maxSize":5,"onConflict":"` + config.DROP_REJECT + `","uploads":0}`)
+	usr1, _ = cfg.GetUserByUsername("user1")
+	if d := usr1.GetShares(cfg.SharePathUp, false)[0].Drop; d.Uploads != 2 {
+		t.Error("uploads must be counted by server only", d.Uploads)
+	}
+	if code := upload("big.txt", "too large"); code != http.StatusRequestEntityTooLarge {
+		t.Error("upload over size limit must fail", code)
+	}
+	if _, err := os.Stat(filepath.Join(home, "big.txt")); err == nil {
+		t.Error("too large upload must be removed")
+	}
+	if code := upload("a.txt", "hi"); code != http.StatusConflict {
+		t.Error("name collision must be rejected", code)
+	}
+
+	dat = map[string]interface{}{"u": cfg.SharePathUp, "share": "drop-log"}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	var l []*config.DropEntry
+	_ = json.NewDecoder(rs.Body).Decode(&l)
+	if len(l) != 2 || l[1].Name != "a (1).txt" || l[1].IP != "127.0.0.1" || l[1].Size != 2 {
+		t.Error("uploads must be logged with uploader ip", l)
+	}
+
+	//regular external share accepts no uploads
+	setDrop(`null`)
+	if code := upload("c.txt", "hi"); code != http.StatusNotFound {
+		t.Error("upload into regular share must fail", code)
+	}
+}
+
+func TestFileDropLongName(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	dat := map[string]interface{}{"u": "/", "method": http.MethodPost,
+		"body": bytes.NewBufferString(`{"path":"` + cfg.SharePathUp + `","allowExternal":true,"drop":{}}`)}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true); rs.StatusCode != http.StatusOK {
+		t.Fatal("drop share status", rs.StatusCode)
+	}
+	usr1, _ := cfg.GetUserByUsername("user1")
+	shr := usr1.GetShares(cfg.SharePathUp, false)[0]
+
+	//taken name of maximal length, so every renamed one fails
+	name := strings.Repeat("a", 251) + ".txt"
+	home := filepath.Join(cfg.GetUserHomePath("user1"), cfg.SharePathUp)
+	if err := ioutil.WriteFile(filepath.Join(home, name), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dat = map[string]interface{}{"u": "/" + shr.LinkName(shr.Links[0].Token) + "/" + name, cnst.P_EXSHARE: "1",
+		"method": http.MethodPost, "body": bytes.NewBufferString("hi")}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Guest, t, true); rs.StatusCode == http.StatusOK {
+		t.Error("upload must fail, in case no name can be created")
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(home, name)); string(b) != "x" {
+		t.Error("existing file must not be replaced")
+	}
+}
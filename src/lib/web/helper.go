@@ -9,14 +9,17 @@ import (
 	"net/http"
 )
 
+//shared webdav locks for all users
+var davLock = webdav.NewMemLS()
+
 func SetupHandler(cfg *config.GlobalConfig) http.Handler {
 	fb := &lib.FileBrowser{
-		Config:    cfg,
-		ReCaptcha: &lib.ReCaptcha{cfg.CaptchaConfig.Host, cfg.CaptchaConfig.Key, cfg.CaptchaConfig.Secret},
+		Config: cfg,
 		NewFS: func(scope string) lib.FileSystem {
 			return utils.Dir(scope)
 		},
 	}
+	fb.SetReCaptcha(&lib.ReCaptcha{Host: cfg.CaptchaConfig.Host, Key: cfg.CaptchaConfig.Key, Secret: cfg.CaptchaConfig.Secret})
 	DavHandler(fb)
 	needUpd, err := fb.Setup()
 	if err != nil {
@@ -25,16 +28,27 @@ func SetupHandler(cfg *config.GlobalConfig) http.Handler {
 	if needUpd {
 		cfg.WriteConfig()
 	}
+	//apply changes from config file, that was edited at runtime
+	config.OnReload = func(cfg *config.GlobalConfig) {
+		fb.SetReCaptcha(&lib.ReCaptcha{Host: cfg.CaptchaConfig.Host, Key: cfg.CaptchaConfig.Key, Secret: cfg.CaptchaConfig.Secret})
+		if fb.HashFirstRunPasswords() {
+			cfg.WriteConfig()
+		}
+		DavHandler(fb)
+	}
 
 	return Handler(fb)
 }
+
+//create webdav handler for users that does not have one yet
 func DavHandler(fb *lib.FileBrowser) {
-	ramLock := webdav.NewMemLS()
 	for _, u := range fb.Config.Users {
-		u.DavHandler = &webdav.Handler{
-			FileSystem: webdav.Dir(fb.Config.GetDavPath(u.Username)),
-			LockSystem: ramLock,
-			Logger:     config.DavLogger,
+		if u.DavHandler == nil {
+			u.DavHandler = &webdav.Handler{
+				FileSystem: &davRulesFS{newDavFS(fb.Config, u.Username), fb.Config, u.Username},
+				LockSystem: davLock,
+				Logger:     config.DavLogger,
+			}
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"bytes"
 	"encoding/json"
 	"github.com/browsefile/backend/src/cnst"
 	fb "github.com/browsefile/backend/src/lib"
@@ -36,7 +37,10 @@ func Handler(m *fb.FileBrowser) http.Handler {
 				err = nil
 			}
 		}
-		if !c.Rendered && c.Router > 0 && c.Router != cnst.R_DOWNLOAD && c.Router != cnst.R_PLAYLIST {
+		//downloads write own response, except failures before any content sent
+		isDownload := c.Router == cnst.R_DOWNLOAD || c.Router == cnst.R_PLAYLIST
+		//auth routes have no router, but still report failures
+		if !c.Rendered && (c.Router > 0 || code >= http.StatusBadRequest) && (!isDownload || err != nil && code >= http.StatusBadRequest) {
 			w.WriteHeader(code)
 		}
 
@@ -55,6 +59,9 @@ func serve(c *fb.Context) (int, error) {
 
 		return staticHandler(c)
 	}
+	if strings.HasPrefix(c.REQ.URL.Path, DL_URL) {
+		return directDownloadHandler(c)
+	}
 	if strings.HasPrefix(c.REQ.URL.Path, cnst.WEB_DAV_URL) {
 		ServeDav(c, c.RESP, c.REQ)
 		return http.StatusOK, nil
@@ -95,19 +102,39 @@ func apiHandler(c *fb.Context) (code int, err error) {
 	if c.REQ.URL.Path == "/auth/renew" {
 		return renewAuthHandler(c)
 	}
+
+	if c.REQ.URL.Path == "/auth/share" {
+		return shareAuthHandler(c)
+	}
+
+	if c.REQ.URL.Path == "/auth/totp" {
+		return totpAuthHandler(c)
+	}
+
+	if c.REQ.URL.Path == OIDC_URL {
+		return oidcHandler(c)
+	}
+
+	if c.REQ.URL.Path == OIDC_CALLBACK_URL {
+		return oidcCallbackHandler(c)
+	}
 	valid, _ := validateAuth(c)
 
 	if !valid {
 		return http.StatusForbidden, nil
 	}
 	isShares := ProcessParams(c)
-	//allow only GET requests, for external share
+	//allow only GET requests, for external share, and uploads into file drop
+	isDrop := c.Router == cnst.R_SHARES && c.IsExternal && c.Method == http.MethodPost
 	if valid && c.User.IsGuest() && (!isShares ||
-		c.Method != http.MethodGet ||
+		c.Method != http.MethodGet && !isDrop ||
 		c.Router == cnst.R_USERS ||
 		c.Router == cnst.R_SETTINGS) {
 		return http.StatusForbidden, nil
 	}
+	if !tokenAllows(c) {
+		return http.StatusForbidden, nil
+	}
 
 	if c.Checksum != "" {
 		err := c.File.Checksum(c.Checksum)
@@ -123,7 +150,7 @@ func apiHandler(c *fb.Context) (code int, err error) {
 
 	switch c.Router {
 	case cnst.R_DOWNLOAD:
-		code, err = downloadHandler(c)
+		code, err = auditShare(c, downloadHandler)
 	case cnst.R_RESOURCE:
 		code, err = resourceHandler(c)
 	case cnst.R_USERS:
@@ -135,20 +162,14 @@ func apiHandler(c *fb.Context) (code int, err error) {
 	case cnst.R_SEARCH:
 		code, err = searchHandler(c)
 	case cnst.R_PLAYLIST:
-		code, err = makePlaylist(c)
+		code, err = auditShare(c, makePlaylist)
 
 	default:
 		code = http.StatusNotFound
 	}
-	if (c.Router == cnst.R_SETTINGS ||
-		c.Router == cnst.R_USERS ||
-		c.Router == cnst.R_RESOURCE ||
-		c.Router == cnst.R_SHARES) &&
-		c.Method == http.MethodPatch ||
-		c.Method == http.MethodPut ||
-		c.Method == http.MethodPost ||
-		c.Method == http.MethodDelete {
-		if c.Config != nil && err != nil {
+	//users and shares are persisted by the users store, only global settings left
+	if c.Router == cnst.R_SETTINGS && c.Method == http.MethodPut {
+		if c.Config != nil && err == nil {
 			c.Config.WriteConfig()
 		}
 
@@ -168,6 +189,7 @@ func renderFile(c *fb.Context, file string) (int, error) {
 	c.IsExternal = len(c.Query.Get(cnst.P_EXSHARE)) > 0
 	c.RESP.Header().Set("Content-Type", contentType+"; charset=utf-8")
 	cfgM := c.GetAuthConfig()
+	reCaptchaConf := c.GetReCaptcha()
 
 	data := map[string]interface{}{
 		"Name":            "Browsefile",
@@ -177,9 +199,10 @@ func renderFile(c *fb.Context, file string) (int, error) {
 		"StaticURL":       "/static",
 		"Signup":          false,
 		"NoAuth":          strings.ToLower(cfgM.AuthMethod) == "noauth" || strings.ToLower(cfgM.AuthMethod) == "ip",
-		"ReCaptcha":       c.ReCaptcha.Key != "" && c.ReCaptcha.Secret != "",
-		"ReCaptchaHost":   c.ReCaptcha.Host,
-		"ReCaptchaKey":    c.ReCaptcha.Key,
+		"OIDC":            cfgM.AuthMethod == "oidc", //login page redirects to identity provider
+		"ReCaptcha":       reCaptchaConf.Key != "" && reCaptchaConf.Secret != "",
+		"ReCaptchaHost":   reCaptchaConf.Host,
+		"ReCaptchaKey":    reCaptchaConf.Key,
 	}
 
 	if c.IsExternal {
@@ -193,7 +216,19 @@ func renderFile(c *fb.Context, file string) (int, error) {
 
 	index := template.Must(template.New("index").Delims("[{[", "]}]").Parse(c.Assets.MustString(file)))
 
-	err = index.Execute(c.RESP, data)
+	//link previews of external share, crawlers do not run scripts
+	var meta *shareMeta
+	if c.IsExternal && file == "index.html" {
+		meta = makeShareMeta(c)
+	}
+	if meta == nil {
+		err = index.Execute(c.RESP, data)
+	} else {
+		var b bytes.Buffer
+		if err = index.Execute(&b, data); err == nil {
+			_, err = c.RESP.Write(injectMeta(b.Bytes(), meta.tags()))
+		}
+	}
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
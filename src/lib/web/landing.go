@@ -0,0 +1,212 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/utils"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//public route of external share links, that works without auth token
+const DL_URL = "/dl/"
+
+//external share of the landing page, with summary of its content
+type shareMeta struct {
+	Title       string
+	Description string
+	URL         string
+	Image       string
+}
+
+//external share at url of landing page(/shares/name_token/sub), with link token, owner and path at owner home
+func landingShare(c *fb.Context, url string) (shr *config.ShareItem, token, owner, p string) {
+	url = strings.TrimPrefix(url, "/shares")
+	_, token = c.Config.GetSharePreviewPath(url, true)
+	shr, _ = c.Config.GetExternal(token)
+	if shr == nil || !shr.IsAllowed(cnst.GUEST) {
+		return nil, "", "", ""
+	}
+	owner, p, err := c.Config.ResolveShare(url, cnst.GUEST, true)
+	if err != nil {
+		return nil, "", "", ""
+	}
+	return shr, token, owner, p
+}
+
+//summary of external share at request url, nil in case url is not inside share.
+//Content of password protected shares and file drops is not disclosed
+func makeShareMeta(c *fb.Context) *shareMeta {
+	shr, token, owner, p := landingShare(c, c.REQ.URL.Path)
+	if shr == nil {
+		return nil
+	}
+	m := &shareMeta{Title: filepath.Base(p), URL: c.Config.ExternalShareHost + c.REQ.URL.RequestURI()}
+	switch {
+	case shr.IsDrop():
+		m.Description = "File drop"
+		return m
+	case shr.IsProtected():
+		m.Description = "Password protected share"
+		return m
+	}
+	var size, files int64
+	err := walkShared(c, owner, p, func(_ string, info os.FileInfo) bool {
+		size += info.Size()
+		files++
+		return true
+	})
+	if err != nil {
+		return nil
+	}
+	m.Description = fmt.Sprintf("%d files, %s", files, humanSize(size))
+	//preview link serves share root
+	if len(sharePreview(c, owner, shr.Path)) > 0 {
+		m.Image = c.Config.ExternalShareHost + DL_URL + token + "/preview"
+	}
+	return m
+}
+
+//open graph and twitter card tags
+func (m *shareMeta) tags() string {
+	var b strings.Builder
+	tag := func(attr, name, val string) {
+		if len(val) > 0 {
+			fmt.Fprintf(&b, "<meta %s=\"%s\" content=\"%s\">", attr, name, template.HTMLEscapeString(val))
+		}
+	}
+	tag("property", "og:site_name", "Browsefile")
+	tag("property", "og:type", "website")
+	tag("property", "og:title", m.Title)
+	tag("property", "og:description", m.Description)
+	tag("property", "og:url", m.URL)
+	tag("property", "og:image", m.Image)
+	card := "summary"
+	if len(m.Image) > 0 {
+		card = "summary_large_image"
+	}
+	tag("name", "twitter:card", card)
+	tag("name", "twitter:title", m.Title)
+	tag("name", "twitter:description", m.Description)
+	tag("name", "twitter:image", m.Image)
+	return b.String()
+}
+
+//put tags into head of page, or at its start in case page has no head
+func injectMeta(page []byte, tags string) []byte {
+	i := bytes.Index(bytes.ToLower(page), []byte("<head"))
+	if i >= 0 {
+		if end := bytes.IndexByte(page[i:], '>'); end >= 0 {
+			i += end + 1
+			return append(page[:i:i], append([]byte(tags), page[i:]...)...)
+		}
+	}
+	return append([]byte(tags), page...)
+}
+
+//first cached preview of file or folder at p of the owner, previews are never generated here
+func sharePreview(c *fb.Context, owner, p string) (res string) {
+	home, prevHome := c.Config.GetUserHomePath(owner), c.Config.GetUserPreviewPath(owner)
+	_ = walkShared(c, owner, p, func(path string, _ os.FileInfo) bool {
+		if prev := utils.GenPreviewConvertPath(path, home, prevHome); utils.Exists(prev) {
+			res = prev
+		}
+		return len(res) == 0
+	})
+	return res
+}
+
+//call fn for each regular file at p of the owner or below it, until fn returns false.
+//Paths that owner path rules don't allow to read are skipped, like at listing of the share
+func walkShared(c *fb.Context, owner, p string, fn func(path string, info os.FileInfo) bool) error {
+	var rules config.PathRules
+	if u, ok := c.Config.GetUserByUsername(owner); ok {
+		rules = u.Rules
+	}
+	if err := rules.CheckRead(p); err != nil {
+		return err
+	}
+	home := c.Config.GetUserHomePath(owner)
+	root := filepath.Join(home, p)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		//unreadable folders inside share are skipped, like by DirSize
+		if err != nil && path == root {
+			return err
+		} else if err != nil {
+			return nil
+		}
+		if rules.CheckRead(strings.TrimPrefix(path, home)) != nil {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && !fn(path, info) {
+			return io.EOF
+		}
+		return nil
+	})
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+//direct download of single file share by link token(/dl/token), or its cached preview(/dl/token/preview)
+func directDownloadHandler(c *fb.Context) (int, error) {
+	if c.Method != http.MethodGet && c.Method != http.MethodHead {
+		return http.StatusMethodNotAllowed, nil
+	}
+	arr := strings.SplitN(strings.TrimPrefix(c.REQ.URL.Path, DL_URL), "/", 2)
+	shr, owner := c.Config.GetExternal(arr[0])
+	guest, ok := c.Config.GetUserByUsername(cnst.GUEST)
+	if shr == nil || !ok || !shr.IsAllowed(cnst.GUEST) {
+		return http.StatusNotFound, cnst.ErrNotExist
+	}
+	c.Query = c.REQ.URL.Query()
+	c.ShareAuth = c.Query.Get(cnst.P_SHARE_AUTH)
+	c.User = fb.ToUserModel(guest, c.Config)
+	c.IsExternal = true
+	c.Router = cnst.R_DOWNLOAD
+	c.URL = "/" + shr.LinkName(arr[0])
+	if len(arr) > 1 && arr[1] == "preview" {
+		shr, _, owner, p := landingShare(c, c.URL)
+		if shr == nil || shr.IsDrop() || shr.IsProtected() {
+			return http.StatusNotFound, cnst.ErrNotExist
+		}
+		prev := sharePreview(c, owner, p)
+		if len(prev) == 0 {
+			return http.StatusNotFound, cnst.ErrNotExist
+		}
+		c.RESP.Header().Set("Content-Type", utils.GetMimeType(prev))
+		return servePreview(c, prev)
+	} else if len(arr) > 1 {
+		return http.StatusNotFound, cnst.ErrNotExist
+	}
+	//folders are downloaded through share page
+	if info, err := os.Stat(filepath.Join(c.Config.GetUserHomePath(owner.Username), shr.Path)); err != nil || info.IsDir() {
+		return http.StatusNotFound, cnst.ErrNotExist
+	}
+	return auditShare(c, downloadHandler)
+}
+
+//human readable size, like 1.5 MB
+func humanSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	//expiration of successful binds by hash of credentials, so directory is not asked on every webdav request
+	ldapBinds    = make(map[string]time.Time)
+	ldapBindLock = new(sync.Mutex)
+)
+
+func ldapBindKey(username, password string) string {
+	h := sha256.Sum256([]byte(username + "\x00" + password))
+	return hex.EncodeToString(h[:])
+}
+
+//user of directory credentials, created on first login. Permissions follow directory groups
+func ldapUser(c *fb.Context, username, password string) (*config.UserConfig, bool) {
+	l := c.Config.LDAP
+	if l == nil {
+		return nil, false
+	}
+	key := ldapBindKey(username, password)
+	now := time.Now()
+	ldapBindLock.Lock()
+	exp, ok := ldapBinds[key]
+	if ok && now.After(exp) {
+		delete(ldapBinds, key)
+		ok = false
+	}
+	ldapBindLock.Unlock()
+	if ok {
+		return c.Config.GetUserByUsername(username)
+	}
+	id, err := fb.LDAPAuth(l, username, password)
+	if err != nil {
+		log.Println("Wrong LDAP password for user", username, err)
+		return nil, false
+	}
+	u, _, err := provisionUser(c, "ldap", &l.GroupMapping, true, username, id.Groups, true)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	if ttl := l.GetCacheTTL(); ttl > 0 {
+		ldapBindLock.Lock()
+		for k, e := range ldapBinds {
+			if now.After(e) {
+				delete(ldapBinds, k)
+			}
+		}
+		ldapBinds[key] = now.Add(ttl)
+		ldapBindLock.Unlock()
+	}
+	return u, true
+}
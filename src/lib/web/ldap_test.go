@@ -0,0 +1,191 @@
+package web
+
+import (
+	"bytes"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testEntry struct {
+	dn, password string
+	groups       []string
+}
+
+//stand-in directory, that answers simple binds and searches by uid
+type testDirectory struct {
+	net.Listener
+	lock    sync.Mutex
+	entries map[string]*testEntry
+	//count of binds as users, service account is not counted
+	binds int
+}
+
+func newTestDirectory(t *testing.T) *testDirectory {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDirectory{Listener: l, entries: map[string]*testEntry{
+		"carol": {"uid=carol,ou=people,dc=example,dc=org", "pw", []string{"cn=editors,ou=groups,dc=example,dc=org"}},
+		"dave":  {"uid=dave,ou=people,dc=example,dc=org", "pw", []string{"cn=admins,ou=groups,dc=example,dc=org"}},
+	}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func ldapResult(id int64, app ber.Tag, code int64) *ber.Packet {
+	p := ber.NewSequence("LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, app, nil, "Response")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	p.AppendChild(res)
+	return p
+}
+
+func (d *testDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id := p.Children[0].Value.(int64)
+		op := p.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, pw := op.Children[1].Value.(string), op.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			d.lock.Lock()
+			if dn == "cn=svc,dc=example,dc=org" && pw == "svc" {
+				code = ldap.LDAPResultSuccess
+			}
+			for _, e := range d.entries {
+				if e.dn == dn {
+					d.binds++
+					if e.password == pw {
+						code = ldap.LDAPResultSuccess
+					}
+				}
+			}
+			d.lock.Unlock()
+			_, _ = conn.Write(ldapResult(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			d.lock.Lock()
+			for uid, e := range d.entries {
+				if filter != "(uid="+uid+")" {
+					continue
+				}
+				rs := ber.NewSequence("LDAP Response")
+				rs.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+				ent := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+				ent.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "DN"))
+				attrs := ber.NewSequence("Attributes")
+				attr := ber.NewSequence("Attribute")
+				attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "memberOf", "Type"))
+				vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+				for _, g := range e.groups {
+					vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, g, "Value"))
+				}
+				attr.AppendChild(vals)
+				attrs.AppendChild(attr)
+				ent.AppendChild(attrs)
+				rs.AppendChild(ent)
+				_, _ = conn.Write(rs.Bytes())
+			}
+			d.lock.Unlock()
+			_, _ = conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func TestLDAPLogin(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	dir := newTestDirectory(t)
+	defer dir.Close()
+	cfg.Http.AuthMethod = "ldap"
+	defer func() { cfg.Http.AuthMethod = "default" }()
+	cfg.LDAP = &config.LDAPConfig{URL: "ldap://" + dir.Addr().String(), BaseDN: "dc=example,dc=org",
+		BindDN: "cn=svc,dc=example,dc=org", BindPassword: "svc",
+		GroupMapping: config.GroupMapping{AdminGroups: []string{"admins"}, EditGroups: []string{"editors"}}}
+
+	login := func(username, password string) (int, string) {
+		body := bytes.NewBufferString(`{"username":"` + username + `","password":"` + password + `"}`)
+		rs, err := http.Post(cfg.Srv.URL+"/api/auth/get", "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rs.Body)
+		return rs.StatusCode, string(b)
+	}
+	if code, token := login("carol", "pw"); code != http.StatusOK || strings.Count(token, ".") != 2 {
+		t.Fatal("directory user must log in", code)
+	}
+	u, ok := cfg.GetUserByUsername("carol")
+	if !ok || u.Admin || !u.AllowEdit {
+		t.Fatal("user must be created with edit permission", u)
+	}
+	if _, err := ioutil.ReadDir(cfg.GetUserHomePath("carol")); err != nil {
+		t.Fatal("home must be created", err)
+	}
+	if code, _ := login("dave", "pw"); code != http.StatusOK {
+		t.Fatal("directory user must log in", code)
+	}
+	if u, _ = cfg.GetUserByUsername("dave"); !u.Admin {
+		t.Error("admins group must grant admin")
+	}
+	for _, c := range [][2]string{{"carol", "wrong"}, {"carol", ""}, {"*", "pw"}, {"user1", "1"}} {
+		//each case must reach directory, not backoff of previous ones
+		clearLoginFailures()
+		if code, _ := login(c[0], c[1]); code != http.StatusForbidden {
+			t.Error("login must fail", c, code)
+		}
+	}
+
+	//webdav binds are cached
+	dav := func(username, password string) int {
+		req, _ := http.NewRequest("PROPFIND", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/", nil)
+		req.SetBasicAuth(username, password)
+		rs, err := cfg.Tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.StatusCode
+	}
+	dir.lock.Lock()
+	binds := dir.binds
+	dir.lock.Unlock()
+	if dav("carol", "pw") != http.StatusMultiStatus || dav("carol", "pw") != http.StatusMultiStatus {
+		t.Fatal("directory user must pass webdav auth")
+	}
+	dir.lock.Lock()
+	if dir.binds != binds {
+		t.Error("cached bind must not ask directory", dir.binds-binds)
+	}
+	dir.lock.Unlock()
+	if dav("carol", "wrong") != http.StatusUnauthorized {
+		t.Error("wrong password must fail")
+	}
+}
@@ -0,0 +1,226 @@
+package web
+
+import (
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//failed attempts without delay
+	LOCKOUT_FREE_ATTEMPTS = 3
+	//delay after first counted failure, doubled by each next one
+	LOCKOUT_BASE_DELAY = time.Second
+	//failed attempts, after which ip or username is locked for LOCKOUT_DURATION
+	LOCKOUT_THRESHOLD = 10
+	LOCKOUT_DURATION  = 15 * time.Minute
+	//failures are forgotten in case there were no new ones during this time
+	LOCKOUT_WINDOW = time.Hour
+)
+
+//failed logins of ip or username
+type loginFailures struct {
+	//like ip:127.0.0.1, user:admin or share:<hash>
+	Key      string    `json:"key"`
+	Failures int       `json:"failures"`
+	Last     time.Time `json:"last"`
+	//next attempt is rejected without password check until this time
+	Until  time.Time `json:"until"`
+	Locked bool      `json:"locked"`
+}
+
+var (
+	loginFails    = make(map[string]*loginFailures)
+	loginFailLock = new(sync.Mutex)
+)
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func userKey(username string) string {
+	return "user:" + username
+}
+
+func shareKey(hash string) string {
+	return "share:" + hash
+}
+
+//address of the remote client without port. Forwarded address is used only in case request came from trusted proxy,
+//otherwise any client could pick own address and bypass lockout
+func clientIP(cfg *config.GlobalConfig, r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !cfg.IsTrustedProxy(host) {
+		return host
+	}
+	//proxies append address of their peer, so first untrusted from the right is the client
+	if fwd := r.Header.Get("X-Forwarded-For"); len(fwd) > 0 {
+		arr := strings.Split(fwd, ",")
+		for i := len(arr) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(arr[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			if host = ip; !cfg.IsTrustedProxy(ip) {
+				break
+			}
+		}
+		return host
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return host
+}
+
+//delay of next attempt after n failures, and whether it is lockout
+func failDelay(n int) (time.Duration, bool) {
+	if n >= LOCKOUT_THRESHOLD {
+		return LOCKOUT_DURATION, true
+	}
+	if n < LOCKOUT_FREE_ATTEMPTS {
+		return 0, false
+	}
+	d := LOCKOUT_BASE_DELAY << uint(n-LOCKOUT_FREE_ATTEMPTS)
+	if d > LOCKOUT_DURATION {
+		d = LOCKOUT_DURATION
+	}
+	return d, false
+}
+
+//time left until any of keys may try again, zero in case attempt is allowed
+func loginBlocked(keys ...string) time.Duration {
+	now := time.Now()
+	var res time.Duration
+	loginFailLock.Lock()
+	defer loginFailLock.Unlock()
+	for _, k := range keys {
+		f, ok := loginFails[k]
+		if !ok {
+			continue
+		}
+		if now.Sub(f.Last) > LOCKOUT_WINDOW && now.After(f.Until) {
+			delete(loginFails, k)
+			continue
+		}
+		if left := f.Until.Sub(now); left > res {
+			res = left
+		}
+	}
+	return res
+}
+
+//count failed attempt for ip and username, logged as
+//"Failed login for user <name> from <ip> via <source>", so fail2ban can match it by
+//failregex = Failed login for (user|share) .* from <HOST> via
+func loginFailed(ip, username, source string) {
+	log.Printf("Failed login for user %s from %s via %s", strconv.Quote(username), ip, source)
+	if len(username) > 0 {
+		countFailure(ip, ipKey(ip), userKey(username))
+	} else {
+		countFailure(ip, ipKey(ip))
+	}
+}
+
+//count wrong password of external share, logged same way as failed login
+func shareUnlockFailed(ip, hash string) {
+	log.Printf("Failed login for share %s from %s via share", strconv.Quote(hash), ip)
+	countFailure(ip, ipKey(ip), shareKey(hash))
+}
+
+func countFailure(ip string, keys ...string) {
+	now := time.Now()
+	loginFailLock.Lock()
+	defer loginFailLock.Unlock()
+	for k, f := range loginFails {
+		if now.Sub(f.Last) > LOCKOUT_WINDOW && now.After(f.Until) {
+			delete(loginFails, k)
+		}
+	}
+	for _, k := range keys {
+		f, ok := loginFails[k]
+		if !ok {
+			f = &loginFailures{Key: k}
+			loginFails[k] = f
+		}
+		f.Failures++
+		f.Last = now
+		d, locked := failDelay(f.Failures)
+		f.Until = now.Add(d)
+		if locked && !f.Locked {
+			log.Printf("Locked out %s from %s for %s", k, ip, d)
+		}
+		f.Locked = locked
+	}
+}
+
+//forget failures of username or share after successful login, ip is kept, so own account can't reset guessing of others
+func loginSucceeded(key string) {
+	loginFailLock.Lock()
+	delete(loginFails, key)
+	loginFailLock.Unlock()
+}
+
+//respond too many requests in case any of keys is delayed, true in case request is rejected
+func rejectBlocked(w http.ResponseWriter, keys ...string) bool {
+	left := loginBlocked(keys...)
+	if left <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(left/time.Second)+1))
+	return true
+}
+
+func clearLoginFailures() {
+	loginFailLock.Lock()
+	loginFails = make(map[string]*loginFailures)
+	loginFailLock.Unlock()
+}
+
+//route /api/settings/lockouts[/key], admin lists delayed ips, usernames and shares, and clears them
+func settingsLockoutsHandler(c *fb.Context) (int, error) {
+	if !c.User.Admin {
+		return http.StatusForbidden, nil
+	}
+	key := strings.Trim(strings.TrimPrefix(c.URL, "/lockouts"), "/")
+	switch c.Method {
+	case http.MethodGet:
+		if len(key) > 0 {
+			return http.StatusMethodNotAllowed, nil
+		}
+		now := time.Now()
+		res := []loginFailures{}
+		loginFailLock.Lock()
+		for _, f := range loginFails {
+			if now.Sub(f.Last) <= LOCKOUT_WINDOW || now.Before(f.Until) {
+				res = append(res, *f)
+			}
+		}
+		loginFailLock.Unlock()
+		sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+		return renderJSON(c, res)
+	case http.MethodDelete:
+		if len(key) == 0 {
+			clearLoginFailures()
+			return http.StatusOK, nil
+		}
+		loginFailLock.Lock()
+		defer loginFailLock.Unlock()
+		if _, ok := loginFails[key]; !ok {
+			return http.StatusNotFound, nil
+		}
+		delete(loginFails, key)
+		return http.StatusOK, nil
+	}
+	return http.StatusMethodNotAllowed, nil
+}
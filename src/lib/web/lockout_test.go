@@ -0,0 +1,144 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFailDelay(t *testing.T) {
+	for n := 0; n < LOCKOUT_FREE_ATTEMPTS; n++ {
+		if d, _ := failDelay(n); d != 0 {
+			t.Fatal("first attempts must not be delayed", n, d)
+		}
+	}
+	if d, _ := failDelay(LOCKOUT_FREE_ATTEMPTS + 2); d != 4*LOCKOUT_BASE_DELAY {
+		t.Fatal("delay must be doubled", d)
+	}
+	if d, locked := failDelay(LOCKOUT_THRESHOLD); !locked || d != LOCKOUT_DURATION {
+		t.Fatal("wrong lockout", d, locked)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	login := func(username, password string) *http.Response {
+		body := bytes.NewBufferString(`{"username":"` + username + `","password":"` + password + `"}`)
+		rs, err := http.Post(cfg.Srv.URL+"/api/auth/get", "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+	for i := 0; i < LOCKOUT_FREE_ATTEMPTS; i++ {
+		if rs := login("user1", "wrong"); rs.StatusCode != http.StatusForbidden {
+			t.Fatal("wrong password must fail", rs.StatusCode)
+		}
+	}
+	//even right password is not checked during backoff
+	rs := login("user1", "1")
+	if rs.StatusCode != http.StatusTooManyRequests || len(rs.Header.Get("Retry-After")) == 0 {
+		t.Fatal("attempt must be delayed", rs.StatusCode)
+	}
+	dav := func(username, password string) int {
+		req, _ := http.NewRequest("PROPFIND", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/", nil)
+		req.SetBasicAuth(username, password)
+		rs, err := cfg.Tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.StatusCode
+	}
+	if c := dav("user2", "1"); c != http.StatusTooManyRequests {
+		t.Error("address must be delayed for webdav too", c)
+	}
+
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, map[string]interface{}{"u": "/lockouts"}, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Fatal("only admin can list lockouts", rs.StatusCode)
+	}
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, map[string]interface{}{"u": "/lockouts"}, cfg.GetAdmin(), t, false)
+	var list []loginFailures
+	_ = json.NewDecoder(rs.Body).Decode(&list)
+	if len(list) != 2 || list[0].Key != "ip:127.0.0.1" || list[1].Key != "user:user1" || list[1].Failures != LOCKOUT_FREE_ATTEMPTS {
+		t.Fatal("wrong lockouts", list)
+	}
+	dat := map[string]interface{}{"u": "/lockouts/ip:127.0.0.1", "method": http.MethodDelete}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.GetAdmin(), t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("admin can clear lockout", rs.StatusCode)
+	}
+	if c := dav("user2", "1"); c != http.StatusMultiStatus {
+		t.Error("cleared address must pass", c)
+	}
+	if rs = login("user1", "1"); rs.StatusCode != http.StatusTooManyRequests {
+		t.Error("username must stay delayed", rs.StatusCode)
+	}
+	dat["u"] = "/lockouts"
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.GetAdmin(), t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("admin can clear all lockouts", rs.StatusCode)
+	}
+	if rs = login("user1", "1"); rs.StatusCode != http.StatusOK {
+		t.Fatal("cleared user must log in", rs.StatusCode)
+	}
+
+	//behind trusted proxy each client is counted by own address
+	cfg.TrustedProxies = []string{"127.0.0.1"}
+	defer func() { cfg.TrustedProxies = nil }()
+	proxied := func(ip string) int {
+		req, _ := http.NewRequest(http.MethodPost, cfg.Srv.URL+"/api/auth/get", bytes.NewBufferString(`{"username":"user2","password":"wrong"}`))
+		req.Header.Set("X-Forwarded-For", ip)
+		rs, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.StatusCode
+	}
+	for i := 0; i < LOCKOUT_FREE_ATTEMPTS; i++ {
+		proxied("10.0.0.3")
+	}
+	if loginBlocked(ipKey("10.0.0.3")) == 0 || loginBlocked(ipKey("127.0.0.1")) > 0 {
+		t.Error("forwarded address of trusted proxy must be delayed, not proxy itself")
+	}
+	clearLoginFailures()
+
+	//reaching threshold locks address out
+	for i := 0; i < LOCKOUT_THRESHOLD; i++ {
+		loginFailed("10.0.0.1", "", "web")
+	}
+	if left := loginBlocked(ipKey("10.0.0.1")); left < LOCKOUT_DURATION-time.Minute {
+		t.Error("address must be locked out", left)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cfg := &config.GlobalConfig{}
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:4000"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	r.Header.Set("X-Real-IP", "3.3.3.3")
+	if ip := clientIP(cfg, r); ip != "10.0.0.2" {
+		t.Fatal("forwarded address must be ignored without trusted proxy", ip)
+	}
+	cfg.TrustedProxies = []string{"10.0.0.0/24", "2.2.2.2"}
+	if ip := clientIP(cfg, r); ip != "1.1.1.1" {
+		t.Error("first untrusted address from the right expected", ip)
+	}
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 4.4.4.4")
+	if ip := clientIP(cfg, r); ip != "4.4.4.4" {
+		t.Error("address set by client must not be trusted", ip)
+	}
+	r.Header.Del("X-Forwarded-For")
+	if ip := clientIP(cfg, r); ip != "3.3.3.3" {
+		t.Error("real ip of trusted proxy expected", ip)
+	}
+	r.RemoteAddr = "10.0.1.2:4000"
+	if ip := clientIP(cfg, r); ip != "10.0.1.2" {
+		t.Error("address outside trusted network must be used", ip)
+	}
+}
@@ -0,0 +1,131 @@
+package web
+
+import (
+	"errors"
+	fb "github.com/browsefile/backend/src/lib"
+	"net/http"
+	"time"
+)
+
+//routes of login through identity provider
+const (
+	OIDC_URL          = "/auth/oidc"
+	OIDC_CALLBACK_URL = "/auth/oidc/callback"
+	//cookies of login attempt, and of its result
+	OIDC_STATE_COOKIE = "bf_oidc_state"
+	OIDC_LOGIN_COOKIE = "bf_oidc_login"
+)
+
+//redirect to provider login page, state of attempt is kept at cookie
+func oidcHandler(c *fb.Context) (int, error) {
+	o := c.Config.OIDC
+	if c.GetAuthConfig().AuthMethod != "oidc" || o == nil {
+		return http.StatusNotFound, nil
+	}
+	p, err := fb.GetOIDCProvider(o.Issuer)
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	state, nonce, verifier := fb.RandomString(), fb.RandomString(), fb.RandomString()
+	signed, err := fb.GenOIDCState(c.Config, state, nonce, verifier)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	setAuthCookie(c, OIDC_STATE_COOKIE, signed, fb.OIDC_STATE_TTL)
+	http.Redirect(c.RESP, c.REQ, p.AuthCodeURL(o, oidcRedirectURL(c), state, nonce, verifier), http.StatusFound)
+	return 0, nil
+}
+
+//provider redirects back with code, that is exchanged for id token. User is logged in at index page by login cookie
+func oidcCallbackHandler(c *fb.Context) (int, error) {
+	o := c.Config.OIDC
+	if c.GetAuthConfig().AuthMethod != "oidc" || o == nil {
+		return http.StatusNotFound, nil
+	}
+	q := c.REQ.URL.Query()
+	ck, err := c.REQ.Cookie(OIDC_STATE_COOKIE)
+	if err != nil {
+		return http.StatusForbidden, errors.New("oidc : login attempt expired")
+	}
+	setAuthCookie(c, OIDC_STATE_COOKIE, "", -1)
+	st, ok := fb.CheckOIDCState(c.Config, ck.Value)
+	if !ok || st.State != q.Get("state") {
+		return http.StatusForbidden, errors.New("oidc : wrong state")
+	}
+	if e := q.Get("error"); len(e) > 0 {
+		return http.StatusForbidden, errors.New("oidc : " + e + " " + q.Get("error_description"))
+	}
+	p, err := fb.GetOIDCProvider(o.Issuer)
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	idToken, err := p.Exchange(o, q.Get("code"), st.Verifier, oidcRedirectURL(c))
+	if err != nil {
+		return http.StatusForbidden, err
+	}
+	claims, err := p.Verify(o, idToken, st.Nonce)
+	if err != nil {
+		return http.StatusForbidden, err
+	}
+	username, groups := fb.OIDCIdentity(o, claims)
+	_, hasGroups := claims[o.GetGroupsClaim()]
+	u, code, err := provisionUser(c, "oidc", &o.GroupMapping, o.AutoCreate, username, groups, hasGroups)
+	if err != nil {
+		return code, err
+	}
+	signed, err := fb.GenOIDCLogin(c.Config, u.Username)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	setAuthCookie(c, OIDC_LOGIN_COOKIE, signed, fb.OIDC_LOGIN_TTL)
+	http.Redirect(c.RESP, c.REQ, "/", http.StatusFound)
+	return 0, nil
+}
+
+//exchange login cookie for session token
+func oidcLogin(c *fb.Context) (int, error) {
+	ck, err := c.REQ.Cookie(OIDC_LOGIN_COOKIE)
+	if err != nil {
+		return http.StatusForbidden, nil
+	}
+	setAuthCookie(c, OIDC_LOGIN_COOKIE, "", -1)
+	uc, ok := fb.CheckOIDCLogin(c.Config, ck.Value)
+	if !ok {
+		return http.StatusForbidden, nil
+	}
+	//second factor enrolled here is required as well, mfa of identity provider is not known
+	if uc.HasTOTP() {
+		return secondFactor(c, uc, "")
+	}
+	c.User = fb.ToUserModel(uc, c.Config)
+	return printToken(c)
+}
+
+//callback url registered at provider
+func oidcRedirectURL(c *fb.Context) string {
+	if len(c.Config.OIDC.RedirectURL) > 0 {
+		return c.Config.OIDC.RedirectURL
+	}
+	scheme := "http"
+	if c.REQ.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.REQ.Host + "/api" + OIDC_CALLBACK_URL
+}
+
+//http only cookie of auth routes, negative ttl removes it
+func setAuthCookie(c *fb.Context, name, value string, ttl time.Duration) {
+	ck := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/api/auth",
+		HttpOnly: true,
+		Secure:   c.REQ.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(ttl / time.Second),
+	}
+	if ttl < 0 {
+		ck.MaxAge = -1
+	}
+	http.SetCookie(c.RESP, ck)
+}
@@ -0,0 +1,211 @@
+package web
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/browsefile/backend/src/config"
+	"github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//stand-in identity provider, that logs in user of next authorize request
+type testProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	lock   sync.Mutex
+	claims jwt.MapClaims
+	//pending authorization codes with their challenge and nonce
+	codes map[string][2]string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProvider{key: key, codes: make(map[string][2]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != "bf" || q.Get("code_challenge_method") != "S256" || len(q.Get("code_challenge")) == 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		p.lock.Lock()
+		code := q.Get("state") + "-code"
+		p.codes[code] = [2]string{q.Get("code_challenge"), q.Get("nonce")}
+		p.lock.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+q.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		p.lock.Lock()
+		c, ok := p.codes[r.FormValue("code")]
+		delete(p.codes, r.FormValue("code"))
+		claims := jwt.MapClaims{}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		p.lock.Unlock()
+		h := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || id != "bf" || secret != "s3cret" || base64.RawURLEncoding.EncodeToString(h[:]) != c[0] {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims["iss"], claims["aud"], claims["nonce"] = p.URL, "bf", c[1]
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		tk := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		tk.Header["kid"] = "k1"
+		signed, _ := tk.SignedString(key)
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func TestOIDCLogin(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	prov := newTestProvider(t)
+	defer prov.Close()
+	cfg.Http.AuthMethod = "oidc"
+	defer func() { cfg.Http.AuthMethod = "default" }()
+	cfg.OIDC = &config.OIDCConfig{Issuer: prov.URL, ClientID: "bf", ClientSecret: "s3cret", AutoCreate: true,
+		GroupMapping: config.GroupMapping{AdminGroups: []string{"bf-admins"}, EditGroups: []string{"bf-editors"}}}
+
+	tr := &http.Transport{}
+	get := func(u string, cks []*http.Cookie) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, u, nil)
+		for _, ck := range cks {
+			req.AddCookie(ck)
+		}
+		rs, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+	//full redirect chain, returns session token
+	login := func(claims jwt.MapClaims) (*http.Response, string) {
+		prov.lock.Lock()
+		prov.claims = claims
+		prov.lock.Unlock()
+		rs := get(cfg.Srv.URL+"/api"+OIDC_URL, nil)
+		if rs.StatusCode != http.StatusFound || !strings.HasPrefix(rs.Header.Get("Location"), prov.URL+"/authorize") {
+			t.Fatal("redirect to provider expected", rs.StatusCode)
+		}
+		state := rs.Cookies()
+		rs = get(rs.Header.Get("Location"), nil)
+		if rs.StatusCode != http.StatusFound {
+			t.Fatal("provider must accept request", rs.StatusCode)
+		}
+		rs = get(rs.Header.Get("Location"), state)
+		if rs.StatusCode != http.StatusFound {
+			return rs, ""
+		}
+		req, _ := http.NewRequest(http.MethodPost, cfg.Srv.URL+"/api/auth/get", nil)
+		for _, ck := range rs.Cookies() {
+			req.AddCookie(ck)
+		}
+		rs, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rs.Body)
+		return rs, string(b)
+	}
+
+	//password login is disabled
+	rs, err := http.Post(cfg.Srv.URL+"/api/auth/get", "application/json", strings.NewReader(`{"username":"user1","password":"1"}`))
+	if err != nil || rs.StatusCode != http.StatusForbidden {
+		t.Fatal("password login must be disabled", err)
+	}
+
+	rs, token := login(jwt.MapClaims{"preferred_username": "carol", "groups": []string{"bf-editors"}})
+	if rs.StatusCode != http.StatusOK || strings.Count(token, ".") != 2 {
+		t.Fatal("login must pass", rs.StatusCode)
+	}
+	u, ok := cfg.GetUserByUsername("carol")
+	if !ok || u.Admin || !u.AllowEdit || !u.AllowNew {
+		t.Fatal("user must be created with edit permission", u)
+	}
+	if _, err = ioutil.ReadDir(cfg.GetUserHomePath("carol")); err != nil {
+		t.Fatal("home must be created", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, cfg.Srv.URL+"/api/resource/", nil)
+	req.Header.Set("X-Auth", token)
+	if rs, _ = tr.RoundTrip(req); rs.StatusCode != http.StatusOK {
+		t.Error("session must be accepted", rs.StatusCode)
+	}
+
+	//groups are synced on next login
+	if rs, _ = login(jwt.MapClaims{"preferred_username": "carol", "groups": []string{"bf-admins"}}); rs.StatusCode != http.StatusOK {
+		t.Fatal("login must pass", rs.StatusCode)
+	}
+	if u, _ = cfg.GetUserByUsername("carol"); !u.Admin {
+		t.Error("admin group must grant admin")
+	}
+
+	//custom claim and disabled provisioning
+	cfg.OIDC.UsernameClaim = "email"
+	cfg.OIDC.AutoCreate = false
+	if rs, _ = login(jwt.MapClaims{"email": "dave"}); rs.StatusCode != http.StatusForbidden {
+		t.Error("unknown user must not be created", rs.StatusCode)
+	}
+	if rs, _ = login(jwt.MapClaims{"email": "user1"}); rs.StatusCode != http.StatusOK {
+		t.Error("existing user must log in", rs.StatusCode)
+	}
+
+	//second factor is required after provider login
+	secret, _, _ := cfg.EnrollTOTP("user1")
+	recovery, err := cfg.ConfirmTOTP("user1", totpNow(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, token = login(jwt.MapClaims{"email": "user1"})
+	var step map[string]string
+	if err = json.Unmarshal([]byte(token), &step); err != nil || rs.StatusCode != http.StatusOK || step["secondFactor"] != "totp" {
+		t.Fatal("second step expected", rs.StatusCode, token)
+	}
+	code, _ := json.Marshal(map[string]string{"token": step["token"], "code": recovery[0]})
+	if rs, err = http.Post(cfg.Srv.URL+"/api/auth/totp", "application/json", bytes.NewReader(code)); err != nil || rs.StatusCode != http.StatusOK {
+		t.Error("code must finish login", err)
+	}
+
+	//state is bound to cookie
+	rs = get(cfg.Srv.URL+"/api"+OIDC_URL, nil)
+	loc, _ := url.Parse(rs.Header.Get("Location"))
+	rs = get(cfg.Srv.URL+"/api"+OIDC_CALLBACK_URL+"?code=x&state="+loc.Query().Get("state"), nil)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Error("callback without state cookie must fail", rs.StatusCode)
+	}
+}
@@ -31,6 +31,10 @@ func ProcessParams(c *fb.Context) (isShares bool) {
 	if len(c.Auth) == 0 {
 		c.Auth = c.REQ.Header.Get(cnst.H_XAUTH)
 	}
+	c.ShareAuth = c.Query.Get(cnst.P_SHARE_AUTH)
+	if len(c.ShareAuth) == 0 {
+		c.ShareAuth = c.REQ.Header.Get(cnst.H_SHARE_AUTH)
+	}
 	//search request
 	q := c.Query.Get("query")
 	if len(q) > 0 {
@@ -67,6 +67,9 @@ func serveFileAsUrl(c *lib.Context, fName, p, host string) {
 	if len(c.Auth) > 0 {
 		io.WriteString(c.RESP, "&auth="+c.Auth)
 	}
+	if len(c.ShareAuth) > 0 {
+		io.WriteString(c.RESP, "&"+cnst.P_SHARE_AUTH+"="+c.ShareAuth)
+	}
 
 	io.WriteString(c.RESP, "\r\n")
 
@@ -46,7 +46,7 @@ func TestPlaylistOnExternalShareDir(t *testing.T) {
 	cfg.InitServ(t)
 	defer cfg.Clean(t)
 	shr := cfg.Usr1.GetShares(cfg.SharePathUp, false)[0]
-	l := shr.ResolveSymlinkName()
+	l := shr.LinkName(shr.Links[0].Token)
 	p := "/" + l
 
 	testPlaylistOnDir(&cfg, t, true, map[string]interface{}{cnst.P_EXSHARE: "1", "u": p, "files": []string{p}}, 9)
@@ -56,7 +56,7 @@ func TestPlaylistOnExternalShareDirParent(t *testing.T) {
 	cfg.InitServ(t)
 	defer cfg.Clean(t)
 	shr := cfg.Usr1.GetShares(cfg.SharePathUp, false)[0]
-	l := shr.ResolveSymlinkName()
+	l := shr.LinkName(shr.Links[0].Token)
 	p := "/" + l
 
 	testPlaylistOnDir(&cfg, t, true, map[string]interface{}{cnst.P_EXSHARE: shr.Hash, "u": p, "files": []string{p}}, 9)
@@ -0,0 +1,110 @@
+package web
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+//request body that fails once user quota is reached
+type quotaReader struct {
+	io.Reader
+	exceeded bool
+	//usage of the owner is reserved for every read chunk
+	cfg      *config.GlobalConfig
+	username string
+	//size of replaced file, that is written without reservation
+	replaced int64
+	finished bool
+}
+
+func (q *quotaReader) Read(p []byte) (n int, err error) {
+	n, err = q.Reader.Read(p)
+	if n > 0 {
+		need := int64(n) - q.replaced
+		if need <= 0 {
+			q.replaced -= int64(n)
+		} else {
+			q.replaced = 0
+			if q.cfg.ReserveQuota(q.username, need, 0) != nil {
+				q.exceeded = true
+				return 0, cnst.ErrQuotaExceeded
+			}
+		}
+	}
+	return n, err
+}
+
+//count finished upload, space of replaced file that was not overwritten is released
+func (q *quotaReader) commit() {
+	if q.replaced > 0 {
+		q.cfg.AddUsage(q.username, -q.replaced, 0)
+		q.replaced = 0
+	}
+}
+
+//end write of the upload, successful or not. Must be called for every reader of newUploadReader
+func (q *quotaReader) finish() {
+	if !q.finished {
+		q.finished = true
+		q.cfg.EndWrite(q.username)
+	}
+}
+
+//check that file at home relative path p can be written with size bytes(negative if unknown),
+//returns reader that reserves usage of the user while body is read. New file is reserved at once
+func checkUploadQuota(c *fb.Context, p string, body io.Reader, size int64) (*quotaReader, error) {
+	var old, isNew int64 = 0, 1
+	if inf, err := c.User.FileSystem.Stat(p); err == nil && !inf.IsDir() {
+		old, isNew = inf.Size(), 0
+	}
+	return newUploadReader(c, body, size, old, isNew)
+}
+
+//reader of upload with size bytes(negative if unknown), that replaces file of old size or creates new one
+func newUploadReader(c *fb.Context, body io.Reader, size, old, isNew int64) (*quotaReader, error) {
+	//fail early in case known size does not fit
+	if size >= 0 {
+		if err := c.Config.CheckQuota(c.User.Username, size-old, 0); err != nil {
+			return nil, err
+		}
+	}
+	c.Config.BeginWrite(c.User.Username)
+	if err := c.Config.ReserveQuota(c.User.Username, 0, isNew); err != nil {
+		c.Config.EndWrite(c.User.Username)
+		return nil, err
+	}
+	return &quotaReader{Reader: body, cfg: c.Config, username: c.User.Username, replaced: old}, nil
+}
+
+//webdav response, that reports 507 in case upload was cut by quota
+type quotaResponseWriter struct {
+	http.ResponseWriter
+	qr *quotaReader
+}
+
+func (w *quotaResponseWriter) WriteHeader(code int) {
+	if w.qr.exceeded {
+		code = http.StatusInsufficientStorage
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *quotaResponseWriter) Write(b []byte) (int, error) {
+	if w.qr.exceeded {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+//drop partially written file, usage will be counted again
+func removeExceeded(c *fb.Context, p string) {
+	if err := c.User.FileSystem.RemoveAll(p); err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+	c.Config.ResetUsage(c.User.Username)
+}
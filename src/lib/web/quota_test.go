@@ -0,0 +1,165 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQuotaUpload(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	base := cfg.GetUsage("user1")
+	usr1, _ := cfg.GetUserByUsername("user1")
+	usr1.Quota = &config.Quota{Bytes: base.Bytes + 15}
+	_ = cfg.Update(usr1)
+
+	dat := map[string]interface{}{"u": "/q1.txt", "method": http.MethodPut, "body": bytes.NewBufferString("0123456789")}
+	_, rs, _ := cfg.MakeRequest(cnst.R_RESOURCE, dat, usr1, t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("upload within quota must pass, got", rs.StatusCode)
+	}
+	dat["u"] = "/q2.txt"
+	dat["body"] = bytes.NewBufferString(strings.Repeat("x", 20))
+	_, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, usr1, t, false)
+	if rs.StatusCode != http.StatusInsufficientStorage {
+		t.Error("upload over quota must be rejected, got", rs.StatusCode)
+	}
+	if _, err := cfg.User1FS.Stat("/q2.txt"); err == nil {
+		t.Error("rejected upload must not be stored")
+	}
+
+	dat = map[string]interface{}{"u": "/user1"}
+	_, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, usr1, t, false)
+	var info struct {
+		Usage *config.Usage `json:"usage"`
+	}
+	if err := json.NewDecoder(rs.Body).Decode(&info); err != nil || info.Usage == nil {
+		t.Fatal("usage must be reported", err)
+	}
+	if info.Usage.Bytes != base.Bytes+10 || info.Usage.QuotaBytes != base.Bytes+15 {
+		t.Error("wrong usage", info.Usage)
+	}
+
+	//copy does not fit
+	dat = map[string]interface{}{"u": "/q1.txt", "method": http.MethodPatch, "destination": "/q3.txt", "action": "copy"}
+	_, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, usr1, t, false)
+	if rs.StatusCode != http.StatusInsufficientStorage {
+		t.Error("copy over quota must be rejected, got", rs.StatusCode)
+	}
+
+	dat = map[string]interface{}{"u": "/q1.txt", "method": http.MethodDelete}
+	_, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, usr1, t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("delete status", rs.StatusCode)
+	}
+	if u := cfg.GetUsage("user1"); u.Bytes != base.Bytes || u.Files != base.Files {
+		t.Error("delete must release quota", u, base)
+	}
+}
+
+func TestSizeLimitReader(t *testing.T) {
+	lr := &sizeLimitReader{Reader: strings.NewReader("0123456789"), max: 5}
+	b, err := ioutil.ReadAll(lr)
+	if err != cnst.ErrTooLarge || !lr.exceeded || len(b) != 5 {
+		t.Error("reader must stop at limit", len(b), err)
+	}
+	lr = &sizeLimitReader{Reader: strings.NewReader("01234"), max: 5}
+	if b, err = ioutil.ReadAll(lr); err != nil || lr.exceeded || len(b) != 5 {
+		t.Error("exact size must fit", len(b), err)
+	}
+	lr = &sizeLimitReader{Reader: strings.NewReader("0123456789")}
+	if b, _ = ioutil.ReadAll(lr); len(b) != 10 {
+		t.Error("not limited reader must read all")
+	}
+}
+
+func TestQuotaReserve(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	base := cfg.GetUsage("user1")
+	usr1, _ := cfg.GetUserByUsername("user1")
+	usr1.Quota = &config.Quota{Bytes: base.Bytes + 15}
+	_ = cfg.Update(usr1)
+
+	//uploads running at once, each fits alone, but not together
+	r1 := &quotaReader{Reader: strings.NewReader("0123456789"), cfg: cfg.GlobalConfig, username: "user1"}
+	r2 := &quotaReader{Reader: strings.NewReader("0123456789"), cfg: cfg.GlobalConfig, username: "user1"}
+	if b, err := ioutil.ReadAll(r1); err != nil || len(b) != 10 {
+		t.Fatal("first upload must fit", err)
+	}
+	if _, err := ioutil.ReadAll(r2); err != cnst.ErrQuotaExceeded || !r2.exceeded {
+		t.Error("second upload must not fit, while first is reserved", err)
+	}
+	//replaced file is written without reservation, rest of it is released on commit
+	r3 := &quotaReader{Reader: strings.NewReader("01234"), cfg: cfg.GlobalConfig, username: "user1", replaced: 8}
+	if _, err := ioutil.ReadAll(r3); err != nil {
+		t.Fatal(err)
+	}
+	r3.commit()
+	if u := cfg.GetUsage("user1"); u.Bytes != base.Bytes+10-3 {
+		t.Error("wrong usage", u.Bytes-base.Bytes)
+	}
+}
+
+func TestQuotaKeptOnUpdate(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	usr1, _ := cfg.GetUserByUsername("user1")
+	usr1.Quota = &config.Quota{Bytes: 100}
+	_ = cfg.Update(usr1)
+
+	put := func(data string) int {
+		body := bytes.NewBufferString(`{"what":"user","which":"all","data":` + data + `}`)
+		dat := map[string]interface{}{"u": "/user1", "method": http.MethodPut, "body": body}
+		_, rs, _ := cfg.MakeRequest(cnst.R_USERS, dat, cfg.GetAdmin(), t, false)
+		return rs.StatusCode
+	}
+	if c := put(`{"username":"user1","allowNew":true}`); c != http.StatusOK {
+		t.Fatal("update must pass", c)
+	}
+	if u, _ := cfg.GetUserByUsername("user1"); u.Quota == nil || u.Quota.Bytes != 100 {
+		t.Error("omitted quota must be kept", u.Quota)
+	}
+	if c := put(`{"username":"user1","quota":null}`); c != http.StatusOK {
+		t.Fatal("update must pass", c)
+	}
+	if u, _ := cfg.GetUserByUsername("user1"); u.Quota != nil {
+		t.Error("admin can clear quota", u.Quota)
+	}
+}
+
+func TestQuotaResetPending(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	cfg.ResetUsage("user1")
+	base := cfg.GetUsage("user1")
+
+	//upload reserved size, but did not write it yet, recount now would lose reservation
+	cfg.BeginWrite("user1")
+	if err := cfg.ReserveQuota("user1", 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	cfg.ResetUsage("user1")
+	if u := cfg.GetUsage("user1"); u.Bytes != base.Bytes+10 || u.Files != base.Files+1 {
+		t.Error("reservation must be kept while write is pending", u, base)
+	}
+	p := filepath.Join(cfg.GetUserHomePath("user1"), "pending.txt")
+	if err := ioutil.WriteFile(p, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.EndWrite("user1")
+	if u := cfg.GetUsage("user1"); u.Bytes != base.Bytes+10 || u.Files != base.Files+1 {
+		t.Error("usage must be counted again once write finished", u, base)
+	}
+}
@@ -71,6 +71,9 @@ func resourceHandler(c *fb.Context) (int, error) {
 }
 
 func resourceGetHandler(c *fb.Context) (int, error) {
+	if err := c.CheckRead(c.URL); err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
 	// GetUsers the information of the directory/file.
 	f, err := c.MakeInfo()
 	if err != nil {
@@ -103,7 +106,7 @@ func resourceGetHandler(c *fb.Context) (int, error) {
 	// Serve a preview if the file can't be edited or the
 	// user has no permission to edit this file. Otherwise,
 	// just serve the editor.
-	if !f.CanBeEdited() || !c.User.AllowEdit {
+	if !f.CanBeEdited() || !c.User.AllowEdit || c.User.Rules.CheckWrite(c.URL) != nil {
 		f.Kind = "preview"
 		return renderJSON(c, f)
 	}
@@ -136,17 +139,22 @@ func listingHandler(c *fb.Context) (int, error) {
 
 func resourceDeleteHandler(c *fb.Context) (int, error) {
 	// Prevent the removal of the root directory.
-	if c.URL == "/" || !c.User.AllowEdit {
+	if c.URL == "/" || !canModify(c, c.User.AllowEdit, true, c.URL) {
 		return http.StatusForbidden, nil
 	}
+	if err := c.User.Rules.CheckWrite(c.URL); err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
 	removePreview(c)
 
+	size, files := utils.DirSize(filepath.Join(c.GetUserHomePath(), c.URL))
 	// Remove the file or folder.
 	err := c.User.FileSystem.RemoveAll(c.URL)
 
 	if err != nil {
 		return cnst.ErrorToHTTP(err, true), err
 	}
+	c.Config.AddUsage(c.User.Username, -size, -files)
 	//delete share
 	for _, itm := range findShare(c.User.UserConfig, c.URL) {
 
@@ -210,13 +218,16 @@ func modPreview(c *fb.Context, src, dst string, isCopy bool) {
 }
 
 func resourcePostPutHandler(c *fb.Context) (int, error) {
-	if !c.User.AllowNew && c.Method == http.MethodPost {
+	if !canModify(c, c.User.AllowNew, false, c.URL) && c.Method == http.MethodPost {
 		return http.StatusForbidden, nil
 	}
 
-	if !c.User.AllowEdit && c.Method == http.MethodPut {
+	if !canModify(c, c.User.AllowEdit, false, c.URL) && c.Method == http.MethodPut {
 		return http.StatusForbidden, nil
 	}
+	if err := checkPutRules(c, c.URL); err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
 
 	// Discard any invalid upload before returning to avoid connection
 	// reset error.
@@ -255,18 +266,31 @@ func resourcePostPutHandler(c *fb.Context) (int, error) {
 			return http.StatusConflict, errors.New("There is already a file on that path")
 		}
 	}
+	body, err := checkUploadQuota(c, c.URL, c.REQ.Body, c.REQ.ContentLength)
+	if err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
+	defer body.finish()
 	// Create/Open the file.
 	f, err := c.User.FileSystem.OpenFile(c.URL, os.O_RDWR|os.O_CREATE|os.O_TRUNC, cnst.PERM_DEFAULT, c.User.UID, c.User.GID)
 	if err != nil {
+		c.Config.ResetUsage(c.User.Username)
 		return cnst.ErrorToHTTP(err, false), err
 	}
 	defer f.Close()
 
 	// Copies the new content for the file.
-	_, err = io.Copy(f, c.REQ.Body)
+	_, err = io.Copy(f, body)
+	if body.exceeded {
+		_ = f.Close()
+		removeExceeded(c, c.URL)
+		return http.StatusInsufficientStorage, cnst.ErrQuotaExceeded
+	}
 	if err != nil {
+		c.Config.ResetUsage(c.User.Username)
 		return cnst.ErrorToHTTP(err, false), err
 	}
+	body.commit()
 
 	// GetUsers the info about the file.
 	fi, err := f.Stat()
@@ -293,7 +317,7 @@ func resourcePostPutHandler(c *fb.Context) (int, error) {
 
 // resourcePatchHandler is the entry point for resource handler.
 func resourcePatchHandler(c *fb.Context) (int, error) {
-	if !c.User.AllowEdit {
+	if !canModify(c, c.User.AllowEdit, c.Action != "copy", c.URL) {
 		return http.StatusForbidden, nil
 	}
 	dst, err := url.QueryUnescape(c.Destination)
@@ -307,21 +331,35 @@ func resourcePatchHandler(c *fb.Context) (int, error) {
 		return http.StatusForbidden, nil
 	}
 
+	if err = checkPatchRules(c, src, dst, action == "copy"); err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
+
 	if action == "copy" {
+		size, files := utils.DirSize(filepath.Join(c.GetUserHomePath(), utils.SlashClean(src)))
+		//reserved before copy, so concurrent writes can't exceed quota together
+		c.Config.BeginWrite(c.User.Username)
+		defer c.Config.EndWrite(c.User.Username)
+		if err = c.Config.ReserveQuota(c.User.Username, size, files); err != nil {
+			return cnst.ErrorToHTTP(err, false), err
+		}
+		_, dstErr := c.User.FileSystem.Stat(dst)
 		modPreview(c, src, dst, true)
 		// Copy the file.
 		err = c.User.FileSystem.Copy(src, dst, c.User.UID, c.User.GID)
+		if err != nil || !os.IsNotExist(dstErr) {
+			//destination replaced or copied partially, so count again
+			c.Config.ResetUsage(c.User.Username)
+		}
 
 	} else {
 		modPreview(c, src, dst, false)
 		// Rename the file.
 		err = c.User.FileSystem.Rename(src, dst)
 		if err == nil {
-			//check if share exists
-			for _, itm := range findShare(c.User.UserConfig, c.URL) {
-				if c.User.DeleteShare(itm.Path) {
-					_ = c.Config.Update(c.User.UserConfig)
-				}
+			//shares follow moved files, so recipients keep access
+			if mErr := c.Config.MoveShares(c.User.Username, src, dst); mErr != nil {
+				log.Println(mErr)
 			}
 		}
 
@@ -330,6 +368,40 @@ func resourcePatchHandler(c *fb.Context) (int, error) {
 	return cnst.ErrorToHTTP(err, true), err
 }
 
+//true in case file at p can be modified, allowed - user permission for own files.
+//At writable share of other user write permission is needed, or full one to delete and move files
+func canModify(c *fb.Context, allowed, full bool, p string) bool {
+	if len(c.SharePerm) == 0 {
+		return allowed
+	}
+	if full {
+		//share root is managed by owner only
+		return c.SharePerm == config.SHARE_FULL && strings.TrimSuffix(p, "/") != c.ShareRoot
+	}
+	return c.SharePerm == config.SHARE_WRITE || c.SharePerm == config.SHARE_FULL
+}
+
+//path rules for create or replace file at p, existing file is modified
+func checkPutRules(c *fb.Context, p string) error {
+	if _, err := c.User.FileSystem.Stat(p); err == nil {
+		return c.User.Rules.CheckWrite(p)
+	}
+	return c.User.Rules.CheckCreate(p)
+}
+
+//path rules for copy or move src to dst
+func checkPatchRules(c *fb.Context, src, dst string, isCopy bool) (err error) {
+	if isCopy {
+		err = c.User.Rules.CheckReadAll(src)
+	} else {
+		err = c.User.Rules.CheckWrite(src)
+	}
+	if err != nil {
+		return err
+	}
+	return checkPutRules(c, dst)
+}
+
 // HandleSortOrder gets and stores for a Listing the 'sort' and 'order',
 // and reads 'limit' if given. The latter is 0 if not given. Sets cookies.
 func HandleSortOrder(c *fb.Context, scope string) (err error) {
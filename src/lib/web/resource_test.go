@@ -109,3 +109,64 @@ func TestResourceUpload(t *testing.T) {
 	}
 
 }
+
+func TestResourceMoveShare(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	up := cfg.Usr1.GetShares(cfg.SharePathUp, false)[0]
+	exURL := "/" + up.LinkName(up.Links[0].Token)
+	dat := map[string]interface{}{"u": cfg.SharePathUp, "method": http.MethodPatch, "destination": "/moved"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("wrong status ", rs.StatusCode)
+	}
+	usr1, _ := cfg.GetUserByUsername("user1")
+	if len(usr1.GetShares("/moved", false)) != 1 || len(usr1.GetShares(cfg.SharePathUp, true)) != 0 {
+		t.Fatal("shares must follow moved folder")
+	}
+	deep := usr1.GetShares("/moved/share", false)
+	if len(deep) != 1 || !deep[0].IsAllowed("admin") {
+		t.Fatal("recipients must be kept")
+	}
+	dat = map[string]interface{}{"u": "/user1/" + deep[0].ResolveSymlinkName()}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.GetAdmin(), t, true); rs.StatusCode != http.StatusOK {
+		t.Error("moved share must be available for recipient", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": exURL + "/t.txt", cnst.P_EXSHARE: "1"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusOK {
+		t.Error("old external link must keep working", rs.StatusCode)
+	}
+
+	//webdav
+	req, _ := http.NewRequest("MOVE", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/moved", nil)
+	req.Header.Set("Destination", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/dav")
+	req.SetBasicAuth("user1", "1")
+	rs, err := cfg.Tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.StatusCode != http.StatusCreated {
+		t.Fatal("wrong webdav status ", rs.StatusCode)
+	}
+	usr1, _ = cfg.GetUserByUsername("user1")
+	if len(usr1.GetShares("/dav", false)) != 1 || len(usr1.GetShares("/dav/share", false)) != 1 {
+		t.Error("shares must follow folder moved by webdav")
+	}
+
+	//deleted folder drops its shares, so recreated one is not exposed by old links
+	req, _ = http.NewRequest("DELETE", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/dav", nil)
+	req.SetBasicAuth("user1", "1")
+	if rs, err = cfg.Tr.RoundTrip(req); err != nil || rs.StatusCode != http.StatusNoContent {
+		t.Fatal("wrong webdav delete status", err)
+	}
+	usr1, _ = cfg.GetUserByUsername("user1")
+	if len(usr1.GetShares("/dav", true)) != 0 {
+		t.Error("shares of deleted folder must be dropped")
+	}
+	_ = cfg.User1FS.Mkdir("/dav", cnst.PERM_DEFAULT, 0, 0)
+	dat = map[string]interface{}{"u": exURL, cnst.P_EXSHARE: "1"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Guest, t, true); rs.StatusCode == http.StatusOK {
+		t.Error("old external link must not expose recreated folder")
+	}
+}
@@ -22,7 +22,7 @@ func TestSearchImageSharesExternal(t *testing.T) {
 	dat := map[string]interface{}{"query": "type:i "}
 	//search in share up
 	shr := cfg.Usr1.GetShares(cfg.SharePathUp, false)[0]
-	p := shr.ResolveSymlinkName()
+	p := shr.LinkName(shr.Links[0].Token)
 	dat[cnst.P_EXSHARE] = shr.Hash
 	dat["u"] = "/" + p
 	_, rs, _ := cfg.MakeRequest(cnst.R_SEARCH, dat, cfg.Guest, t, true)
@@ -74,7 +74,7 @@ func TestSearchImageShareExternal(t *testing.T) {
 
 	//search in share up
 	shr = cfg.Usr1.GetShares(cfg.SharePathUp, false)[0]
-	dat["u"] = "/" + shr.ResolveSymlinkName()
+	dat["u"] = "/" + shr.LinkName(shr.Links[0].Token)
 	dat[cnst.P_EXSHARE] = "1"
 	_, rs, _ = cfg.MakeRequest(cnst.R_SEARCH, dat, cfg.Guest, t, true)
 
@@ -6,9 +6,22 @@ import (
 	"github.com/browsefile/backend/src/config"
 	"github.com/browsefile/backend/src/lib"
 	"net/http"
+	"strings"
 )
 
 func settingsHandler(c *lib.Context) (int, error) {
+	if strings.HasPrefix(c.URL, "/backups") {
+		return settingsBackupsHandler(c)
+	}
+	if c.URL == "/effective" {
+		return settingsEffectiveHandler(c)
+	}
+	if strings.HasPrefix(c.URL, "/groups") {
+		return settingsGroupsHandler(c)
+	}
+	if strings.HasPrefix(c.URL, "/lockouts") {
+		return settingsLockoutsHandler(c)
+	}
 	if c.URL != "" && c.URL != "/" {
 		return http.StatusNotFound, nil
 	}
@@ -30,6 +43,20 @@ func settingsGetHandler(c *lib.Context) (int, error) {
 	return renderJSON(c, c.Config.CopyConfig())
 }
 
+//merged config with origin of each overridable value: default, file, env or flag
+func settingsEffectiveHandler(c *lib.Context) (int, error) {
+	if !c.User.Admin {
+		return http.StatusForbidden, nil
+	}
+	if c.Method != http.MethodGet {
+		return http.StatusMethodNotAllowed, nil
+	}
+	return renderJSON(c, map[string]interface{}{
+		"config": c.Config.CopyConfig(),
+		"values": c.Config.EffectiveValues(),
+	})
+}
+
 func settingsPutHandler(c *lib.Context) (int, error) {
 	if !c.User.Admin {
 		return http.StatusForbidden, nil
@@ -52,3 +79,88 @@ func settingsPutHandler(c *lib.Context) (int, error) {
 
 	return http.StatusOK, nil
 }
+
+//config file backups, GET /backups list, GET /backups/<id> diff to the current config, POST /backups/<id> rollback
+func settingsBackupsHandler(c *lib.Context) (int, error) {
+	if !c.User.Admin {
+		return http.StatusForbidden, nil
+	}
+	id := strings.Trim(strings.TrimPrefix(c.URL, "/backups"), "/")
+	if len(id) == 0 {
+		if c.Method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil
+		}
+		return renderJSON(c, c.Config.ListBackups())
+	}
+
+	switch c.Method {
+	case http.MethodGet:
+		diff, err := c.Config.DiffBackup(id)
+		if err != nil {
+			return settingsErrToHTTP(err), err
+		}
+		return renderJSON(c, map[string]interface{}{"id": id, "diff": diff})
+	case http.MethodPost:
+		if err := c.Config.Rollback(id); err != nil {
+			return settingsErrToHTTP(err), err
+		}
+		return http.StatusOK, nil
+	}
+
+	return http.StatusMethodNotAllowed, nil
+}
+
+//user groups, GET /groups list, PUT /groups/<name> create or replace members, DELETE /groups/<name>
+func settingsGroupsHandler(c *lib.Context) (int, error) {
+	if !c.User.Admin {
+		return http.StatusForbidden, nil
+	}
+	name := strings.Trim(strings.TrimPrefix(c.URL, "/groups"), "/")
+	if len(name) == 0 {
+		if c.Method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil
+		}
+		return renderJSON(c, c.Config.GetGroups())
+	}
+
+	switch c.Method {
+	case http.MethodPut:
+		g := &config.Group{}
+		if c.REQ.Body == nil {
+			return http.StatusBadRequest, cnst.ErrEmptyRequest
+		}
+		if err := json.NewDecoder(c.REQ.Body).Decode(g); err != nil {
+			return http.StatusBadRequest, err
+		}
+		g.Name = name
+		for _, u := range g.Users {
+			if _, ok := c.Config.GetUserByUsername(u); !ok {
+				return http.StatusBadRequest, cnst.ErrNotExist
+			}
+		}
+		if err := c.Config.SetGroup(g); err != nil {
+			return settingsErrToHTTP(err), err
+		}
+		return http.StatusOK, nil
+	case http.MethodDelete:
+		if err := c.Config.DeleteGroup(name); err != nil {
+			return settingsErrToHTTP(err), err
+		}
+		return http.StatusOK, nil
+	}
+
+	return http.StatusMethodNotAllowed, nil
+}
+
+func settingsErrToHTTP(err error) int {
+	switch err {
+	case cnst.ErrNotExist:
+		return http.StatusNotFound
+	case cnst.ErrInvalidOption:
+		return http.StatusBadRequest
+	}
+	if _, ok := err.(config.ValidationError); ok {
+		return http.StatusBadRequest
+	}
+	return cnst.ErrorToHTTP(err, false)
+}
@@ -1 +1,115 @@
 package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"net/http"
+	"testing"
+)
+
+func TestSettingsBackups(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	cfg.Http.Port = 1234
+	cfg.WriteConfig()
+
+	dat := map[string]interface{}{"u": "/backups"}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Error("backups allowed only for admin")
+	}
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.GetAdmin(), t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("backups list status", rs.StatusCode)
+	}
+	var bkps []*config.ConfigBackup
+	if err := json.NewDecoder(rs.Body).Decode(&bkps); err != nil {
+		t.Fatal(err)
+	}
+	if len(bkps) == 0 {
+		t.Fatal("backups must be listed")
+	}
+
+	dat["u"] = "/backups/" + bkps[0].ID
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Error("backup diff status", rs.StatusCode)
+	}
+	dat["method"] = http.MethodPost
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Error("rollback status", rs.StatusCode)
+	}
+	if cfg.Http.Port == 1234 {
+		t.Error("rollback must restore previous port")
+	}
+	dat["u"] = "/backups/42"
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false)
+	if rs.StatusCode != http.StatusNotFound {
+		t.Error("missing backup must be 404, got", rs.StatusCode)
+	}
+}
+
+func TestSettingsEffective(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	dat := map[string]interface{}{"u": "/effective"}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Error("effective config allowed only for admin")
+	}
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.GetAdmin(), t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("effective config status", rs.StatusCode)
+	}
+	var res struct {
+		Values []*config.ConfigValue `json:"values"`
+	}
+	if err := json.NewDecoder(rs.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Values) == 0 || len(res.Values[0].Source) == 0 {
+		t.Error("values with source must be returned")
+	}
+}
+
+func TestSettingsGroups(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	dat := map[string]interface{}{"u": "/groups/team", "method": http.MethodPut, "body": bytes.NewBufferString(`{"users": ["user2"]}`)}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Error("groups allowed only for admin")
+	}
+	dat["body"] = bytes.NewBufferString(`{"users": ["user2"]}`)
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.GetAdmin(), t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("group set status", rs.StatusCode)
+	}
+	dat["body"] = bytes.NewBufferString(`{"users": ["nobody"]}`)
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false)
+	if rs.StatusCode != http.StatusBadRequest {
+		t.Error("unknown member must be rejected, got", rs.StatusCode)
+	}
+
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, map[string]interface{}{"u": "/groups"}, nil, t, false)
+	var groups []*config.Group
+	if err := json.NewDecoder(rs.Body).Decode(&groups); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Name != "team" || len(groups[0].Users) != 1 {
+		t.Error("wrong groups", groups)
+	}
+
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, map[string]interface{}{"u": "/groups/team", "method": http.MethodDelete}, nil, t, false)
+	if rs.StatusCode != http.StatusOK || len(cfg.GetGroups()) != 0 {
+		t.Error("group must be deleted", rs.StatusCode)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/browsefile/backend/src/lib"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 func shareHandler(c *lib.Context) (int, error) {
@@ -14,6 +16,18 @@ func shareHandler(c *lib.Context) (int, error) {
 	if c.User == nil && c.Method != "GET" {
 		return http.StatusNotFound, nil
 	}
+	if c.IsExternal && c.Method == http.MethodPost {
+		return dropUploadHandler(c)
+	}
+	//file operations inside local share of other user, otherwise share metadata of the user
+	if !c.IsExternal && len(c.ShareType) == 0 && c.Method != http.MethodGet {
+		if owner, root, p, perm, err := c.Config.ShareAccess(c.URL, c.User.Username); err != cnst.ErrNotExist {
+			if err != nil {
+				return cnst.ErrorToHTTP(err, false), err
+			}
+			return shareWriteHandler(c, owner, root, p, perm)
+		}
+	}
 	switch c.Method {
 	case http.MethodGet:
 		c.FitFilter = func(name, p string) bool {
@@ -29,11 +43,38 @@ func shareHandler(c *lib.Context) (int, error) {
 	return http.StatusNotImplemented, nil
 }
 
+//write into local share of other user, served by resource handlers at owner home with permission of the user
+func shareWriteHandler(c *lib.Context, owner *config.UserConfig, root, p, perm string) (int, error) {
+	if perm == config.SHARE_READ {
+		return http.StatusForbidden, nil
+	}
+	if c.Method == http.MethodPatch {
+		dst, err := url.QueryUnescape(c.Destination)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		//copy and move only inside same share
+		dOwner, dRoot, dp, _, err := c.Config.ShareAccess(sanitizeURL(dst), c.User.Username)
+		if err != nil || dOwner.Username != owner.Username || dRoot != root {
+			return http.StatusForbidden, cnst.ErrShareAccess
+		}
+		c.Destination = url.QueryEscape(dp)
+	}
+	if strings.HasSuffix(c.URL, "/") && p != "/" {
+		p += "/"
+	}
+	c.User = lib.ToUserModel(owner, c.Config)
+	c.URL = p
+	c.IsShare = false
+	c.SharePerm, c.ShareRoot = perm, root
+	return resourceHandler(c)
+}
+
 func shareGetHandler(c *lib.Context) (int, error) {
 	switch c.ShareType {
 	case "my-meta":
 		if "/" == c.URL {
-			return renderJSON(c, c.User.Shares)
+			return renderJSON(c, maskShares(c.User.Shares))
 		} else {
 			shrs := c.User.GetShares(c.URL, false)
 			var shr *config.ShareItem
@@ -43,14 +84,38 @@ func shareGetHandler(c *lib.Context) (int, error) {
 			} else {
 				shr = shrs[0]
 			}
-			return renderJSON(c, shr)
+			return renderJSON(c, maskShares([]*config.ShareItem{shr})[0])
 		}
 
+	case "with-me":
+		return sharedWithHandler(c)
+
+	case "by-me":
+		return sharedByHandler(c)
+
+	case "drop-log":
+		l, err := c.Config.DropLog(c.User.Username, c.URL)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return renderJSON(c, l)
+
+	case "log":
+		l, err := c.Config.AccessLog(c.User.Username, c.URL)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return renderJSON(c, l)
+
 	default:
-		return resourceGetHandler(c)
+		return auditShare(c, resourceGetHandler)
 	}
 }
 func sharePostHandler(c *lib.Context) (res int, err error) {
+	switch c.ShareType {
+	case "link", "rotate", "revoke":
+		return shareLinkHandler(c)
+	}
 	itm := &config.ShareItem{}
 	if c.ShareType != "gen-ex" {
 		err := json.NewDecoder(c.REQ.Body).Decode(itm)
@@ -58,23 +123,58 @@ func sharePostHandler(c *lib.Context) (res int, err error) {
 			return http.StatusBadRequest, err
 		}
 	}
+	//hidden and upload only paths never shared
+	p := itm.Path
+	if c.ShareType == "gen-ex" {
+		p = c.URL
+	}
+	if err = c.User.Rules.CheckRead(p); err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
 	needUpd := false
 	switch c.ShareType {
 	case "gen-ex":
+		//first link of the share, others are managed by link types
 		shrs := c.User.GetShares(c.URL, false)
-		var shr *config.ShareItem
-		if len(shrs) == 0 {
-			shr = &config.ShareItem{}
-			shr.Path = c.URL
-		} else {
-			shr = shrs[0]
+		if len(shrs) == 0 || len(shrs[0].Links) == 0 {
+			return http.StatusNotFound, cnst.ErrNotExist
 		}
-
-		l := c.Config.ExternalShareHost + "/shares/" + shr.ResolveSymlinkName() + "?" + cnst.P_EXSHARE + "=1"
-		return renderJSON(c, l)
+		return renderJSON(c, c.Config.ShareLinkURL(shrs[0], shrs[0].Links[0].Token))
 
 	default:
+		if err = itm.Validate(); err != nil {
+			return http.StatusBadRequest, err
+		}
+		//share source is resolved at request time, so it must exist
+		if _, err = c.User.FileSystem.Stat(itm.Path); err != nil {
+			return cnst.ErrorToHTTP(err, false), err
+		}
+		//downloads are counted by server only
+		itm.Downloads = 0
+		//links are generated by server only, existing ones stay valid
+		itm.Links = nil
 		shrs := c.User.GetShares(itm.Path, false)
+		if len(shrs) > 0 {
+			itm.Downloads = shrs[0].Downloads
+			itm.Links = shrs[0].Links
+		}
+		if itm.IsDrop() {
+			itm.Drop.Uploads = 0
+			if len(shrs) > 0 && shrs[0].IsDrop() {
+				itm.Drop.Uploads = shrs[0].Drop.Uploads
+			}
+		}
+		//masked password means it stays unchanged
+		if itm.Password == config.SECRET_MASK {
+			itm.Password = ""
+			if len(shrs) > 0 {
+				itm.Password = shrs[0].Password
+			}
+		} else if len(itm.Password) > 0 {
+			if itm.Password, err = lib.HashPassword(itm.Password); err != nil {
+				return http.StatusInternalServerError, err
+			}
+		}
 		if shrs != nil && !c.User.DeleteShare(itm.Path) {
 			return http.StatusBadRequest, cnst.ErrExist
 		}
@@ -89,7 +189,48 @@ func sharePostHandler(c *lib.Context) (res int, err error) {
 			return http.StatusBadRequest, err
 		}
 	}
-	return renderJSON(c, itm)
+	return renderJSON(c, maskShares([]*config.ShareItem{itm})[0])
+}
+
+//external link with its full url
+type shareLinkInfo struct {
+	*config.ShareLink
+	URL string `json:"url"`
+}
+
+//add, rotate or revoke single external link of the share at c.URL, link is selected by token param
+func shareLinkHandler(c *lib.Context) (int, error) {
+	var shr *config.ShareItem
+	var l *config.ShareLink
+	var err error
+	switch c.ShareType {
+	case "link":
+		shr, l, err = c.Config.AddShareLink(c.User.Username, c.URL, c.Query.Get("label"))
+	case "rotate":
+		shr, l, err = c.Config.RotateShareLink(c.User.Username, c.URL, c.Query.Get("token"))
+	default:
+		err = c.Config.RevokeShareLink(c.User.Username, c.URL, c.Query.Get("token"))
+	}
+	if err == cnst.ErrInvalidOption {
+		return http.StatusBadRequest, err
+	} else if err != nil {
+		return cnst.ErrorToHTTP(err, false), err
+	}
+	if l == nil {
+		return http.StatusOK, nil
+	}
+	return renderJSON(c, &shareLinkInfo{l, c.Config.ShareLinkURL(shr, l.Token)})
+}
+
+//copies of shares without password hashes
+func maskShares(shrs []*config.ShareItem) []*config.ShareItem {
+	res := make([]*config.ShareItem, len(shrs))
+	for i, shr := range shrs {
+		cp := *shr
+		cp.MaskPassword()
+		res[i] = &cp
+	}
+	return res
 }
 
 func shareDeleteHandler(c *lib.Context) (int, error) {
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"github.com/browsefile/backend/src/cnst"
 	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/utils"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -64,7 +66,7 @@ func TestShareGenEx(t *testing.T) {
 	cfg.InitServ(t)
 	defer cfg.Clean(t)
 
-	dat := map[string]interface{}{"u": cfg.SharePathDeep, "share": "gen-ex", "method": http.MethodPost}
+	dat := map[string]interface{}{"u": cfg.SharePathUp, "share": "gen-ex", "method": http.MethodPost}
 	_, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
 	if rs.StatusCode != http.StatusOK {
 		t.Error("wrong listing status at link :", rs.Request.URL.String())
@@ -73,9 +75,14 @@ func TestShareGenEx(t *testing.T) {
 	b, _ := ioutil.ReadAll(rs.Body)
 	link := string(b)
 	link, _ = url.QueryUnescape(link)
-	if !strings.Contains(link, cfg.Usr1.GetShares(cfg.SharePathDeep, false)[0].Hash) {
+	if !strings.Contains(link, cfg.Usr1.GetShares(cfg.SharePathUp, false)[0].Links[0].Token) {
 		t.Error("share path must be same")
 	}
+	//share without external links has no link
+	dat["u"] = cfg.SharePathDeep
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true); rs.StatusCode != http.StatusNotFound {
+		t.Error("not external share must not have link", rs.StatusCode)
+	}
 
 }
 func TestShareCreate(t *testing.T) {
@@ -114,3 +121,573 @@ func TestShareDelete(t *testing.T) {
 		t.Error("wrong listing status at link :", rs.Request.URL.String())
 	}
 }
+
+func TestShareDownloadLimit(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	dat := map[string]interface{}{"u": "/", "method": http.MethodPost,
+		"body": bytes.NewBufferString(`{"path":"` + cfg.SharePathUp + `","allowExternal":true,"maxDownloads":1,"downloads":5}`)}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("share status", rs.StatusCode)
+	}
+	usr1, _ := cfg.GetUserByUsername("user1")
+	shr := usr1.GetShares(cfg.SharePathUp, false)[0]
+	if shr.MaxDownloads != 1 || shr.Downloads != 0 {
+		t.Fatal("downloads must be counted by server only", shr.Downloads)
+	}
+
+	p := "/" + shr.LinkName(shr.Links[0].Token) + "/t.txt"
+	dat = map[string]interface{}{"u": p, cnst.P_EXSHARE: "1"}
+	//slot taken by download in progress
+	if err := cfg.ReserveShareDownload(p, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusGone {
+		t.Error("concurrent download over limit must fail", rs.StatusCode)
+	}
+	_ = cfg.FinishShareDownload(p, true, false)
+	dat["method"] = http.MethodHead
+	if _, rs, _ = cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusOK {
+		t.Error("head must pass", rs.StatusCode)
+	}
+	delete(dat, "method")
+	if _, rs, _ = cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusOK {
+		t.Error("first download must pass", rs.StatusCode)
+	}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusGone {
+		t.Error("download over limit must fail", rs.StatusCode)
+	}
+
+	dat = map[string]interface{}{"u": "/", "method": http.MethodPost,
+		"body": bytes.NewBufferString(`{"path":"` + cfg.SharePathUp + `","expiresAt":"2000-01-01T00:00:00Z","notBefore":"2001-01-01T00:00:00Z"}`)}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true); rs.StatusCode != http.StatusBadRequest {
+		t.Error("share must expire after start", rs.StatusCode)
+	}
+}
+
+func TestSharePassword(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	dat := map[string]interface{}{"u": "/", "method": http.MethodPost,
+		"body": bytes.NewBufferString(`{"path":"` + cfg.SharePathUp + `","allowExternal":true,"password":"secret"}`)}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("share status", rs.StatusCode)
+	}
+	usr1, _ := cfg.GetUserByUsername("user1")
+	shr := usr1.GetShares(cfg.SharePathUp, false)[0]
+	if !lib.CheckPasswordHash("secret", shr.Password) {
+		t.Fatal("share password must be stored as hash")
+	}
+	dat = map[string]interface{}{"u": cfg.SharePathUp, "share": "my-meta"}
+	_, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	var meta config.ShareItem
+	_ = json.NewDecoder(rs.Body).Decode(&meta)
+	if meta.Password != config.SECRET_MASK {
+		t.Error("password hash must not be sent to the client", meta.Password)
+	}
+
+	shrURL := "/" + shr.LinkName(shr.Links[0].Token)
+	dat = map[string]interface{}{"u": shrURL, cnst.P_EXSHARE: "1"}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusUnauthorized {
+		t.Error("locked share must not be listed", rs.StatusCode)
+	}
+	unlock := func(pwd string) *http.Response {
+		body := bytes.NewBufferString(`{"share":"` + shrURL + `","password":"` + pwd + `"}`)
+		rs, err := http.Post(cfg.Srv.URL+"/api/auth/share", "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+	if rs = unlock("wrong"); rs.StatusCode != http.StatusForbidden {
+		t.Error("wrong password must not unlock share", rs.StatusCode)
+	}
+	rs = unlock("secret")
+	token, _ := ioutil.ReadAll(rs.Body)
+	if rs.StatusCode != http.StatusOK || len(token) == 0 {
+		t.Fatal("share must be unlocked", rs.StatusCode)
+	}
+	dat[cnst.P_SHARE_AUTH] = string(token)
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusOK {
+		t.Error("unlocked share must be listed", rs.StatusCode)
+	}
+	dat["u"] = shrURL + "/t.txt"
+	if _, rs, _ = cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusOK {
+		t.Error("unlocked share must be downloaded", rs.StatusCode)
+	}
+
+	//password change revokes issued tokens, masked password keeps it
+	dat = map[string]interface{}{"u": "/", "method": http.MethodPost,
+		"body": bytes.NewBufferString(`{"path":"` + cfg.SharePathUp + `","allowExternal":true,"password":"` + config.SECRET_MASK + `"}`)}
+	_, _, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	dat = map[string]interface{}{"u": shrURL, cnst.P_EXSHARE: "1", cnst.P_SHARE_AUTH: string(token)}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusOK {
+		t.Error("masked password must keep share password", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": "/", "method": http.MethodPost,
+		"body": bytes.NewBufferString(`{"path":"` + cfg.SharePathUp + `","allowExternal":true,"password":"other"}`)}
+	_, _, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	dat = map[string]interface{}{"u": shrURL, cnst.P_EXSHARE: "1", cnst.P_SHARE_AUTH: string(token)}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusUnauthorized {
+		t.Error("password change must revoke token", rs.StatusCode)
+	}
+
+	//guessing is delayed by the share, even from other addresses
+	clearLoginFailures()
+	for i := 0; i < LOCKOUT_FREE_ATTEMPTS; i++ {
+		shareUnlockFailed("10.0.0.1", shr.Hash)
+	}
+	if rs = unlock("other"); rs.StatusCode != http.StatusTooManyRequests || len(rs.Header.Get("Retry-After")) == 0 {
+		t.Error("share unlock must be delayed", rs.StatusCode)
+	}
+}
+
+func TestShareLinksAPI(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	dat := map[string]interface{}{"u": cfg.SharePathUp, "share": "link", "label": "friends", "method": http.MethodPost}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	var l struct {
+		Token string `json:"token"`
+		Label string `json:"label"`
+		URL   string `json:"url"`
+	}
+	_ = json.NewDecoder(rs.Body).Decode(&l)
+	if rs.StatusCode != http.StatusOK || l.Label != "friends" || !strings.Contains(l.URL, l.Token) {
+		t.Fatal("link must be added", rs.StatusCode)
+	}
+	ls := map[string]interface{}{"u": "/" + cfg.Usr1.GetShares(cfg.SharePathUp, false)[0].LinkName(l.Token), cnst.P_EXSHARE: "1"}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, ls, cfg.Guest, t, true); rs.StatusCode != http.StatusOK {
+		t.Error("new link must be listed", rs.StatusCode)
+	}
+
+	//share update keeps links, client can't set tokens
+	dat = map[string]interface{}{"u": "/", "method": http.MethodPost,
+		"body": bytes.NewBufferString(`{"path":"` + cfg.SharePathUp + `","allowExternal":true,"links":[{"token":"abc"}]}`)}
+	_, _, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	usr1, _ := cfg.GetUserByUsername("user1")
+	if shr := usr1.GetShares(cfg.SharePathUp, false)[0]; len(shr.Links) != 2 || shr.Links[1].Token != l.Token {
+		t.Error("links must be kept on share update", shr.Links)
+	}
+
+	dat = map[string]interface{}{"u": cfg.SharePathUp, "share": "revoke", "token": l.Token, "method": http.MethodPost}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true); rs.StatusCode != http.StatusOK {
+		t.Error("link must be revoked", rs.StatusCode)
+	}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, ls, cfg.Guest, t, true); rs.StatusCode != http.StatusNotFound {
+		t.Error("revoked link must not be listed", rs.StatusCode)
+	}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true); rs.StatusCode != http.StatusNotFound {
+		t.Error("unknown link can't be revoked", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": cfg.SharePathDeep, "share": "link", "method": http.MethodPost}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true); rs.StatusCode != http.StatusBadRequest {
+		t.Error("not external share can't get links", rs.StatusCode)
+	}
+}
+
+func TestShareWrite(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	usr1, _ := cfg.GetUserByUsername("user1")
+	shr := usr1.GetShares(cfg.SharePathDeep, false)[0]
+	base := "/user1/" + shr.ResolveSymlinkName()
+	setPerm := func(perm string) {
+		usr1, _ := cfg.GetUserByUsername("user1")
+		shr := usr1.GetShares(cfg.SharePathDeep, false)[0]
+		shr.Permissions = map[string]string{"admin": perm}
+		usr1.DeleteShare(cfg.SharePathDeep)
+		usr1.AddShare(shr)
+		if err := cfg.Update(usr1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put := func(u, body string) int {
+		dat := map[string]interface{}{"u": u, "method": http.MethodPut, "body": bytes.NewBufferString(body)}
+		_, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.GetAdmin(), t, true)
+		return rs.StatusCode
+	}
+	del := func(u string) int {
+		dat := map[string]interface{}{"u": u, "method": http.MethodDelete}
+		_, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.GetAdmin(), t, true)
+		return rs.StatusCode
+	}
+
+	//read only by default
+	if code := put(base+"/w.txt", "data"); code != http.StatusForbidden {
+		t.Error("read only share must reject upload", code)
+	}
+
+	setPerm(config.SHARE_WRITE)
+	owner, admin := cfg.GetUsage("user1"), cfg.GetUsage("admin")
+	if code := put(base+"/w.txt", "data"); code != http.StatusOK {
+		t.Fatal("write permission must allow upload", code)
+	}
+	if _, err := cfg.User1FS.Stat(cfg.SharePathDeep + "/w.txt"); err != nil {
+		t.Error("file must be created at owner home", err)
+	}
+	if u := cfg.GetUsage("user1"); u.Bytes != owner.Bytes+4 || u.Files != owner.Files+1 {
+		t.Error("upload must count against owner quota", u, owner)
+	}
+	if u := cfg.GetUsage("admin"); u.Bytes != admin.Bytes {
+		t.Error("upload must not count against uploader quota", u, admin)
+	}
+	dat := map[string]interface{}{"u": base + "/dir/", "method": http.MethodPost}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.GetAdmin(), t, true); rs.StatusCode != http.StatusOK {
+		t.Error("write permission must allow new folder", rs.StatusCode)
+	}
+	if code := del(base + "/w.txt"); code != http.StatusForbidden {
+		t.Error("write permission must not allow delete", code)
+	}
+	dat = map[string]interface{}{"u": base + "/w.txt", "method": http.MethodPatch, "destination": base + "/w2.txt"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.GetAdmin(), t, true); rs.StatusCode != http.StatusForbidden {
+		t.Error("write permission must not allow rename", rs.StatusCode)
+	}
+
+	setPerm(config.SHARE_FULL)
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.GetAdmin(), t, true); rs.StatusCode != http.StatusOK {
+		t.Error("full permission must allow rename", rs.StatusCode)
+	}
+	//move outside of the share
+	dat = map[string]interface{}{"u": base + "/w2.txt", "method": http.MethodPatch, "destination": "/user1/w2.txt"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.GetAdmin(), t, true); rs.StatusCode != http.StatusForbidden {
+		t.Error("file must not be moved outside of the share", rs.StatusCode)
+	}
+	if code := del(base + "/w2.txt"); code != http.StatusOK {
+		t.Error("full permission must allow delete", code)
+	}
+	if code := del(base); code != http.StatusForbidden {
+		t.Error("share root must not be removed", code)
+	}
+
+	//owner quota
+	usr1, _ = cfg.GetUserByUsername("user1")
+	usr1.Quota = &config.Quota{Bytes: cfg.GetUsage("user1").Bytes + 2}
+	_ = cfg.Update(usr1)
+	if code := put(base+"/big.txt", "0123456789"); code != http.StatusInsufficientStorage {
+		t.Error("upload must fit into owner quota", code)
+	}
+
+	//webdav
+	setPerm(config.SHARE_WRITE)
+	dav := func(method, u string) int {
+		req, _ := http.NewRequest(method, cfg.Srv.URL+cnst.WEB_DAV_URL+"/shares"+u, strings.NewReader("x"))
+		req.SetBasicAuth("admin", "admin")
+		rs, err := cfg.Tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.StatusCode
+	}
+	if code := dav(http.MethodPut, base+"/d.txt"); code != http.StatusCreated {
+		t.Error("webdav upload into writable share", code)
+	}
+	if code := dav(http.MethodDelete, base+"/d.txt"); code != http.StatusForbidden {
+		t.Error("webdav delete needs full permission", code)
+	}
+}
+
+func TestDavLockCreate(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	usr1, _ := cfg.GetUserByUsername("user1")
+	shr := usr1.GetShares(cfg.SharePathDeep, false)[0]
+	shr.Permissions = map[string]string{"admin": config.SHARE_READ}
+	usr1.AllowNew = false
+	if err := cfg.Update(usr1); err != nil {
+		t.Fatal(err)
+	}
+	//lock of missing resource creates empty file
+	lock := func(u, user, pwd string) int {
+		body := `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope>` +
+			`<D:locktype><D:write/></D:locktype></D:lockinfo>`
+		req, _ := http.NewRequest("LOCK", cfg.Srv.URL+cnst.WEB_DAV_URL+u, strings.NewReader(body))
+		req.SetBasicAuth(user, pwd)
+		rs, err := cfg.Tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.StatusCode
+	}
+	home := cfg.GetUserHomePath("user1")
+	if code := lock("/shares/user1/"+shr.ResolveSymlinkName()+"/lock.txt", "admin", "admin"); code < 400 {
+		t.Error("lock must not create file at read only share", code)
+	}
+	if utils.Exists(filepath.Join(home, cfg.SharePathDeep, "lock.txt")) {
+		t.Error("file created at read only share")
+	}
+	if code := lock("/files/lock.txt", "user1", "1"); code < 400 {
+		t.Error("lock must not create file without permission to create", code)
+	}
+	if utils.Exists(filepath.Join(home, "lock.txt")) {
+		t.Error("file created without permission to create")
+	}
+
+	usr1, _ = cfg.GetUserByUsername("user1")
+	usr1.AllowNew = true
+	_ = cfg.Update(usr1)
+	if code := lock("/files/lock.txt", "user1", "1"); code != http.StatusCreated || !utils.Exists(filepath.Join(home, "lock.txt")) {
+		t.Error("lock must create file with permission to create", code)
+	}
+}
+
+func TestShareAccessLog(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	deep := cfg.Usr1.GetShares(cfg.SharePathDeep, false)[0]
+	base := "/user1/" + deep.ResolveSymlinkName()
+	dat := map[string]interface{}{"u": base, "share": "list"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.GetAdmin(), t, true); rs.StatusCode != http.StatusOK {
+		t.Fatal("list status", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": base + "/real.jpg"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_DOWNLOAD, dat, nil, t, true); rs.StatusCode != http.StatusOK {
+		t.Fatal("download status", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": base}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_DOWNLOAD, dat, nil, t, true); rs.StatusCode != http.StatusOK {
+		t.Fatal("zip status", rs.StatusCode)
+	}
+	//own files are not logged
+	dat = map[string]interface{}{"u": cfg.SharePathDeep + "/real.jpg"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("own download status", rs.StatusCode)
+	}
+	up := cfg.Usr1.GetShares(cfg.SharePathUp, false)[0]
+	dat = map[string]interface{}{"u": "/" + up.LinkName(up.Links[0].Token) + "/t.txt", cnst.P_EXSHARE: "1"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusOK {
+		t.Fatal("guest download status", rs.StatusCode)
+	}
+
+	var l []*config.AccessEntry
+	dat = map[string]interface{}{"u": cfg.SharePathDeep, "share": "log"}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	if err := json.NewDecoder(rs.Body).Decode(&l); err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 3 {
+		t.Fatal("wrong count of records", len(l))
+	}
+	for i, a := range []string{config.ACCESS_LIST, config.ACCESS_DOWNLOAD, config.ACCESS_ZIP} {
+		if l[i].Action != a || l[i].User != "admin" || l[i].Share != cfg.SharePathDeep || l[i].Bytes == 0 {
+			t.Error("wrong record", i, l[i])
+		}
+	}
+	if l[1].Path != cfg.SharePathDeep+"/real.jpg" {
+		t.Error("wrong path", l[1].Path)
+	}
+
+	l = nil
+	dat["u"] = "/"
+	_, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	if err := json.NewDecoder(rs.Body).Decode(&l); err != nil || len(l) != 4 {
+		t.Fatal("all shares log", err, len(l))
+	}
+	if g := l[3]; g.User != "" || g.IP != "127.0.0.1" || g.Share != cfg.SharePathUp || g.Action != config.ACCESS_DOWNLOAD {
+		t.Error("wrong guest record", g)
+	}
+	//nobody else reads the log
+	if l, _ := cfg.AccessLog("admin", "/"); len(l) != 0 {
+		t.Error("accesses must be logged for owner only", len(l))
+	}
+}
+
+func TestShareLinkLegacyURL(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	//legacy hash with slash, that can't be single url segment
+	p := "/ÿ"
+	if !strings.Contains(config.GenShareHash("user1", p), "/") {
+		t.Fatal("hash must have slash")
+	}
+	_ = os.MkdirAll(filepath.Join(cfg.GetUserHomePath("user1"), p), cnst.PERM_DEFAULT)
+	_ = ioutil.WriteFile(filepath.Join(cfg.GetUserHomePath("user1"), p, "t.txt"), []byte("legacy"), cnst.PERM_DEFAULT)
+	shr := &config.ShareItem{Path: p, AllowExternal: true}
+	for _, u := range cfg.Users {
+		if u.Username == "user1" {
+			u.Shares = append(u.Shares, shr)
+		}
+	}
+	cfg.Verify()
+	if len(shr.Links) != 1 || strings.Contains(shr.Links[0].Token, "/") {
+		t.Fatal("legacy link must be migrated with url safe token", shr.Links)
+	}
+	token := shr.Links[0].Token
+
+	rs, err := http.Get(cfg.Srv.URL + strings.TrimPrefix(cfg.ShareLinkURL(shr, token), cfg.ExternalShareHost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(rs.Body)
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusOK || !strings.Contains(string(b), "og:title") {
+		t.Error("legacy link page must be resolved", rs.StatusCode)
+	}
+	dat := map[string]interface{}{"u": "/" + shr.LinkName(token) + "/t.txt", cnst.P_EXSHARE: "1"}
+	_, rs, _ = cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.Guest, t, true)
+	if b, _ = ioutil.ReadAll(rs.Body); rs.StatusCode != http.StatusOK || string(b) != "legacy" {
+		t.Error("legacy link download must pass", rs.StatusCode)
+	}
+}
+
+func TestSharedWithAndBy(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	var with []*sharedWith
+	dat := map[string]interface{}{"u": "/", "share": "with-me"}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.GetAdmin(), t, true)
+	if err := json.NewDecoder(rs.Body).Decode(&with); err != nil {
+		t.Fatal(err)
+	}
+	if len(with) != 2 {
+		t.Fatal("wrong count of incoming shares", len(with))
+	}
+	for _, w := range with {
+		if w.Owner != "user1" || !w.IsDir || w.Size == 0 || w.ModTime.IsZero() || w.Permission != config.SHARE_READ {
+			t.Error("wrong incoming share", w)
+		}
+		dat = map[string]interface{}{"u": w.URL}
+		if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, nil, t, true); rs.StatusCode != http.StatusOK {
+			t.Error("incoming share must be listed by its url", rs.StatusCode)
+		}
+	}
+	//user2 sees only share to all local users
+	dat = map[string]interface{}{"u": "/", "share": "with-me"}
+	_, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr2, t, true)
+	with = nil
+	_ = json.NewDecoder(rs.Body).Decode(&with)
+	if len(with) != 1 || with[0].Path != cfg.SharePathUp {
+		t.Error("wrong incoming shares of user2", with)
+	}
+
+	var by []*sharedBy
+	dat = map[string]interface{}{"u": "/", "share": "by-me"}
+	_, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true)
+	if err := json.NewDecoder(rs.Body).Decode(&by); err != nil {
+		t.Fatal(err)
+	}
+	if len(by) != 2 {
+		t.Fatal("wrong count of outgoing shares", len(by))
+	}
+	for _, b := range by {
+		if b.Status != SHARE_ACTIVE {
+			t.Error("share must be active", b.Path)
+		}
+		if b.Path == cfg.SharePathUp && (len(b.Links) != 1 || b.Links[0].Expired || len(b.Links[0].URL) == 0) {
+			t.Error("external link must be shown")
+		}
+		if b.Path == cfg.SharePathDeep && (len(b.AllowUsers) != 1 || b.AllowUsers[0] != "admin") {
+			t.Error("recipients must be shown")
+		}
+	}
+	dat = map[string]interface{}{"u": "/", "share": "by-me"}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Guest, t, true); rs.StatusCode != http.StatusForbidden {
+		t.Error("guest has no shares", rs.StatusCode)
+	}
+}
+
+func TestShareLanding(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	get := func(u string) (int, string) {
+		rs, err := http.Get(cfg.Srv.URL + u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Body.Close()
+		b, _ := ioutil.ReadAll(rs.Body)
+		return rs.StatusCode, string(b)
+	}
+	up := cfg.Usr1.GetShares(cfg.SharePathUp, false)[0]
+	token := up.Links[0].Token
+	page := "/shares/" + up.LinkName(token) + "?" + cnst.P_EXSHARE + "=1"
+	_, b := get(page)
+	if !strings.Contains(b, `<meta property="og:title" content="test">`) || !strings.Contains(b, `twitter:card`) {
+		t.Fatal("share page must have meta tags", b)
+	}
+	if !strings.Contains(b, `<meta property="og:description" content="`) || !strings.Contains(b, " files, ") {
+		t.Error("share page must describe content", b)
+	}
+	if _, b = get("/shares/" + up.LinkName(token)); strings.Contains(b, "og:title") {
+		t.Error("meta tags only for external share")
+	}
+
+	//preview from cache
+	desc := func(b string) string {
+		b = b[strings.Index(b, `og:description" content="`):]
+		return b[:strings.Index(b, `">`)]
+	}
+	_ = ioutil.WriteFile(filepath.Join(cfg.GetUserHomePath("user1"), cfg.SharePathUp, "a.jpg"), []byte("src"), cnst.PERM_DEFAULT)
+	prev := filepath.Join(cfg.GetUserPreviewPath("user1"), cfg.SharePathUp, "a.jpg")
+	_ = os.MkdirAll(filepath.Dir(prev), cnst.PERM_DEFAULT)
+	_ = ioutil.WriteFile(prev, []byte("jpg"), cnst.PERM_DEFAULT)
+	if _, b = get(page); !strings.Contains(b, DL_URL+token+`/preview">`) {
+		t.Error("share page must have preview image", b)
+	}
+	if code, b := get(DL_URL + token + "/preview"); code != http.StatusOK || b != "jpg" {
+		t.Error("preview must be served without auth", code)
+	}
+
+	//files hidden by owner path rules are neither counted, nor previewed
+	visible := desc(b)
+	usr1, _ := cfg.GetUserByUsername("user1")
+	usr1.Rules = config.PathRules{{Path: cfg.SharePathUp + "/a.jpg", Mode: config.RULE_HIDDEN}}
+	_ = cfg.Update(usr1)
+	if _, b = get(page); strings.Contains(b, "og:image") || desc(b) == visible {
+		t.Error("hidden file must not be disclosed", b)
+	}
+	if code, _ := get(DL_URL + token + "/preview"); code != http.StatusNotFound {
+		t.Error("hidden file has no preview", code)
+	}
+	usr1.Rules = nil
+	_ = cfg.Update(usr1)
+
+	//direct download of single file share only
+	shr := &config.ShareItem{Path: cfg.SharePathUp + "/t.txt", AllowExternal: true}
+	cfg.Usr1.AddShare(shr)
+	_ = cfg.Update(cfg.Usr1)
+	usr1, _ = cfg.GetUserByUsername("user1")
+	shr = usr1.GetShares(cfg.SharePathUp+"/t.txt", false)[0]
+	want, _ := ioutil.ReadFile(filepath.Join(cfg.GetUserHomePath("user1"), cfg.SharePathUp, "t.txt"))
+	if code, b := get(DL_URL + shr.Links[0].Token); code != http.StatusOK || b != string(want) {
+		t.Error("file must be downloaded by direct link", code)
+	}
+	if code, _ := get(DL_URL + token); code != http.StatusNotFound {
+		t.Error("folder has no direct link", code)
+	}
+	if code, _ := get(DL_URL + "bad"); code != http.StatusNotFound {
+		t.Error("unknown token", code)
+	}
+
+	//content of protected share is not disclosed
+	up.Password, _ = lib.HashPassword("1")
+	usr1.DeleteShare(cfg.SharePathUp)
+	usr1.AddShare(up)
+	_ = cfg.Update(usr1)
+	if _, b = get(page); !strings.Contains(b, "Password protected share") || strings.Contains(b, "og:image") {
+		t.Error("protected share must not disclose content", b)
+	}
+	if code, _ := get(DL_URL + token + "/preview"); code != http.StatusNotFound {
+		t.Error("protected share has no preview", code)
+	}
+}
@@ -0,0 +1,106 @@
+package web
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/utils"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//state of the share lifetime
+const (
+	SHARE_ACTIVE  = "active"
+	SHARE_PENDING = "pending"
+	SHARE_EXPIRED = "expired"
+)
+
+//share of other user, available for the user
+type sharedWith struct {
+	Owner string `json:"owner"`
+	//share path at owner home
+	Path string `json:"path"`
+	//url at shares, like /owner/name_hash
+	URL        string     `json:"url"`
+	Permission string     `json:"permission"`
+	IsDir      bool       `json:"isDir"`
+	Size       int64      `json:"size"`
+	ModTime    time.Time  `json:"modified"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+//share of the user with its recipients
+type sharedBy struct {
+	*config.ShareItem
+	//url at shares of recipients, like /owner/name_hash
+	URL    string `json:"url"`
+	Status string `json:"status"`
+	//external links with their state, replaces links of the share
+	Links []*sharedLink `json:"links,omitempty"`
+}
+
+type sharedLink struct {
+	*config.ShareLink
+	URL     string `json:"url"`
+	Expired bool   `json:"expired"`
+}
+
+//shares of other users available for the user
+func sharedWithHandler(c *lib.Context) (int, error) {
+	if c.User == nil || c.User.IsGuest() {
+		return http.StatusForbidden, nil
+	}
+	res := []*sharedWith{}
+	for _, a := range c.Config.SharesWith(c.User.Username) {
+		itm := &sharedWith{
+			Owner:      a.Username,
+			Path:       a.Path,
+			URL:        "/" + a.Username + "/" + a.ResolveSymlinkName(),
+			Permission: a.Permission(c.User.Username),
+			ExpiresAt:  a.ShareItem.ExpiresAt,
+		}
+		p := filepath.Join(c.Config.GetUserHomePath(a.Username), a.Path)
+		info, err := os.Stat(p)
+		if err != nil {
+			//source is missing, share is shown once it is back
+			continue
+		}
+		itm.IsDir, itm.ModTime, itm.Size = info.IsDir(), info.ModTime(), info.Size()
+		if info.IsDir() {
+			itm.Size, _ = utils.DirSize(p)
+		}
+		res = append(res, itm)
+	}
+	return renderJSON(c, res)
+}
+
+//shares of the user with recipients and state of external links
+func sharedByHandler(c *lib.Context) (int, error) {
+	if c.User == nil || c.User.IsGuest() {
+		return http.StatusForbidden, nil
+	}
+	now := time.Now()
+	res := []*sharedBy{}
+	for _, shr := range maskShares(c.User.Shares) {
+		itm := &sharedBy{ShareItem: shr, URL: "/" + c.User.Username + "/" + shr.ResolveSymlinkName()}
+		switch c.Config.CheckShare(itm.URL, false) {
+		case nil:
+			itm.Status = SHARE_ACTIVE
+		case cnst.ErrShareAccess:
+			itm.Status = SHARE_PENDING
+		default:
+			itm.Status = SHARE_EXPIRED
+		}
+		for _, l := range shr.Links {
+			itm.Links = append(itm.Links, &sharedLink{
+				l, c.Config.ShareLinkURL(shr, l.Token),
+				l.ExpiresAt != nil && !now.Before(*l.ExpiresAt),
+			})
+		}
+		res = append(res, itm)
+	}
+	return renderJSON(c, res)
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//route /api/users/name/tokens[/id], tokens are created only by the user itself, admin can list and revoke them
+func tokensHandler(c *fb.Context, u *config.UserConfig, id string, self bool) (int, error) {
+	switch {
+	case c.Method == http.MethodGet && len(id) == 0:
+		u.MaskSecrets()
+		if u.Tokens == nil {
+			u.Tokens = []*config.AccessToken{}
+		}
+		return renderJSON(c, u.Tokens)
+	case c.Method == http.MethodPost && len(id) == 0:
+		if !self {
+			return http.StatusForbidden, nil
+		}
+		var req struct {
+			Name      string     `json:"name"`
+			Scopes    []string   `json:"scopes"`
+			ExpiresAt *time.Time `json:"expiresAt"`
+		}
+		if c.REQ.Body == nil || json.NewDecoder(c.REQ.Body).Decode(&req) != nil {
+			return http.StatusBadRequest, cnst.ErrEmptyRequest
+		}
+		t, plain, err := c.Config.AddToken(u.Username, req.Name, req.Scopes, req.ExpiresAt)
+		if err == cnst.ErrInvalidOption {
+			return http.StatusBadRequest, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		//plain value is shown only once
+		return renderJSON(c, struct {
+			*config.AccessToken
+			Token string `json:"token"`
+		}{t, plain})
+	case c.Method == http.MethodDelete && len(id) > 0:
+		if err := c.Config.DeleteToken(u.Username, id); err != nil {
+			return cnst.ErrorToHTTP(err, false), err
+		}
+		clearDavAuth()
+		return http.StatusOK, nil
+	}
+	return http.StatusMethodNotAllowed, nil
+}
+
+//personal access token of request, from X-Auth header or Authorization Bearer
+func requestToken(r *http.Request) string {
+	t := r.Header.Get(cnst.H_XAUTH)
+	if len(t) == 0 {
+		if a := r.Header.Get("Authorization"); len(a) > 7 && strings.EqualFold(a[:7], "Bearer ") {
+			t = a[7:]
+		}
+	}
+	if strings.HasPrefix(t, config.TOKEN_PREFIX) {
+		return t
+	}
+	return ""
+}
+
+//true in case scopes of request token allow api call, admin permission of the user itself is checked by handlers
+func tokenAllows(c *fb.Context) bool {
+	t := c.Token
+	if t == nil {
+		return true
+	}
+	read := c.Method == http.MethodGet || c.Method == http.MethodHead
+	switch c.Router {
+	case cnst.R_SETTINGS:
+		return t.HasScope(config.SCOPE_ADMIN)
+	case cnst.R_USERS:
+		//tokens, second factor and passwords are never managed by token, see userAuthHandler and usersPutHandler
+		return read && t.HasScope(config.SCOPE_READ) || t.HasScope(config.SCOPE_ADMIN)
+	case cnst.R_SHARES:
+		if read {
+			return t.HasScope(config.SCOPE_READ)
+		}
+		return t.HasScope(config.SCOPE_SHARE)
+	case cnst.R_RESOURCE:
+		if read {
+			return t.HasScope(config.SCOPE_READ)
+		}
+		return t.HasScope(config.SCOPE_WRITE)
+	}
+	return t.HasScope(config.SCOPE_READ)
+}
+
+//true in case token scopes allow webdav method
+func davTokenAllows(t *config.AccessToken, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return t.HasScope(config.SCOPE_READ)
+	}
+	return t.HasScope(config.SCOPE_WRITE)
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"net/http"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	create := func(scopes ...string) (int, map[string]interface{}) {
+		b := new(bytes.Buffer)
+		_ = json.NewEncoder(b).Encode(map[string]interface{}{"name": "script", "scopes": scopes})
+		dat := map[string]interface{}{"u": "/user1/tokens", "method": http.MethodPost, "body": b}
+		_, rs, _ := cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr1, t, false)
+		var res map[string]interface{}
+		_ = json.NewDecoder(rs.Body).Decode(&res)
+		return rs.StatusCode, res
+	}
+	if code, _ := create("admin"); code != http.StatusBadRequest {
+		t.Fatal("admin scope requires admin", code)
+	}
+	if code, _ := create("root"); code != http.StatusBadRequest {
+		t.Fatal("unknown scope must fail", code)
+	}
+	code, ro := create("read")
+	if code != http.StatusOK || len(ro["token"].(string)) == 0 || ro["hash"] != nil {
+		t.Fatal("token must be created", code, ro)
+	}
+	_, rw := create("read", "write")
+
+	//X-Auth
+	cfg.Token = ro["token"].(string)
+	dat := map[string]interface{}{"u": "/"}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_RESOURCE, dat, nil, t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("read token must list files", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": "/tokdir/", "method": http.MethodPost}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_RESOURCE, dat, nil, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("read token must not write", rs.StatusCode)
+	}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SETTINGS, map[string]interface{}{"u": "/"}, nil, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("token without admin scope must not read settings", rs.StatusCode)
+	}
+	//token can't be exchanged for session
+	req, _ := http.NewRequest(http.MethodPost, cfg.Srv.URL+"/api/auth/renew", nil)
+	req.Header.Set(cnst.H_XAUTH, cfg.Token)
+	if rs, err := cfg.Tr.RoundTrip(req); err != nil || rs.StatusCode != http.StatusForbidden {
+		t.Error("token must not be renewed")
+	}
+
+	//Bearer
+	cfg.Token = rw["token"].(string)
+	req, _ = http.NewRequest(http.MethodPost, cfg.BuildUrl(cnst.R_RESOURCE, dat, false).String(), nil)
+	req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	if rs, err := cfg.Tr.RoundTrip(req); err != nil || rs.StatusCode != http.StatusOK {
+		t.Fatal("write token must create folder", err)
+	}
+
+	//webdav
+	dav := func(method, pw string) int {
+		req, _ := http.NewRequest(method, cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/tokdir2/", nil)
+		req.SetBasicAuth("user1", pw)
+		rs, err := cfg.Tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.StatusCode
+	}
+	if c := dav("PROPFIND", ro["token"].(string)); c != http.StatusNotFound {
+		t.Error("read token must pass webdav", c)
+	}
+	if c := dav("MKCOL", ro["token"].(string)); c != http.StatusForbidden {
+		t.Error("read token must not write through webdav", c)
+	}
+	if c := dav("MKCOL", rw["token"].(string)); c != http.StatusCreated {
+		t.Error("write token must write through webdav", c)
+	}
+
+	//listing hides hashes and shows usage
+	_, rs, _ := cfg.MakeRequest(cnst.R_USERS, map[string]interface{}{"u": "/user1/tokens"}, cfg.Usr1, t, false)
+	var list []map[string]interface{}
+	_ = json.NewDecoder(rs.Body).Decode(&list)
+	if len(list) != 2 || list[0]["hash"] != nil || list[0]["lastUsed"] == nil {
+		t.Fatal("wrong listing", list)
+	}
+	dat = map[string]interface{}{"u": "/user1/tokens/" + ro["id"].(string), "method": http.MethodDelete}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr2, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("other user can't revoke", rs.StatusCode)
+	}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("owner can revoke", rs.StatusCode)
+	}
+	if c := dav("PROPFIND", ro["token"].(string)); c != http.StatusUnauthorized {
+		t.Error("revoked token must fail", c)
+	}
+	cfg.Token = ro["token"].(string)
+	if _, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, map[string]interface{}{"u": "/"}, nil, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("revoked token must fail", rs.StatusCode)
+	}
+
+	//admin scope does not allow to mint tokens, enroll second factor or change password
+	_, plain, err := cfg.AddToken("admin", "root", []string{config.SCOPE_READ, config.SCOPE_WRITE, config.SCOPE_ADMIN}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Token = plain
+	if _, rs, _ = cfg.MakeRequest(cnst.R_USERS, map[string]interface{}{"u": "/"}, nil, t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("admin token must manage users", rs.StatusCode)
+	}
+	b := bytes.NewBufferString(`{"name":"more","scopes":["admin"]}`)
+	dat = map[string]interface{}{"u": "/admin/tokens", "method": http.MethodPost, "body": b}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, nil, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("token must not create tokens", rs.StatusCode)
+	}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_USERS, map[string]interface{}{"u": "/admin/tokens"}, nil, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("token must not list tokens", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": "/admin/totp", "method": http.MethodPost}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, nil, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Error("token must not enroll second factor", rs.StatusCode)
+	}
+	for _, which := range []string{"password", "all"} {
+		b = bytes.NewBufferString(`{"what":"user","which":"` + which + `","data":{"username":"admin","password":"new","admin":true}}`)
+		dat = map[string]interface{}{"u": "/admin", "method": http.MethodPut, "body": b}
+		if _, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, nil, t, false); rs.StatusCode != http.StatusForbidden {
+			t.Error("token must not change password", which, rs.StatusCode)
+		}
+	}
+	if u, _ := cfg.GetUserByUsername("admin"); len(u.Tokens) != 1 || u.HasTOTP() {
+		t.Error("account must stay unchanged", len(u.Tokens))
+	}
+}
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"net/http"
+	"strings"
+)
+
+//state of second factor of the user
+type totpInfo struct {
+	Enabled bool `json:"enabled"`
+	//enrollment started, but not confirmed yet
+	Pending bool `json:"pending"`
+	//count of unused recovery codes
+	Recovery int `json:"recovery"`
+}
+
+//route /api/users/name/totp and /api/users/name/tokens[/id].
+//Enrollment is done only by the user itself, admin can view state, disable second factor and revoke tokens
+func userAuthHandler(c *fb.Context, name, sub string) (int, error) {
+	//token must not mint other tokens or change second factor, whatever its scopes
+	if c.Token != nil {
+		return http.StatusForbidden, nil
+	}
+	self := c.User.Username == name && !c.User.IsGuest()
+	if !self && !c.User.Admin {
+		return http.StatusForbidden, nil
+	}
+	u, ok := c.Config.GetUserByUsername(name)
+	if !ok || u.IsGuest() {
+		return http.StatusNotFound, cnst.ErrNotExist
+	}
+	arr := strings.SplitN(sub, "/", 2)
+	switch {
+	case arr[0] == "totp" && len(arr) == 1:
+		return totpHandler(c, u, self)
+	case arr[0] == "tokens":
+		id := ""
+		if len(arr) > 1 {
+			id = arr[1]
+		}
+		return tokensHandler(c, u, id, self)
+	}
+	return http.StatusNotFound, cnst.ErrNotExist
+}
+
+func totpHandler(c *fb.Context, u *config.UserConfig, self bool) (int, error) {
+	switch c.Method {
+	case http.MethodGet:
+		res := &totpInfo{}
+		if u.TOTP != nil {
+			res.Enabled, res.Pending, res.Recovery = u.TOTP.Enabled, !u.TOTP.Enabled, len(u.TOTP.Recovery)
+		}
+		return renderJSON(c, res)
+	case http.MethodPost:
+		if !self {
+			return http.StatusForbidden, nil
+		}
+		secret, uri, err := c.Config.EnrollTOTP(u.Username)
+		if err == cnst.ErrExist {
+			return http.StatusConflict, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return renderJSON(c, map[string]string{"secret": secret, "uri": uri})
+	case http.MethodPut:
+		if !self {
+			return http.StatusForbidden, nil
+		}
+		var req struct {
+			Code string `json:"code"`
+		}
+		if c.REQ.Body == nil || json.NewDecoder(c.REQ.Body).Decode(&req) != nil {
+			return http.StatusBadRequest, cnst.ErrEmptyRequest
+		}
+		recovery, err := c.Config.ConfirmTOTP(u.Username, req.Code)
+		if err == cnst.ErrInvalidOption {
+			return http.StatusConflict, err
+		} else if err != nil {
+			return cnst.ErrorToHTTP(err, false), err
+		}
+		clearDavAuth()
+		return renderJSON(c, map[string][]string{"recovery": recovery})
+	case http.MethodDelete:
+		if err := c.Config.DisableTOTP(u.Username); err != nil {
+			return cnst.ErrorToHTTP(err, false), err
+		}
+		return http.StatusOK, nil
+	}
+	return http.StatusMethodNotAllowed, nil
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/browsefile/backend/src/cnst"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+//current authenticator code of the secret
+func totpNow(secret string) string {
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[off:off+4])&0x7fffffff)%1000000)
+}
+
+func TestTOTPLogin(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	body := func(v interface{}) *bytes.Buffer {
+		b := new(bytes.Buffer)
+		_ = json.NewEncoder(b).Encode(v)
+		return b
+	}
+	//enrollment is allowed only for the user itself
+	dat := map[string]interface{}{"u": "/user1/totp", "method": http.MethodPost}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr2, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Fatal("other user can't enroll", rs.StatusCode)
+	}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_USERS, dat, cfg.GetAdmin(), t, false); rs.StatusCode != http.StatusForbidden {
+		t.Fatal("admin can't enroll for user", rs.StatusCode)
+	}
+	_, rs, _ := cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr1, t, false)
+	var enroll map[string]string
+	_ = json.NewDecoder(rs.Body).Decode(&enroll)
+	if rs.StatusCode != http.StatusOK || !strings.HasPrefix(enroll["uri"], "otpauth://totp/") {
+		t.Fatal("wrong enrollment", rs.StatusCode, enroll)
+	}
+	dat = map[string]interface{}{"u": "/user1/totp", "method": http.MethodPut, "body": body(map[string]string{"code": "000000"})}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Fatal("wrong code must not confirm", rs.StatusCode)
+	}
+	dat["body"] = body(map[string]string{"code": totpNow(enroll["secret"])})
+	_, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr1, t, false)
+	var conf map[string][]string
+	_ = json.NewDecoder(rs.Body).Decode(&conf)
+	if rs.StatusCode != http.StatusOK || len(conf["recovery"]) == 0 {
+		t.Fatal("second factor must be enabled", rs.StatusCode)
+	}
+	//secret never reaches the client
+	dat = map[string]interface{}{"u": "/user1"}
+	_, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr1, t, false)
+	var info map[string]interface{}
+	_ = json.NewDecoder(rs.Body).Decode(&info)
+	if tp, _ := info["totp"].(map[string]interface{}); tp == nil || tp["enabled"] != true || tp["secret"] != "" {
+		t.Error("secret must be masked", info["totp"])
+	}
+
+	login := func(p string, v interface{}) (*http.Response, map[string]string) {
+		rs, err := http.Post(cfg.Srv.URL+"/api/auth/"+p, "application/json", body(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := map[string]string{}
+		if strings.HasPrefix(rs.Header.Get("Content-Type"), "application/json") {
+			_ = json.NewDecoder(rs.Body).Decode(&res)
+		}
+		return rs, res
+	}
+	//password alone gives only token of second step
+	rs, step := login("get", map[string]string{"username": "user1", "password": "1"})
+	if rs.StatusCode != http.StatusOK || step["secondFactor"] != "totp" || len(step["token"]) == 0 {
+		t.Fatal("second step expected", rs.StatusCode, step)
+	}
+	req, _ := http.NewRequest(http.MethodGet, cfg.Srv.URL+"/api/resource/", nil)
+	req.Header.Set(cnst.H_XAUTH, step["token"])
+	if rs, _ = http.DefaultClient.Do(req); rs.StatusCode == http.StatusOK {
+		t.Fatal("token of second step must not be accepted as session")
+	}
+	if rs, _ = login("totp", map[string]string{"token": step["token"], "code": "000000"}); rs.StatusCode != http.StatusForbidden {
+		t.Fatal("wrong code must fail", rs.StatusCode)
+	}
+	if rs, _ = login("totp", map[string]string{"token": "bad", "code": conf["recovery"][0]}); rs.StatusCode != http.StatusForbidden {
+		t.Fatal("wrong token must fail", rs.StatusCode)
+	}
+	if rs, _ = login("totp", map[string]string{"token": step["token"], "code": conf["recovery"][0]}); rs.StatusCode != http.StatusOK {
+		t.Fatal("recovery code must pass", rs.StatusCode)
+	}
+	if rs, _ = login("get", map[string]string{"username": "user1", "password": "1", "otp": conf["recovery"][1]}); rs.StatusCode != http.StatusOK {
+		t.Fatal("code with password must pass", rs.StatusCode)
+	}
+
+	//webdav accepts only tokens once second factor enabled
+	dav := func(pw string) int {
+		req, _ := http.NewRequest("PROPFIND", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/", nil)
+		req.SetBasicAuth("user1", pw)
+		rs, err := cfg.Tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.StatusCode
+	}
+	if dav("1") != http.StatusUnauthorized {
+		t.Error("password must not pass second factor")
+	}
+	dat = map[string]interface{}{"u": "/user1/tokens", "method": http.MethodPost, "body": body(map[string]interface{}{"name": "phone", "scopes": []string{"read", "write"}})}
+	_, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr1, t, false)
+	var ap map[string]interface{}
+	_ = json.NewDecoder(rs.Body).Decode(&ap)
+	pw, _ := ap["token"].(string)
+	if rs.StatusCode != http.StatusOK || len(pw) == 0 {
+		t.Fatal("token must be created", rs.StatusCode)
+	}
+	if dav(pw) != http.StatusMultiStatus {
+		t.Error("token must pass")
+	}
+	dat = map[string]interface{}{"u": "/user1/tokens/" + ap["id"].(string), "method": http.MethodDelete}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.GetAdmin(), t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("admin can revoke token", rs.StatusCode)
+	}
+	if dav(pw) != http.StatusUnauthorized {
+		t.Error("revoked token must fail")
+	}
+}
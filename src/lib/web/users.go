@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -21,9 +24,30 @@ type ModifyUserRequest struct {
 	Data *fb.UserModel `json:"data"`
 }
 
+//user with used storage and effective quota, usage visible only for admin and user itself
+type userInfo struct {
+	*config.UserConfig
+	Usage *config.Usage `json:"usage,omitempty"`
+}
+
+func makeUserInfo(c *fb.Context, u *config.UserConfig) *userInfo {
+	u.Shares = maskShares(u.Shares)
+	u.MaskSecrets()
+	res := &userInfo{UserConfig: u}
+	if c.User.Admin || c.User.Username == u.Username {
+		usg := c.Config.GetUsage(u.Username)
+		res.Usage = &usg
+	}
+	return res
+}
+
 // usersHandler is the entry point of the users API. It's just a router
 // to send the request to its
 func usersHandler(c *fb.Context) (int, error) {
+	//second factor and access tokens are managed by users itself
+	if arr := strings.SplitN(strings.Trim(c.URL, "/"), "/", 2); len(arr) == 2 {
+		return userAuthHandler(c, arr[0], arr[1])
+	}
 	// If the user isn't admin and isn't making a PUT
 	// request, then return forbidden.
 	if !c.User.Admin && c.Method != http.MethodGet {
@@ -59,27 +83,48 @@ func getUserName(r string) (string) {
 // parseUserFromRequest returns the user which is present in the request
 // body. If the body is empty or the JSON is invalid, it
 // returns an fb.Error.
-func parseUserFromRequest(c *fb.Context) (*fb.UserModel, string, error) {
+func parseUserFromRequest(c *fb.Context) (*fb.UserModel, string, userFields, error) {
 	// Checks if the request body is empty.
 	if c.REQ.Body == nil {
-		return nil, "", cnst.ErrEmptyRequest
+		return nil, "", nil, cnst.ErrEmptyRequest
 	}
 
 	// Parses the request body and checks if it's well formed.
-	mod := &ModifyUserRequest{}
-	err := json.NewDecoder(c.REQ.Body).Decode(mod)
+	b, err := ioutil.ReadAll(c.REQ.Body)
 	if err != nil {
-		return nil, "", err
+		return nil, "", nil, err
+	}
+	mod := &ModifyUserRequest{}
+	if err = json.Unmarshal(b, mod); err != nil {
+		return nil, "", nil, err
+	}
+	//fields, that were present at request, so omitted ones are not reset
+	var sent struct {
+		Data userFields `json:"data"`
+	}
+	if err = json.Unmarshal(b, &sent); err != nil {
+		return nil, "", nil, err
 	}
 
 	// Checks if the request type is right.
 	if mod.What != "user" {
-		return nil, "", cnst.ErrWrongDataType
+		return nil, "", nil, cnst.ErrWrongDataType
 	}
 
+	//second factor and tokens are managed by their own routes
+	mod.Data.TOTP = nil
+	mod.Data.Tokens = nil
 	mod.Data.FileSystem = c.NewFS(c.GetUserHomePath())
 	mod.Data.FileSystemPreview = c.NewFS(c.GetUserPreviewPath())
-	return mod.Data, mod.Which, nil
+	return mod.Data, mod.Which, sent.Data, nil
+}
+
+//json fields of user at modify request
+type userFields map[string]json.RawMessage
+
+func (f userFields) has(name string) bool {
+	_, ok := f[name]
+	return ok
 }
 
 func usersGetHandler(c *fb.Context) (int, error) {
@@ -95,7 +140,9 @@ func usersGetHandler(c *fb.Context) (int, error) {
 			return http.StatusInternalServerError, errors.New("cant find any users")
 		}
 
-		for _, u := range users {
+		res := make([]*userInfo, len(users))
+		for i, u := range users {
+			res[i] = makeUserInfo(c, u)
 			// Removes the user password so it won't
 			// be sent to the front-end.
 			u.Password = ""
@@ -106,10 +153,11 @@ func usersGetHandler(c *fb.Context) (int, error) {
 				u.IpAuth = nil
 				u.Shares = nil
 				u.ViewMode = ""
+				u.Quota = nil
 			}
 		}
 
-		return renderJSON(c, users)
+		return renderJSON(c, res)
 	}
 
 	name := getUserName(c.URL)
@@ -119,7 +167,7 @@ func usersGetHandler(c *fb.Context) (int, error) {
 	}
 
 	u.Password = ""
-	return renderJSON(c, u)
+	return renderJSON(c, makeUserInfo(c, u))
 }
 
 func usersPostHandler(c *fb.Context) (int, error) {
@@ -127,7 +175,7 @@ func usersPostHandler(c *fb.Context) (int, error) {
 		return http.StatusMethodNotAllowed, nil
 	}
 
-	u, _, err := parseUserFromRequest(c)
+	u, _, _, err := parseUserFromRequest(c)
 	if err != nil {
 		return http.StatusBadRequest, err
 	}
@@ -141,6 +189,9 @@ func usersPostHandler(c *fb.Context) (int, error) {
 	if u.Password == "" {
 		return http.StatusBadRequest, cnst.ErrEmptyPassword
 	}
+	if err = u.Rules.Validate(); err != nil {
+		return http.StatusBadRequest, err
+	}
 
 	// Checks if the scope exists.
 	if code, err := makeFS(c.Config.GetUserHomePath(u.Username)); err != nil {
@@ -165,6 +216,7 @@ func usersPostHandler(c *fb.Context) (int, error) {
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
+	DavHandler(c.FileBrowser)
 
 	// Set the Location header and return.
 	c.RESP.Header().Set("Location", "/settings/users/"+u.Username)
@@ -172,6 +224,52 @@ func usersPostHandler(c *fb.Context) (int, error) {
 	return http.StatusOK, nil
 }
 
+//user of external identity(oidc, ldap), missing user is created in case allowed. Permissions follow mapped groups
+func provisionUser(c *fb.Context, source string, m *config.GroupMapping, create bool, username string, groups []string, syncGroups bool) (*config.UserConfig, int, error) {
+	if len(username) == 0 || username == cnst.GUEST || strings.ContainsAny(username, "/\\:") || strings.HasPrefix(username, ".") {
+		return nil, http.StatusForbidden, errors.New(source + " : unsupported username '" + username + "'")
+	}
+	admin, edit := m.GroupFlags(groups)
+	u, ok := c.Config.GetUserByUsername(username)
+	if ok {
+		if m.MapsGroups() && syncGroups && (u.Admin != admin || u.AllowEdit != edit || u.AllowNew != edit) {
+			u.Admin, u.AllowEdit, u.AllowNew = admin, edit, edit
+			if err := c.Config.Update(u); err != nil {
+				return nil, http.StatusInternalServerError, err
+			}
+			log.Printf("%s : permissions of %s updated from groups, admin %v, edit %v", source, username, admin, edit)
+		}
+		return u, 0, nil
+	}
+	if !create {
+		return nil, http.StatusForbidden, errors.New(source + " : unknown user " + username)
+	}
+	if code, err := makeFS(c.Config.GetUserHomePath(username)); err != nil {
+		return nil, code, err
+	}
+	//local password is never known, webdav clients use access tokens
+	pw, err := fb.HashPassword(fb.RandomString())
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	u = &config.UserConfig{
+		Username:  username,
+		Password:  pw,
+		Admin:     admin,
+		AllowEdit: edit,
+		AllowNew:  edit,
+		Locale:    "en",
+		ViewMode:  cnst.MosaicViewMode,
+	}
+	if err = c.Config.AddUser(u); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	log.Printf("%s : user %s created, admin %v, edit %v", source, username, admin, edit)
+	DavHandler(c.FileBrowser)
+	u, _ = c.Config.GetUserByUsername(username)
+	return u, 0, nil
+}
+
 func makeFS(path string) (int, error) {
 	info, err := os.Stat(path)
 
@@ -236,7 +334,7 @@ func usersPutHandler(c *fb.Context) (int, error) {
 	}
 
 	// GetUsers the user from the request body.
-	u, which, err := parseUserFromRequest(c)
+	u, which, sent, err := parseUserFromRequest(c)
 	if err != nil {
 		return http.StatusBadRequest, err
 	}
@@ -270,6 +368,11 @@ func usersPutHandler(c *fb.Context) (int, error) {
 		return http.StatusOK, nil
 	}
 
+	//password is changed at session only, so leaked token can't take over the account
+	if c.Token != nil && (which == "password" || u.Password != "") {
+		return http.StatusForbidden, nil
+	}
+
 	// Updates the Password.
 	if which == "password" {
 		if u.Password == "" {
@@ -289,6 +392,7 @@ func usersPutHandler(c *fb.Context) (int, error) {
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
+		clearDavAuth()
 
 		return http.StatusOK, nil
 	}
@@ -313,6 +417,9 @@ func usersPutHandler(c *fb.Context) (int, error) {
 	if u.Username == "" {
 		return http.StatusBadRequest, cnst.ErrEmptyUsername
 	}
+	if err = u.Rules.Validate(); err != nil {
+		return http.StatusBadRequest, err
+	}
 
 	// Checks if the scope exists.
 	if code, err := makeFS(c.Config.GetUserHomePath(u.Username)); err != nil {
@@ -338,6 +445,14 @@ func usersPutHandler(c *fb.Context) (int, error) {
 		u.Password = original.Password
 	}
 	u.Shares = original.Shares
+	//storage limit is set by admin only, and kept in case it was omitted
+	if !c.User.Admin || !sent.has("quota") {
+		u.Quota = original.Quota
+	}
+	//path restrictions are set by admin only, and kept in case they were omitted
+	if !c.User.Admin || !sent.has("pathRules") {
+		u.Rules = original.Rules
+	}
 
 	// Updates the whole User struct because we always are supposed
 	// to send a new entire object.
@@ -345,6 +460,7 @@ func usersPutHandler(c *fb.Context) (int, error) {
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
+	clearDavAuth()
 
 	return http.StatusOK, nil
 }
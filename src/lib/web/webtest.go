@@ -120,18 +120,31 @@ func (tc *TServContext) BuildUrl(r int, params map[string]interface{}, isShare b
 		if dst, ok := params["destination"]; ok {
 			q.Set("destination", dst.(string))
 		}
+		if act, ok := params["action"]; ok {
+			q.Set("action", act.(string))
+		}
 	case cnst.R_SHARES:
 		parsedURL += "/shares" + urlSuf
 		if share, ok := params["share"]; ok {
 			q.Set("share", share.(string))
 		}
+		for _, k := range []string{"token", "label", "destination", "action"} {
+			if v, ok := params[k]; ok {
+				q.Set(k, v.(string))
+			}
+		}
 	case cnst.R_USERS:
 		parsedURL += "/users" + urlSuf
+	case cnst.R_SETTINGS:
+		parsedURL += "/settings" + urlSuf
 	}
 	_, ok := params[cnst.P_PREVIEW_TYPE]
 	if ok {
 		q.Set(cnst.P_PREVIEW_TYPE, params[cnst.P_PREVIEW_TYPE].(string))
 	}
+	if sa, ok := params[cnst.P_SHARE_AUTH]; ok {
+		q.Set(cnst.P_SHARE_AUTH, sa.(string))
+	}
 
 	resURL, _ := url.Parse(parsedURL)
 	resURL.RawQuery = q.Encode()
@@ -151,6 +164,8 @@ func (tc *TServContext) InitServ(t *testing.T) {
 	cfg.Usr1.AddShare(shrDeep)
 	_ = cfg.Update(cfg.Usr1)
 	cfg.WriteConfig()
+	//every test logs in from same address, failures of previous ones must not delay it
+	clearLoginFailures()
 
 	cfg.Srv = httptest.NewServer(SetupHandler(cfg.GlobalConfig))
 	cfg.Tr = &http.Transport{}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/browsefile/backend/src/cnst"
 	"github.com/browsefile/backend/src/config"
@@ -21,22 +22,36 @@ func main() {
 	/*	go func() {
 		log.Println(http.ListenAndServe("localhost:6060", nil))
 	}()*/
-	fmt.Println("browsefile", cnst.Version)
 	cfg := new(config.GlobalConfig)
-	if len(os.Args) > 1 {
-		if os.Args[1] == "-h" {
-			fmt.Printf("Default config file locations : '%s', '%s'. Also you can specify own by passing path as first argument.", cnst.FilePath1, cnst.FilePath2)
-			os.Exit(0)
-		} else {
-			cfg.Path = os.Args[1]
+	cfgPath := flag.String("config", config.EnvConfigPath(), "config file path, env "+config.ENV_PREFIX+"CONFIG")
+	remote := flag.String("remote", "", "run command against running server, http://host:port")
+	login := flag.String("login", "", "user:password for -remote")
+	cfg.BindFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [config path | command]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Default config file locations : '%s', '%s'. Values are taken from defaults, config file, environment variables and flags, latest wins.\n", cnst.FilePath1, cnst.FilePath2)
+		flag.PrintDefaults()
+		commandsUsage(flag.CommandLine.Output())
+	}
+	flag.Parse()
+	cfg.Path = *cfgPath
+	if flag.NArg() > 0 && isCommand(flag.Arg(0)) {
+		if err := runCommand(cfg, *remote, *login, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		return
+	}
+	fmt.Println("browsefile", cnst.Version)
+	if flag.NArg() > 0 {
+		cfg.Path = flag.Arg(0)
 	}
 
 	cfg.ReadConfigFile()
 	var listener, listenerTLS net.Listener
 	var err error
 	isHttp := cfg.Http != nil && cfg.Http.Port > 0
-	isTLS := cfg.Tls != nil && cfg.Tls.Port > 0 && len(cfg.TLSCert) > 0 && len(cfg.TLSCert) > 0
+	isTLS := cfg.Tls != nil && cfg.Tls.Port > 0 && len(cfg.TLSCert) > 0 && len(cfg.TLSKey) > 0
 	// Builds the address and a listener.
 	if isHttp {
 		listener, err = net.Listen("tcp", cfg.Http.IP+":"+strconv.Itoa(cfg.Http.Port))
@@ -52,6 +67,10 @@ func main() {
 	}
 
 	srv := &http.Server{Handler: web.SetupHandler(cfg), ReadTimeout: 5 * time.Hour, WriteTimeout: 5 * time.Hour}
+	//pick up config file changes without restart
+	go cfg.WatchConfig(5 * time.Second)
+	//drop expired share links and old share access records
+	go cfg.SweepShares(time.Minute)
 	// Tell the user the port in which is listening.
 	if isHttp {
 		log.Println("Listening http://" + listener.Addr().String())